@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"observaquiz_lambda/pkg/instrumentation"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+type apiHandler func(context.Context, events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error)
+
+// Admin endpoints are for booth staff. They need the admin key in a header,
+// and they don't exist at all unless admin_api_key is configured.
+func adminOnly(handler apiHandler) apiHandler {
+	return func(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+		span := oteltrace.SpanFromContext(currentContext)
+		if settings.AdminApiKey == "" {
+			span.SetAttributes(attribute.String("app.admin.rejected", "admin endpoints are not configured"))
+			return instrumentation.ErrorResponse("Admin endpoints are not enabled", 404), nil
+		}
+		providedKey := getHeader(request, ADMIN_API_KEY_HEADER)
+		if subtle.ConstantTimeCompare([]byte(providedKey), []byte(settings.AdminApiKey)) != 1 {
+			span.SetAttributes(attribute.String("app.admin.rejected", "wrong admin key"))
+			return instrumentation.ErrorResponse("Not allowed", 403), nil
+		}
+		span.SetAttributes(attribute.Bool("app.admin", true))
+		return handler(currentContext, request)
+	}
+}
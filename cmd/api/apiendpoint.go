@@ -16,6 +16,33 @@ var api = ApiHolder{
 		postAnswerEndpoint,
 		queryDataEndpoint,
 		postOpinionEndpoint,
+		postAnswerStreamEndpoint.buffered(),
+		getCostsEndpoint,
+		getModerationEndpoint,
+		postConversationEndpoint,
+		postCalibrationEndpoint,
+		postEvaluationEndpoint,
+		getLeaderboardEndpoint,
+		postNicknameEndpoint,
+		postSessionEndpoint,
+		getSessionSummaryEndpoint,
+		getSessionReportEndpoint,
+		getSessionEndpoint,
+		putPrizeTiersEndpoint,
+		getPrizesEndpoint,
+		postPrizeDrawEndpoint,
+		getPrizeDrawEndpoint,
+		getExportEndpoint,
+		deleteAttendeeDataEndpoint,
+		postLeadEndpoint,
+		deleteLeadEndpoint,
+		getLeadsExportEndpoint,
+		getLeadsEndpoint,
+		postLeadWithdrawalEndpoint,
+		postLeadRedeliveryEndpoint,
+	},
+	streamingEndpoints: []streamingEndpoint{
+		postAnswerStreamEndpoint,
 	},
 }
 
@@ -28,7 +55,8 @@ type apiEndpoint struct {
 }
 
 type ApiHolder struct {
-	apiEndpoints []apiEndpoint
+	apiEndpoints       []apiEndpoint
+	streamingEndpoints []streamingEndpoint // these are also in apiEndpoints, buffered, for when we can't stream
 }
 
 func getResponseFromHandler(currentContext context.Context, endpoint apiEndpoint, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
@@ -51,3 +79,13 @@ func (api ApiHolder) findEndpoint(method string, path string) (apiEndpoint, bool
 
 	return apiEndpoint{}, false
 }
+
+func (api ApiHolder) findStreamingEndpoint(method string, path string) (streamingEndpoint, bool) {
+	for _, v := range api.streamingEndpoints {
+		if v.method == method && v.pathRegex.MatchString(path) {
+			return v, true
+		}
+	}
+
+	return streamingEndpoint{}, false
+}
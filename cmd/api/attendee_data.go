@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"observaquiz_lambda/cmd/api/queryData"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * We keep what attendees wrote, and a hash of their Honeycomb API key. Two ways it goes away:
+ *
+ *    DELETE /api/attendee-data     with x-honeycomb-api-key: every session started (POST /api/sessions) or answered with that key, in every event
+ *    retention_days=N              sessions nobody has touched in N days, and drawings older than that, checked at most hourly
+ *
+ * Sessions answered without any key on them can only go by retention.
+ * Either way, what this instance remembers about them in memory goes too (forgetAttendeeInMemory),
+ * and a lead of theirs is withdrawn, so a CRM that had it hears it's gone (lead_delivery.go).
+ *
+ * Every deleted session gets its own span, "delete attendee session", with why, which session, and how much went:
+ * that's the audit trail. The key is recorded only as its hash, the same one CreateAndRunHoneycombQuery filters on.
+ */
+
+const (
+	DELETION_REASON_ATTENDEE_REQUEST = "attendee_request"
+	DELETION_REASON_RETENTION        = "retention"
+
+	retentionCheckInterval = time.Hour
+)
+
+var deleteAttendeeDataEndpoint = apiEndpoint{
+	"DELETE",
+	"/api/attendee-data",
+	regexp.MustCompile("^/api/attendee-data$"),
+	deleteAttendeeData,
+	false, // all of their events
+}
+
+type DeleteAttendeeDataResponse struct {
+	DeletedSessionQty int                    `json:"deleted_session_qty"`
+	DeletedAnswerQty  int                    `json:"deleted_answer_qty"`
+	Sessions          []store.DeletedSession `json:"sessions"`
+}
+
+func deleteAttendeeData(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	attendeeApiKey := getHeader(request, ATTENDEE_API_KEY_HEADER)
+	if strings.TrimSpace(attendeeApiKey) == "" {
+		return instrumentation.ErrorResponse(fmt.Sprintf("Send the %s header: we delete what was done with that key", ATTENDEE_API_KEY_HEADER), 400), nil
+	}
+	attendeeKeyHash := queryData.HashAttendeeApiKey(attendeeApiKey)
+
+	currentContext, span := tracer.Start(currentContext, "delete attendee data")
+	defer span.End()
+	span.SetAttributes(attribute.String("app.deletion.reason", DELETION_REASON_ATTENDEE_REQUEST),
+		attribute.String("app.deletion.attendee_key_hash", attendeeKeyHash))
+
+	deletion, err := deleteWithdrawingLeads(currentContext, func() (store.Deletion, error) {
+		return attendeeStore.DeleteAttendee(currentContext, attendeeKeyHash)
+	})
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't delete your data. Nothing was deleted; please try again", 500), nil
+	}
+	auditDeletion(currentContext, DELETION_REASON_ATTENDEE_REQUEST, attendeeKeyHash, deletion)
+	for _, session := range deletion.Sessions {
+		forgetAttendeeInMemory(session.EventName, session.ExecutionId)
+	}
+
+	responseJson, _ := json.Marshal(DeleteAttendeeDataResponse{
+		DeletedSessionQty: len(deletion.Sessions),
+		DeletedAnswerQty:  deletion.AnswerQty(),
+		Sessions:          deletion.Sessions,
+	})
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(responseJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
+
+// one span per deleted session, so each deletion can be found on its own
+func auditDeletion(currentContext context.Context, reason string, attendeeKeyHash string, deletion store.Deletion) {
+	span := trace.SpanFromContext(currentContext)
+	span.SetAttributes(attribute.Int("app.deletion.sessions_qty", len(deletion.Sessions)),
+		attribute.Int("app.deletion.answers_qty", deletion.AnswerQty()),
+		attribute.Int("app.deletion.drawings_qty", deletion.DrawingQty),
+		attribute.Int("app.deletion.scrubbed_drawings_qty", deletion.ScrubbedDrawingQty),
+		attribute.Int("app.deletion.withdrawn_leads_qty", deletion.WithdrawnLeadQty))
+	for _, session := range deletion.Sessions {
+		_, sessionSpan := tracer.Start(currentContext, "delete attendee session")
+		sessionSpan.SetAttributes(attribute.String("app.deletion.reason", reason),
+			attribute.String("app.deletion.event_name", session.EventName),
+			attribute.String("app.deletion.execution_id", session.ExecutionId),
+			attribute.Int("app.deletion.answers_qty", session.AnswerQty),
+			attribute.Int("app.deletion.opinions_qty", session.OpinionQty),
+			attribute.String("app.deletion.session_started_at", session.StartedAt.Format(time.RFC3339)),
+			attribute.String("app.deletion.session_updated_at", session.UpdatedAt.Format(time.RFC3339)))
+		if attendeeKeyHash != "" {
+			sessionSpan.SetAttributes(attribute.String("app.deletion.attendee_key_hash", attendeeKeyHash))
+		}
+		sessionSpan.End()
+	}
+}
+
+// The store withdraws the leads of the sessions it deletes. leadLock keeps a delivery result from landing on one halfway,
+// and the withdrawals go to the CRM straight away.
+func deleteWithdrawingLeads(currentContext context.Context, deleteSessions func() (store.Deletion, error)) (store.Deletion, error) {
+	leadLock.Lock()
+	deletion, err := deleteSessions()
+	leadLock.Unlock()
+	if err == nil && deletion.WithdrawnLeadQty > 0 {
+		leadDelivery.deliverSoon(currentContext)
+	}
+	return deletion, err
+}
+
+// What this instance remembers outside the store: the moderation review list, conversations, reports,
+// retried answers (with the responses we gave), and what copy detection compares new answers with.
+func forgetAttendeeInMemory(eventName string, executionId string) {
+	moderationLog.forget(eventName, executionId)
+	conversations.forget(eventName, executionId)
+	sessionReports.forget(eventName, executionId)
+	answerDeduplication.forget(eventName, executionId)
+	copyMemory.forget(eventName, executionId)
+}
+
+/* retention */
+
+type retentionPolicy struct {
+	lock        sync.Mutex
+	days        int // 0 keeps everything
+	lastChecked time.Time
+	now         func() time.Time
+}
+
+var attendeeDataRetention = &retentionPolicy{now: time.Now} // main() sets days from retention_days
+
+// purgeIfDue runs in whichever request comes along after retentionCheckInterval. Lambda has nothing better to run it on.
+func (p *retentionPolicy) purgeIfDue(currentContext context.Context) {
+	p.lock.Lock()
+	now := p.now()
+	if p.days <= 0 || now.Sub(p.lastChecked) < retentionCheckInterval {
+		p.lock.Unlock()
+		return
+	}
+	p.lastChecked = now
+	days := p.days
+	p.lock.Unlock()
+
+	// its own trace, linked to the request that happened to run it
+	currentContext, span := tracer.Start(currentContext, "purge expired attendee data",
+		trace.WithNewRoot(), trace.WithLinks(trace.LinkFromContext(currentContext)))
+	defer span.End()
+	cutoff := now.Add(-time.Duration(days) * 24 * time.Hour).UTC()
+	span.SetAttributes(attribute.String("app.deletion.reason", DELETION_REASON_RETENTION),
+		attribute.Int("app.deletion.retention_days", days),
+		attribute.String("app.deletion.cutoff", cutoff.Format(time.RFC3339)))
+
+	deletion, err := deleteWithdrawingLeads(currentContext, func() (store.Deletion, error) {
+		return attendeeStore.DeleteBefore(currentContext, cutoff)
+	})
+	if err != nil {
+		span.RecordError(err)
+		p.lock.Lock()
+		p.lastChecked = time.Time{} // try again next request
+		p.lock.Unlock()
+		return
+	}
+	auditDeletion(currentContext, DELETION_REASON_RETENTION, "", deletion)
+	for _, session := range deletion.Sessions {
+		forgetAttendeeInMemory(session.EventName, session.ExecutionId)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"observaquiz_lambda/cmd/api/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// a store of its own, because deleting things would pull the rug out from under the other tests
+func withFreshStore(t *testing.T) {
+	t.Helper()
+	usual := attendeeStore
+	attendeeStore = store.NewMemoryStore()
+	t.Cleanup(func() { attendeeStore = usual })
+}
+
+func rememberedInMemory(executionId string) (idempotency bool, copyDetection bool) {
+	answerDeduplication.lock.Lock()
+	for key := range answerDeduplication.attempts {
+		idempotency = idempotency || strings.Contains(key, "\x00"+executionId+"\x00")
+	}
+	answerDeduplication.lock.Unlock()
+
+	copyMemory.lock.Lock()
+	for _, answer := range copyMemory.recent[testEventName+"/"+v1QuestionId] {
+		copyDetection = copyDetection || answer.executionId == executionId
+	}
+	for submitter := range copyMemory.submissions {
+		copyDetection = copyDetection || strings.HasPrefix(submitter, executionId+"@")
+	}
+	copyMemory.lock.Unlock()
+	return idempotency, copyDetection
+}
+
+func TestDeletingAnAttendeeFindsSessionsTheyOnlyAnsweredWith(t *testing.T) {
+	withFreshStore(t)
+	executionId := "made-up-and-deleted"
+	postTestAnswer(t, v1QuestionId, executionId, replayedV1Answer)
+	if idempotency, copyDetection := rememberedInMemory(executionId); !idempotency || !copyDetection {
+		t.Fatalf("expected the answer to be remembered before deleting it: idempotency %v, copy detection %v", idempotency, copyDetection)
+	}
+
+	request := events.APIGatewayV2HTTPRequest{Headers: map[string]string{ATTENDEE_API_KEY_HEADER: "test-key-" + executionId}}
+	response, _ := deleteAttendeeData(context.Background(), request)
+	deleted := DeleteAttendeeDataResponse{}
+	json.Unmarshal([]byte(response.Body), &deleted)
+	if response.StatusCode != 200 || deleted.DeletedSessionQty != 1 || deleted.DeletedAnswerQty != 1 {
+		t.Fatalf("expected their one session and answer deleted, got %d: %s", response.StatusCode, response.Body)
+	}
+
+	if _, found, _ := attendeeStore.Session(context.Background(), testEventName, executionId); found {
+		t.Errorf("the session is still in the store")
+	}
+	if idempotency, copyDetection := rememberedInMemory(executionId); idempotency || copyDetection {
+		t.Errorf("still remembered in memory: idempotency %v, copy detection %v", idempotency, copyDetection)
+	}
+}
+
+func TestRetentionForgetsWhatItDeletes(t *testing.T) {
+	withFreshStore(t)
+	executionId := "kept-too-long"
+	postTestAnswer(t, v1QuestionId, executionId, replayedV1Answer)
+
+	retention := &retentionPolicy{days: 1, now: func() time.Time { return time.Now().Add(48 * time.Hour) }}
+	retention.purgeIfDue(context.Background())
+
+	if _, found, _ := attendeeStore.Session(context.Background(), testEventName, executionId); found {
+		t.Errorf("a session older than retention_days is still in the store")
+	}
+	if idempotency, copyDetection := rememberedInMemory(executionId); idempotency || copyDetection {
+		t.Errorf("still remembered in memory: idempotency %v, copy detection %v", idempotency, copyDetection)
+	}
+}
+
+func TestStreamedRequestsPurgeToo(t *testing.T) {
+	withFreshStore(t)
+	executionId := "kept-too-long-streamed"
+	postTestAnswer(t, v1QuestionId, executionId, replayedV1Answer)
+
+	usual := attendeeDataRetention
+	attendeeDataRetention = &retentionPolicy{days: 1, now: func() time.Time { return time.Now().Add(48 * time.Hour) }}
+	t.Cleanup(func() { attendeeDataRetention = usual })
+
+	// anything at all on the stream path; it doesn't matter that there's no such question
+	request := httptest.NewRequest("POST", "/api/questions/no-such-question/answer/stream", strings.NewReader(`{"answer": "hi"}`))
+	request.Header.Set("event-name", testEventName)
+	observaquizHttpHandler{}.ServeHTTP(httptest.NewRecorder(), request)
+
+	if _, found, _ := attendeeStore.Session(context.Background(), testEventName, executionId); found {
+		t.Errorf("a streamed request didn't run the retention purge")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"observaquiz_lambda/cmd/api/store"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Every answer and opinion goes into the attendee store, keyed by event and execution id,
+ * with a hash of the attendee's API key so DELETE /api/attendee-data can find it.
+ * Ones without an execution id don't: there's no one to keep them for.
+ *
+ * store=memory   (the default) each instance keeps its own, until it goes away
+ * store=file     one line of JSON per answer or opinion, appended to store_path, read back at startup
+ */
+
+const (
+	STORE_MEMORY       = "memory"
+	STORE_FILE         = "file"
+	default_store_path = "observaquiz_store.jsonl"
+)
+
+var attendeeStore store.Store = store.NewMemoryStore() // main() replaces this with the configured one
+
+func chooseStore(setting string, path string) store.Store {
+	switch setting {
+	case STORE_FILE:
+		if path == "" {
+			path = default_store_path
+		}
+		fileStore, err := store.OpenFileStore(path)
+		if err != nil {
+			// better to run the booth without history than not at all
+			fmt.Printf("Could not open the attendee store at %s, keeping it in memory instead: %v\n", path, err)
+			return store.NewMemoryStore()
+		}
+		return fileStore
+	default:
+		return store.NewMemoryStore()
+	}
+}
+
+// The attendee already has their answer. If we can't write it down, that's our problem, not theirs.
+func recordAnswer(currentContext context.Context, eventName string, questionId string, executionId string, attendeeKeyHash string, answer AnswerBody, llmResponse *responseToAnswer) {
+	span := trace.SpanFromContext(currentContext)
+	if executionId == "unset" {
+		// without an execution id, everyone's answers would pile up in one session, and it would top the leaderboard
+		span.SetAttributes(attribute.String("app.store.skipped", "no execution id"))
+		return
+	}
+	stored := store.Answer{
+		EventName:        eventName,
+		ExecutionId:      executionId,
+		QuestionId:       questionId,
+		Answer:           answer.Answer,
+		Response:         llmResponse.response,
+		Score:            llmResponse.score,
+		PossibleScore:    llmResponse.possibleScore,
+		PartialScore:     llmResponse.partialScore,
+		RawScore:         llmResponse.rawScore,
+		RawPossibleScore: llmResponse.rawPossibleScore,
+		EvaluationId:     llmResponse.evaluationId,
+		Category:         llmResponse.category,
+		Flags:            llmResponse.flags,
+		AttendeeKeyHash:  attendeeKeyHash,
+		AnsweredAt:       time.Now().UTC(),
+	}
+	for _, part := range llmResponse.scoreParts {
+		stored.Components = append(stored.Components, store.ScoreComponent{
+			Description:   part.description,
+			Status:        part.status,
+			Score:         part.score,
+			MaximumScore:  part.possibleScore,
+			Confidence:    part.confidence,
+			Reasoning:     part.reasoning,
+			MatchedWords:  part.matchedWords,
+			PenaltyWords:  part.penaltyWords,
+			Samples:       part.samples,
+			LowConfidence: part.lowConfidence,
+		})
+	}
+	err := attendeeStore.SaveAnswer(currentContext, stored)
+	span.SetAttributes(attribute.Bool("app.store.saved", err == nil))
+	if err != nil {
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure saving answer to the attendee store")))
+	}
+}
+
+func recordOpinion(currentContext context.Context, eventName string, executionId string, attendeeKeyHash string, evaluationId string, opinion Opinion) {
+	span := trace.SpanFromContext(currentContext)
+	if executionId == "unset" {
+		span.SetAttributes(attribute.String("app.store.skipped", "no execution id"))
+		return
+	}
+	err := attendeeStore.SaveOpinion(currentContext, store.Opinion{
+		EventName:       eventName,
+		ExecutionId:     executionId,
+		EvaluationId:    evaluationId,
+		Opinion:         string(opinion),
+		AttendeeKeyHash: attendeeKeyHash,
+		GivenAt:         time.Now().UTC(),
+	})
+	span.SetAttributes(attribute.Bool("app.store.saved", err == nil))
+	if err != nil {
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure saving opinion to the attendee store")))
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAnswersWithoutAnExecutionIdAreNotKept(t *testing.T) {
+	recordAnswer(context.Background(), testEventName, v1QuestionId, "unset", "", AnswerBody{Answer: "anonymous"}, &responseToAnswer{score: 90, possibleScore: 100})
+	recordOpinion(context.Background(), testEventName, "unset", "", "evaluation", Opinion("good"))
+
+	_, found, err := attendeeStore.Session(context.Background(), testEventName, "unset")
+	if err != nil || found {
+		t.Errorf("expected no session for answers without an execution id, found %v (%v)", found, err)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"observaquiz_lambda/cmd/api/costs"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sourcegraph/conc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Anchors are answers we've already scored, so the scoring prompt has something to be consistent with.
+ *
+ *    "anchors": [ { "answer": "we wait for customers to complain", "score": 3, "tolerance": 3 } ]
+ *
+ * They go into the scoring prompt where it says ANCHORS, or at the end if it doesn't.
+ *
+ * They're also a check on the scorer: POST /api/admin/calibration scores every anchor in the event,
+ * and reports any that come out more than their tolerance (default 15% of the maximum score) from where they should.
+ * Each anchor is left out of the prompt that scores it, or the LLM would just copy its score.
+ * When a model upgrade changes how it scores, this is where we find out. With run_mode=server,
+ * calibrate_on_startup=true runs it at startup too. A Lambda doesn't: every cold start would pay for it,
+ * and the instance can be frozen before it finishes.
+ */
+
+const default_anchor_tolerance = 0.15 // of the maximum score
+
+type ScoringAnchor struct {
+	Answer    string `json:"answer"`
+	Score     int    `json:"score"`
+	Tolerance *int   `json:"tolerance"` // how far off is still OK. Default is 15% of the maximum score
+	Reasoning string `json:"reasoning"` // optional. Shown to the LLM with the anchor
+}
+
+func (anchor ScoringAnchor) tolerance(maximumScore int) int {
+	if anchor.Tolerance != nil {
+		return *anchor.Tolerance
+	}
+	return int(math.Ceil(default_anchor_tolerance * float64(maximumScore)))
+}
+
+// the scoring prompt, with its anchors in it
+func (scoringPrompt ScoringPrompt) promptWithAnchors() string {
+	if len(scoringPrompt.Anchors) == 0 {
+		return scoringPrompt.Prompt
+	}
+	description := strings.Builder{}
+	description.WriteString("Here are answers that have already been scored. Score consistently with them.\n")
+	for _, anchor := range scoringPrompt.Anchors {
+		description.WriteString(fmt.Sprintf("- answer: ```\n%s\n```\n  score: %d\n", anchor.Answer, anchor.Score))
+		if anchor.Reasoning != "" {
+			description.WriteString(fmt.Sprintf("  reasoning: %s\n", anchor.Reasoning))
+		}
+	}
+	if strings.Contains(scoringPrompt.Prompt, "ANCHORS") {
+		return strings.Replace(scoringPrompt.Prompt, "ANCHORS", description.String(), -1)
+	}
+	return scoringPrompt.Prompt + "\n\n" + description.String()
+}
+
+// the scoring prompt without one of its anchors. That's how we calibrate that anchor:
+// with its own answer and score in the prompt, the LLM would copy the score, and it would never look drifted.
+func (scoringPrompt ScoringPrompt) withoutAnchor(index int) ScoringPrompt {
+	scoringPrompt.Anchors = append(append([]ScoringAnchor{}, scoringPrompt.Anchors[:index]...), scoringPrompt.Anchors[index+1:]...)
+	return scoringPrompt
+}
+
+/* the self-check */
+
+var postCalibrationEndpoint = apiEndpoint{
+	"POST",
+	"/api/admin/calibration",
+	regexp.MustCompile("^/api/admin/calibration$"),
+	adminOnly(postCalibration),
+	true,
+}
+
+type CalibrationReport struct {
+	EventName  string              `json:"event_name"`
+	Anchors    []CalibrationResult `json:"anchors"`
+	DriftedQty int                 `json:"drifted_qty"`
+	FailedQty  int                 `json:"failed_qty"`
+}
+
+type CalibrationResult struct {
+	QuestionId    string `json:"question_id"`
+	ScoringPrompt string `json:"scoring_prompt"`
+	Answer        string `json:"answer"`
+	ExpectedScore int    `json:"expected_score"`
+	ActualScore   int    `json:"actual_score"`
+	Tolerance     int    `json:"tolerance"`
+	Drifted       bool   `json:"drifted"`
+	Status        string `json:"status"` // scored or failed
+	Reasoning     string `json:"reasoning"`
+}
+
+func postCalibration(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	eventName := getEventName(request)
+	report := calibrate(currentContext, eventName)
+
+	reportJson, err := json.Marshal(report)
+	if err != nil {
+		trace.SpanFromContext(currentContext).RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(reportJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
+
+// calibrate scores every anchor of every v2 question in the event, and says which ones drifted.
+// Each anchor is scored by its prompt with the other anchors in it, but not itself.
+func calibrate(currentContext context.Context, eventName string) CalibrationReport {
+	currentContext, span := tracer.Start(currentContext, "calibrate scoring prompts")
+	defer span.End()
+	currentContext = costs.WithScope(currentContext, costs.Scope{EventName: eventName, ExecutionId: "calibration", AttendeeKeyHash: "calibration"})
+	currentContext = withLlmPriority(currentContext, llmPriorityScoring)
+	llmApi := newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey)
+
+	report := CalibrationReport{EventName: eventName, Anchors: []CalibrationResult{}}
+	var lock sync.Mutex
+	var wg conc.WaitGroup
+	for _, question := range eventQuestions[eventName] {
+		if question.Version != "v2" {
+			continue
+		}
+		for _, scoringPrompt := range question.Scoring.ScoringPrompts {
+			for i, anchor := range scoringPrompt.Anchors {
+				question, scoringPrompt, anchor := question, scoringPrompt.withoutAnchor(i), anchor
+				wg.Go(func() {
+					result := calibrateAnchor(currentContext, llmApi, question, scoringPrompt, anchor)
+					lock.Lock()
+					defer lock.Unlock()
+					report.Anchors = append(report.Anchors, result)
+				})
+			}
+		}
+	}
+	wg.Wait()
+
+	for _, result := range report.Anchors {
+		if result.Status != SCORE_STATUS_SCORED {
+			report.FailedQty++
+		} else if result.Drifted {
+			report.DriftedQty++
+		}
+	}
+	span.SetAttributes(attribute.String("app.calibration.event_name", eventName),
+		attribute.Int("app.calibration.anchors_qty", len(report.Anchors)),
+		attribute.Int("app.calibration.drifted_qty", report.DriftedQty),
+		attribute.Int("app.calibration.failed_qty", report.FailedQty),
+		attribute.Bool("app.calibration.drifted", report.DriftedQty > 0))
+	return report
+}
+
+func calibrateAnchor(currentContext context.Context, llmApi *openaiApi, question Question, scoringPrompt ScoringPrompt, anchor ScoringAnchor) CalibrationResult {
+	currentContext, span := tracer.Start(currentContext, "calibrate anchor")
+	defer span.End()
+
+	substitutions := map[string]string{
+		"THEIR ANSWER": anchor.Answer,
+		"QUESTION":     question.Question,
+	}
+	scored := scoreWithPrompt(currentContext, llmApi, AnswerBody{Answer: anchor.Answer}, substitutions, scoringPrompt)
+	result := CalibrationResult{
+		QuestionId:    question.Id.String(),
+		ScoringPrompt: scoringPrompt.Description,
+		Answer:        anchor.Answer,
+		ExpectedScore: anchor.Score,
+		ActualScore:   scored.score,
+		Tolerance:     anchor.tolerance(scoringPrompt.MaximumScore),
+		Status:        scored.status,
+		Reasoning:     scored.reasoning,
+	}
+	result.Drifted = scored.status == SCORE_STATUS_SCORED && absoluteDifference(scored.score, anchor.Score) > result.Tolerance
+
+	span.SetAttributes(attribute.String("app.calibration.question_id", result.QuestionId),
+		attribute.String("app.calibration.scoring_prompt", result.ScoringPrompt),
+		attribute.Int("app.calibration.expected_score", result.ExpectedScore),
+		attribute.Int("app.calibration.actual_score", result.ActualScore),
+		attribute.Int("app.calibration.tolerance", result.Tolerance),
+		attribute.Bool("app.calibration.drifted", result.Drifted))
+	return result
+}
+
+// for calibrate_on_startup, in server mode. Prints what drifted, so it shows up in the logs too.
+func calibrateAllEvents(currentContext context.Context) {
+	for eventName := range eventQuestions {
+		report := calibrate(currentContext, eventName)
+		if len(report.Anchors) == 0 {
+			continue
+		}
+		fmt.Printf("Calibration for %s: %d anchors, %d drifted, %d failed\n", eventName, len(report.Anchors), report.DriftedQty, report.FailedQty)
+		for _, result := range report.Anchors {
+			if result.Drifted {
+				fmt.Printf("  drifted: %s / %s: expected %d (±%d), got %d for %q\n", result.QuestionId, result.ScoringPrompt, result.ExpectedScore, result.Tolerance, result.ActualScore, result.Answer)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCalibratingAnAnchorLeavesItOutOfThePrompt(t *testing.T) {
+	scoringPrompt := ScoringPrompt{Prompt: "Score it.", MaximumScore: 20, Anchors: []ScoringAnchor{
+		{Answer: "we wait for customers to complain", Score: 2},
+		{Answer: "we trace every request", Score: 18},
+		{Answer: "we grep the logs", Score: 6},
+	}}
+
+	prompt := scoringPrompt.withoutAnchor(1).promptWithAnchors()
+	if strings.Contains(prompt, "we trace every request") {
+		t.Errorf("the anchor being calibrated is in its own prompt: %s", prompt)
+	}
+	if !strings.Contains(prompt, "we wait for customers to complain") || !strings.Contains(prompt, "we grep the logs") {
+		t.Errorf("the other anchors should still be there: %s", prompt)
+	}
+	if len(scoringPrompt.Anchors) != 3 || scoringPrompt.Anchors[1].Answer != "we trace every request" {
+		t.Errorf("leaving an anchor out shouldn't change the question's anchors: %+v", scoringPrompt.Anchors)
+	}
+}
+
+func TestAnchorsGoWhereThePromptSays(t *testing.T) {
+	anchors := []ScoringAnchor{{Answer: "we grep the logs", Score: 6, Reasoning: "logs, but nothing else"}}
+	placed := ScoringPrompt{Prompt: "Score it.\nANCHORS\nTheir answer: THEIR ANSWER", Anchors: anchors}.promptWithAnchors()
+	if !strings.Contains(placed, "Score it.\nHere are answers") || !strings.HasSuffix(placed, "Their answer: THEIR ANSWER") {
+		t.Errorf("expected the anchors where it says ANCHORS: %s", placed)
+	}
+	if !strings.Contains(placed, "reasoning: logs, but nothing else") {
+		t.Errorf("expected the anchor's reasoning: %s", placed)
+	}
+	appended := ScoringPrompt{Prompt: "Score it.", Anchors: anchors}.promptWithAnchors()
+	if !strings.HasPrefix(appended, "Score it.\n\nHere are answers") {
+		t.Errorf("expected the anchors at the end: %s", appended)
+	}
+	if plain := (ScoringPrompt{Prompt: "Score it."}).promptWithAnchors(); plain != "Score it." {
+		t.Errorf("without anchors, the prompt is as it was: %s", plain)
+	}
+}
+
+const calibrationTestEventName = "calibration_test"
+
+// The real questions don't have anchors until people have agreed what they should score.
+// This event is the devopsdays question, with anchors on its first scoring prompt; its scores are in testdata/llm_fixtures
+func withCalibrationTestEvent(t *testing.T) {
+	t.Helper()
+	question, found := findQuestion(testEventName, v2QuestionId)
+	if !found {
+		t.Fatalf("the v2 question is missing")
+	}
+	scoringPrompt := question.Scoring.ScoringPrompts[0]
+	scoringPrompt.Prompt = strings.Replace(scoringPrompt.Prompt, "Their answer: ", "ANCHORS\nTheir answer: ", 1)
+	none := 0
+	scoringPrompt.Anchors = []ScoringAnchor{
+		{Answer: "We find out when customers complain, and then we read the code.", Score: 10},
+		{Answer: "Every service sends OpenTelemetry traces to Honeycomb, and SLOs alert us when users are having a bad time. It would be clearer if our old logs were on the traces too.", Score: 20},
+		{Answer: "I like turtles", Score: 0, Tolerance: &none},
+	}
+	question.Scoring.ScoringPrompts = []ScoringPrompt{scoringPrompt}
+	eventQuestions[calibrationTestEventName] = []Question{question}
+	t.Cleanup(func() { delete(eventQuestions, calibrationTestEventName) })
+}
+
+func TestCalibrationReportsDriftedAnchors(t *testing.T) {
+	withCalibrationTestEvent(t)
+	report := calibrate(context.Background(), calibrationTestEventName)
+
+	if len(report.Anchors) != 3 || report.FailedQty != 0 {
+		t.Fatalf("expected all three anchors scored: %+v", report)
+	}
+	drifted := []string{}
+	for _, result := range report.Anchors {
+		if result.Drifted {
+			drifted = append(drifted, result.Answer)
+		}
+	}
+	if report.DriftedQty != 1 || len(drifted) != 1 || drifted[0] != "I like turtles" {
+		t.Errorf("expected only the turtles, with no tolerance, to drift: %+v", report)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/sourcegraph/conc"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/**
+ * Ask the same scoring prompt the same question twice, and you can get 5 and then 15.
+ * With consensus, a scoring prompt asks several times and combines the answers:
+ *
+ *    "consensus": { "samples": 3, "combine": "median", "temperatures": [0.2, 0.7, 1.0] }
+ *
+ * combine is median (the default), mean, or trimmed_mean (drop the highest and lowest, then the mean).
+ * temperatures and seeds, if given, go to each sample in turn. A temperature of 0 goes to OpenAI as 0.0001:
+ * go-openai leaves out a zero temperature, and then OpenAI uses its default of 1, the opposite of what 0 asked for.
+ * When the samples' standard deviation is more than low_confidence_spread (default 0.15) of the maximum score,
+ * the score is marked low confidence.
+ */
+
+const (
+	COMBINE_MEDIAN       = "median"
+	COMBINE_MEAN         = "mean"
+	COMBINE_TRIMMED_MEAN = "trimmed_mean"
+
+	default_low_confidence_spread = 0.15
+	maximumConsensusSamples       = 7 // they all cost money
+
+	zeroTemperature float32 = 0.0001 // as close to 0 as go-openai will send
+)
+
+type ConsensusConfig struct {
+	Samples             int       `json:"samples"` // 1 (the default) for no consensus
+	Combine             string    `json:"combine"`
+	Temperatures        []float32 `json:"temperatures"`
+	Seeds               []int     `json:"seeds"`
+	LowConfidenceSpread float64   `json:"low_confidence_spread"`
+}
+
+func (config ConsensusConfig) samples() int {
+	if config.Samples < 1 {
+		return 1
+	}
+	if config.Samples > maximumConsensusSamples {
+		return maximumConsensusSamples
+	}
+	return config.Samples
+}
+
+func (config ConsensusConfig) lowConfidenceSpread() float64 {
+	if config.LowConfidenceSpread <= 0 {
+		return default_low_confidence_spread
+	}
+	return config.LowConfidenceSpread
+}
+
+// the i-th sample's request: the same prompt, with its own temperature and seed
+func (config ConsensusConfig) sampleRequest(request chatRequest, i int) chatRequest {
+	if len(config.Temperatures) > 0 {
+		temperature := config.Temperatures[i%len(config.Temperatures)]
+		request.temperature = &temperature
+	}
+	if len(config.Seeds) > 0 {
+		seed := config.Seeds[i%len(config.Seeds)]
+		request.seed = &seed
+	}
+	return request
+}
+
+func (config ConsensusConfig) combine(scores []int) int {
+	values := make([]float64, len(scores))
+	for i, score := range scores {
+		values[i] = float64(score)
+	}
+	sort.Float64s(values)
+	switch config.Combine {
+	case COMBINE_MEAN:
+		return int(math.Round(meanOf(values)))
+	case COMBINE_TRIMMED_MEAN:
+		if len(values) > 2 {
+			values = values[1 : len(values)-1]
+		}
+		return int(math.Round(meanOf(values)))
+	default:
+		middle := len(values) / 2
+		if len(values)%2 == 0 {
+			return int(math.Round((values[middle-1] + values[middle]) / 2))
+		}
+		return int(values[middle])
+	}
+}
+
+func meanOf(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+func standardDeviationOf(scores []int) float64 {
+	values := make([]float64, len(scores))
+	for i, score := range scores {
+		values[i] = float64(score)
+	}
+	average := meanOf(values)
+	total := 0.0
+	for _, v := range values {
+		total += (v - average) * (v - average)
+	}
+	return math.Sqrt(total / float64(len(values)))
+}
+
+type scoreSample struct {
+	response ScoreResponse
+	output   chatResult
+	err      error
+}
+
+// sampleScores asks the scoring prompt once per sample, all at once. Each sample gets its own span.
+func sampleScores(currentContext context.Context, llmApi *openaiApi, request chatRequest, scoreComponent ScoringPrompt) []scoreSample {
+	config := scoreComponent.Consensus
+	samples := make([]scoreSample, config.samples())
+	if len(samples) == 1 {
+		samples[0].err = chatForValidJson(currentContext, llmApi, request, scoreSchema(scoreComponent.MaximumScore), &samples[0].output, &samples[0].response)
+		return samples
+	}
+
+	var wg conc.WaitGroup
+	for i := range samples {
+		i := i
+		wg.Go(func() {
+			sampleContext, span := tracer.Start(currentContext, "score sample")
+			defer span.End()
+			span.SetAttributes(attribute.Int("app.score.sample", i))
+			sample := &samples[i]
+			sample.err = chatForValidJson(sampleContext, llmApi, config.sampleRequest(request, i), scoreSchema(scoreComponent.MaximumScore), &sample.output, &sample.response)
+			if sample.err != nil {
+				span.RecordError(sample.err)
+			} else {
+				span.SetAttributes(attribute.Int("app.score.score", sample.response.Score))
+			}
+		})
+	}
+	wg.Wait()
+	return samples
+}
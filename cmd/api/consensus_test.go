@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestCombiningSamples(t *testing.T) {
+	tests := []struct {
+		combine  string
+		scores   []int
+		expected int
+	}{
+		{COMBINE_MEDIAN, []int{15, 5, 10}, 10},
+		{"", []int{15, 5, 10}, 10}, // median is the default
+		{COMBINE_MEDIAN, []int{4, 5, 10, 20}, 8},
+		{COMBINE_MEDIAN, []int{7}, 7},
+		{COMBINE_MEAN, []int{5, 10, 20}, 12},
+		{COMBINE_MEAN, []int{0, 20}, 10},
+		{COMBINE_TRIMMED_MEAN, []int{0, 10, 12, 14, 20}, 12},
+		{COMBINE_TRIMMED_MEAN, []int{20, 0, 10}, 10},
+		{COMBINE_TRIMMED_MEAN, []int{4, 20}, 12}, // nothing to trim with two
+	}
+	for _, test := range tests {
+		if combined := (ConsensusConfig{Combine: test.combine}).combine(test.scores); combined != test.expected {
+			t.Errorf("%q of %v: expected %d, got %d", test.combine, test.scores, test.expected, combined)
+		}
+	}
+}
+
+func TestSampleCount(t *testing.T) {
+	for samples, expected := range map[int]int{-1: 1, 0: 1, 1: 1, 3: 3, 7: 7, 50: maximumConsensusSamples} {
+		if actual := (ConsensusConfig{Samples: samples}).samples(); actual != expected {
+			t.Errorf("%d samples: expected %d, got %d", samples, expected, actual)
+		}
+	}
+}
+
+// scores each sample by its temperature (0.2 is 2), and fails the ones at the temperatures in fail.
+// Keeps the temperatures it was sent; the samples run at once, so that takes a lock
+type temperatureLlmClient struct {
+	lock         *sync.Mutex
+	temperatures *[]float32
+	fail         map[float32]bool
+}
+
+func (c temperatureLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	c.lock.Lock()
+	*c.temperatures = append(*c.temperatures, request.Temperature)
+	c.lock.Unlock()
+	if c.fail[request.Temperature] {
+		return openai.ChatCompletionResponse{}, errors.New("this one doesn't answer")
+	}
+	output := fmt.Sprintf(`{"score": %d, "reasoning": "at %v"}`, int(request.Temperature*10+0.5), request.Temperature)
+	return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: output}}}}, nil
+}
+
+func (c temperatureLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	response, err := c.complete(currentContext, request)
+	if err != nil {
+		return "", err
+	}
+	return response.Choices[0].Message.Content, nil
+}
+
+func temperatureLlmApi(fail ...float32) (*openaiApi, *[]float32) {
+	temperatures := &[]float32{}
+	failing := map[float32]bool{}
+	for _, temperature := range fail {
+		failing[temperature] = true
+	}
+	return &openaiApi{model: "fake", client: temperatureLlmClient{lock: &sync.Mutex{}, temperatures: temperatures, fail: failing}}, temperatures
+}
+
+func consensusPrompt(consensus ConsensusConfig) ScoringPrompt {
+	return ScoringPrompt{Prompt: "score it", MaximumScore: 20, Description: "consensus", Consensus: consensus}
+}
+
+func TestConsensusScoresEachSample(t *testing.T) {
+	llmApi, temperatures := temperatureLlmApi()
+	scored := scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{},
+		consensusPrompt(ConsensusConfig{Samples: 5, Temperatures: []float32{0.2, 0.4, 1.0}}))
+
+	// the temperatures go round: 0.2, 0.4, 1.0, 0.2, 0.4
+	if len(*temperatures) != 5 || scored.status != SCORE_STATUS_SCORED || len(scored.samples) != 5 {
+		t.Fatalf("expected five samples scored: %+v, temperatures %v", scored, *temperatures)
+	}
+	if scored.score != 4 || scored.reasoning != "at 0.4" {
+		t.Errorf("expected the median, 4, with the reasoning of a sample that gave it: %+v", scored)
+	}
+	if scored.spread == 0 || scored.lowConfidence {
+		t.Errorf("expected some spread, but not enough for low confidence: %+v", scored)
+	}
+}
+
+func TestConsensusMarksDisagreementLowConfidence(t *testing.T) {
+	llmApi, _ := temperatureLlmApi()
+	scored := scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{},
+		consensusPrompt(ConsensusConfig{Samples: 2, Combine: COMBINE_MEAN, Temperatures: []float32{0.2, 1.8}}))
+	if scored.score != 10 || !scored.lowConfidence || scored.confidence != "low: the samples disagreed" {
+		t.Errorf("expected 10, low confidence: %+v", scored)
+	}
+}
+
+func TestConsensusWithoutTheFailedSamples(t *testing.T) {
+	llmApi, _ := temperatureLlmApi(1.0)
+	scored := scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{},
+		consensusPrompt(ConsensusConfig{Samples: 3, Combine: COMBINE_MEAN, Temperatures: []float32{0.2, 0.6, 1.0}}))
+	if scored.status != SCORE_STATUS_SCORED || scored.score != 4 || len(scored.samples) != 2 {
+		t.Errorf("expected the mean of the two that answered: %+v", scored)
+	}
+
+	llmApi, _ = temperatureLlmApi(0.2, 0.6)
+	scored = scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{},
+		consensusPrompt(ConsensusConfig{Samples: 2, Temperatures: []float32{0.2, 0.6}}))
+	if scored.status != SCORE_STATUS_FAILED || scored.score != 0 {
+		t.Errorf("expected it to fail when no sample answered: %+v", scored)
+	}
+}
+
+func TestConsensusIsCappedAtSevenSamples(t *testing.T) {
+	llmApi, temperatures := temperatureLlmApi()
+	scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{},
+		consensusPrompt(ConsensusConfig{Samples: 100, Temperatures: []float32{1.0}}))
+	if len(*temperatures) != maximumConsensusSamples {
+		t.Errorf("expected %d calls, got %d", maximumConsensusSamples, len(*temperatures))
+	}
+}
+
+func TestZeroTemperatureIsSent(t *testing.T) {
+	llmApi, temperatures := temperatureLlmApi()
+	scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{},
+		consensusPrompt(ConsensusConfig{Samples: 2, Temperatures: []float32{0, 0.5}}))
+
+	sentZero := false
+	for _, temperature := range *temperatures {
+		if temperature == 0 {
+			t.Errorf("a temperature of 0 would be left out of the request, and OpenAI would use 1")
+		}
+		if temperature == zeroTemperature {
+			sentZero = true
+		}
+	}
+	if !sentZero {
+		t.Errorf("expected 0 sent as %v, got %v", zeroTemperature, *temperatures)
+	}
+
+	llmApi, temperatures = temperatureLlmApi()
+	scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{}, consensusPrompt(ConsensusConfig{}))
+	if (*temperatures)[0] != 0 {
+		t.Errorf("without a temperature, leave it to OpenAI; got %v", *temperatures)
+	}
+}
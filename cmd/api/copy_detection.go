@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"observaquiz_lambda/cmd/api/costs"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Copy detection: is this answer somebody else's, our own example, or a bot?
+ *
+ *  - near_duplicate   it's nearly the same as a recent answer to the same question, from someone else
+ *  - prompt_example   it's mostly text from our own prompts (examples, anchors, instructions)
+ *  - burst            the same attendee, from the same IP, sent more than burst_answers in burst_seconds.
+ *                     Not the IP alone: the whole booth shares one
+ *
+ * Similarity is over 3-word shingles of the normalized text (lowercase, letters and numbers only).
+ * Recent answers are compared with MinHash signatures, so it stays cheap however many there are;
+ * prompt text is compared exactly, as how much of the answer appears in the prompt.
+ * No LLM involved. Short answers ("logs and dashboards") are too easy to write twice, so they're left alone.
+ *
+ * In questions/<event>/event.json:
+ *
+ *    "copy_detection": { "action": "reduce", "score_multiplier": 0.5 }
+ *
+ * action is flag (the default: record it, and put it on the review list at GET /api/admin/moderation),
+ * reduce (flag, and multiply the score by score_multiplier), reject (flag, and refuse the answer, before any LLM calls),
+ * or off. Every flag is an event on the request span.
+ *
+ * Recent answers and bursts are counted in memory, per Lambda instance.
+ */
+
+const (
+	COPY_ACTION_FLAG   = "flag"
+	COPY_ACTION_REDUCE = "reduce"
+	COPY_ACTION_REJECT = "reject"
+	COPY_ACTION_OFF    = "off"
+
+	COPY_FLAG_NEAR_DUPLICATE = "near_duplicate"
+	COPY_FLAG_PROMPT_EXAMPLE = "prompt_example"
+	COPY_FLAG_BURST          = "burst"
+
+	default_copy_similarity_threshold = 0.8
+	default_copy_score_multiplier     = 0.5
+	default_copy_minimum_words        = 8
+	default_copy_recent_answers       = 200 // per question
+	default_copy_burst_answers        = 20  // from one attendee; nobody types that fast
+	default_copy_burst_seconds        = 60
+
+	copyShingleWords  = 3
+	minHashSignatures = 64
+)
+
+const copyRejectedMessage = "That answer looks a lot like one we've already seen. Tell us about your own observability!"
+
+type CopyDetectionConfig struct {
+	Action              string  `json:"action"`               // flag (default), reduce, reject, or off
+	SimilarityThreshold float64 `json:"similarity_threshold"` // 0 to 1. Default 0.8
+	ScoreMultiplier     float64 `json:"score_multiplier"`     // for reduce. Default 0.5
+	MinimumWords        int     `json:"minimum_words"`        // shorter answers aren't compared. Default 8
+	RecentAnswers       int     `json:"recent_answers"`       // how many to compare against, per question. Default 200
+	BurstAnswers        int     `json:"burst_answers"`        // more than this many from one attendee and IP... Default 20
+	BurstSeconds        int     `json:"burst_seconds"`        // ...in this many seconds is a burst. Default 60
+}
+
+func (config CopyDetectionConfig) action() string {
+	if config.Action == "" {
+		return COPY_ACTION_FLAG
+	}
+	return config.Action
+}
+
+func (config CopyDetectionConfig) similarityThreshold() float64 {
+	if config.SimilarityThreshold <= 0 || config.SimilarityThreshold > 1 {
+		return default_copy_similarity_threshold
+	}
+	return config.SimilarityThreshold
+}
+
+func (config CopyDetectionConfig) scoreMultiplier() float64 {
+	if config.ScoreMultiplier <= 0 || config.ScoreMultiplier > 1 {
+		return default_copy_score_multiplier
+	}
+	return config.ScoreMultiplier
+}
+
+func (config CopyDetectionConfig) minimumWords() int {
+	if config.MinimumWords <= 0 {
+		return default_copy_minimum_words
+	}
+	return config.MinimumWords
+}
+
+func (config CopyDetectionConfig) recentAnswers() int {
+	if config.RecentAnswers <= 0 {
+		return default_copy_recent_answers
+	}
+	return config.RecentAnswers
+}
+
+func (config CopyDetectionConfig) burstAnswers() int {
+	if config.BurstAnswers <= 0 {
+		return default_copy_burst_answers
+	}
+	return config.BurstAnswers
+}
+
+func (config CopyDetectionConfig) burstWindow() time.Duration {
+	if config.BurstSeconds <= 0 {
+		return default_copy_burst_seconds * time.Second
+	}
+	return time.Duration(config.BurstSeconds) * time.Second
+}
+
+type copyFlag struct {
+	kind       string
+	similarity float64 // for near_duplicate and prompt_example
+	detail     string
+}
+
+type copyVerdict struct {
+	flags  []copyFlag
+	action string
+}
+
+func (verdict copyVerdict) flagged() bool {
+	return len(verdict.flags) > 0
+}
+
+/* the text */
+
+func normalizeForComparison(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func shingles(words []string) map[string]bool {
+	result := map[string]bool{}
+	if len(words) < copyShingleWords {
+		if len(words) > 0 {
+			result[strings.Join(words, " ")] = true
+		}
+		return result
+	}
+	for i := 0; i+copyShingleWords <= len(words); i++ {
+		result[strings.Join(words[i:i+copyShingleWords], " ")] = true
+	}
+	return result
+}
+
+type minHashSignature [minHashSignatures]uint64
+
+// one 64-bit hash per shingle, remixed once per signature slot
+func minHashOf(shingleSet map[string]bool) minHashSignature {
+	signature := minHashSignature{}
+	for i := range signature {
+		signature[i] = math.MaxUint64
+	}
+	for shingle := range shingleSet {
+		hasher := fnv.New64a()
+		hasher.Write([]byte(shingle))
+		base := hasher.Sum64()
+		for i := range signature {
+			if h := mixHash(base ^ (uint64(i+1) * 0x9e3779b97f4a7c15)); h < signature[i] {
+				signature[i] = h
+			}
+		}
+	}
+	return signature
+}
+
+// splitmix64's finalizer: spreads the bits, so each slot acts like its own hash function
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// estimates the Jaccard similarity of the two shingle sets
+func (signature minHashSignature) similarity(other minHashSignature) float64 {
+	same := 0
+	for i := range signature {
+		if signature[i] == other[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(minHashSignatures)
+}
+
+// how much of the answer is in the prompt: the prompt is much longer, so Jaccard would always be small
+func containment(answer map[string]bool, prompt map[string]bool) float64 {
+	if len(answer) == 0 {
+		return 0
+	}
+	contained := 0
+	for shingle := range answer {
+		if prompt[shingle] {
+			contained++
+		}
+	}
+	return float64(contained) / float64(len(answer))
+}
+
+/* what we've seen */
+
+type recentAnswer struct {
+	executionId string
+	signature   minHashSignature
+}
+
+type copyDetectionMemory struct {
+	lock          sync.Mutex
+	recent        map[string][]recentAnswer // by event and question, oldest first
+	submissions   map[string][]time.Time    // by execution id and IP
+	promptShingle map[string]map[string]bool
+	now           func() time.Time
+}
+
+var copyMemory = &copyDetectionMemory{
+	recent:        map[string][]recentAnswer{},
+	submissions:   map[string][]time.Time{},
+	promptShingle: map[string]map[string]bool{},
+	now:           time.Now,
+}
+
+// counts this submission, and says how many there have been from that IP within the window, including this one
+// submitter is the execution id and the IP together
+func (m *copyDetectionMemory) countSubmission(submitter string, window time.Duration) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	for other, times := range m.submissions {
+		if other != submitter && now.Sub(times[len(times)-1]) >= window {
+			delete(m.submissions, other) // so it doesn't keep everyone it has ever seen
+		}
+	}
+	recent := []time.Time{}
+	for _, at := range m.submissions[submitter] {
+		if now.Sub(at) < window {
+			recent = append(recent, at)
+		}
+	}
+	m.submissions[submitter] = append(recent, now)
+	return len(m.submissions[submitter])
+}
+
+// when their data is deleted: their recent answers, and their submissions (from any IP)
+func (m *copyDetectionMemory) forget(eventName string, executionId string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, answers := range m.recent {
+		if !strings.HasPrefix(key, eventName+"/") {
+			continue
+		}
+		kept := []recentAnswer{}
+		for _, answer := range answers {
+			if answer.executionId != executionId {
+				kept = append(kept, answer)
+			}
+		}
+		m.recent[key] = kept
+	}
+	for submitter := range m.submissions {
+		if strings.HasPrefix(submitter, executionId+"@") {
+			delete(m.submissions, submitter)
+		}
+	}
+}
+
+// the most similar recent answer from someone else; then remember this one
+func (m *copyDetectionMemory) mostSimilarThenRemember(key string, answer recentAnswer, keep int) (best float64, bestExecutionId string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, earlier := range m.recent[key] {
+		if earlier.executionId == answer.executionId && answer.executionId != "unset" {
+			continue // answering again is allowed
+		}
+		if similarity := answer.signature.similarity(earlier.signature); similarity > best {
+			best, bestExecutionId = similarity, earlier.executionId
+		}
+	}
+	m.recent[key] = append(m.recent[key], answer)
+	if len(m.recent[key]) > keep {
+		m.recent[key] = m.recent[key][len(m.recent[key])-keep:]
+	}
+	return best, bestExecutionId
+}
+
+func (m *copyDetectionMemory) promptShinglesFor(eventName string, questionDefinition Question) map[string]bool {
+	key := eventName + "/" + questionDefinition.Id.String()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if cached, ok := m.promptShingle[key]; ok {
+		return cached
+	}
+	texts := []string{questionDefinition.AnswerResponsePrompt.SystemPrompt, questionDefinition.PromptsV2.ResponsePrompt, questionDefinition.PromptsV2.CategoryPrompt}
+	for _, example := range questionDefinition.AnswerResponsePrompt.Examples {
+		texts = append(texts, example.ExampleAnswer, example.ExampleResponse)
+	}
+	for _, scoringPrompt := range questionDefinition.Scoring.ScoringPrompts {
+		texts = append(texts, scoringPrompt.Prompt)
+		for _, anchor := range scoringPrompt.Anchors {
+			texts = append(texts, anchor.Answer)
+		}
+	}
+	promptShingles := map[string]bool{}
+	for _, text := range texts {
+		for shingle := range shingles(normalizeForComparison(text)) {
+			promptShingles[shingle] = true
+		}
+	}
+	m.promptShingle[key] = promptShingles
+	return promptShingles
+}
+
+/* the check */
+
+// detectCopying looks for copies and bursts, records each flag on the span, and says what to do about them.
+func detectCopying(currentContext context.Context, eventName string, questionDefinition Question, sourceIp string, answer AnswerBody) copyVerdict {
+	currentContext, span := tracer.Start(currentContext, "detect copying")
+	defer span.End()
+	config := eventConfigs[eventName].CopyDetection
+	verdict := copyVerdict{action: config.action()}
+	span.SetAttributes(attribute.String("app.copy.action", verdict.action))
+	if verdict.action == COPY_ACTION_OFF {
+		return verdict
+	}
+	executionId := costs.ScopeFrom(currentContext).ExecutionId
+
+	if sourceIp != "" {
+		submissions := copyMemory.countSubmission(executionId+"@"+sourceIp, config.burstWindow())
+		span.SetAttributes(attribute.String("app.copy.source_ip", sourceIp),
+			attribute.Int("app.copy.recent_submissions", submissions))
+		if submissions > config.burstAnswers() {
+			verdict.flags = append(verdict.flags, copyFlag{kind: COPY_FLAG_BURST,
+				detail: fmt.Sprintf("%d answers from execution %s at %s in %s", submissions, executionId, sourceIp, config.burstWindow())})
+		}
+	}
+
+	words := normalizeForComparison(answer.Answer)
+	span.SetAttributes(attribute.Int("app.copy.words", len(words)))
+	if len(words) >= config.minimumWords() {
+		answerShingles := shingles(words)
+
+		fromPrompt := containment(answerShingles, copyMemory.promptShinglesFor(eventName, questionDefinition))
+		span.SetAttributes(attribute.Float64("app.copy.prompt_containment", fromPrompt))
+		if fromPrompt >= config.similarityThreshold() {
+			verdict.flags = append(verdict.flags, copyFlag{kind: COPY_FLAG_PROMPT_EXAMPLE, similarity: fromPrompt,
+				detail: fmt.Sprintf("%.0f%% of it is in our prompts", fromPrompt*100)})
+		}
+
+		similarity, similarTo := copyMemory.mostSimilarThenRemember(eventName+"/"+questionDefinition.Id.String(),
+			recentAnswer{executionId: executionId, signature: minHashOf(answerShingles)}, config.recentAnswers())
+		span.SetAttributes(attribute.Float64("app.copy.max_similarity", similarity))
+		if similarity >= config.similarityThreshold() {
+			verdict.flags = append(verdict.flags, copyFlag{kind: COPY_FLAG_NEAR_DUPLICATE, similarity: similarity,
+				detail: fmt.Sprintf("%.0f%% like a recent answer from execution %s", similarity*100, similarTo)})
+		}
+	}
+
+	requestSpan := trace.SpanFromContext(currentContext)
+	for _, flag := range verdict.flags {
+		flagAttributes := trace.WithAttributes(attribute.String("app.copy.flag", flag.kind),
+			attribute.Float64("app.copy.similarity", flag.similarity),
+			attribute.String("app.copy.detail", flag.detail),
+			attribute.String("app.copy.action", verdict.action))
+		span.AddEvent("copy detection flag", flagAttributes)
+		requestSpan.AddEvent("copy detection flag", flagAttributes)
+		recordCopyFlagForReview(currentContext, span, flag, answer)
+	}
+	span.SetAttributes(attribute.Int("app.copy.flags_qty", len(verdict.flags)))
+	requestSpan.SetAttributes(attribute.Bool("app.copy.flagged", verdict.flagged()),
+		attribute.Int("app.copy.flags_qty", len(verdict.flags)))
+	return verdict
+}
+
+// onto the same list as moderation, so booth staff have one place to look
+func recordCopyFlagForReview(currentContext context.Context, span trace.Span, flag copyFlag, answer AnswerBody) {
+	scope := costs.ScopeFrom(currentContext)
+	moderationLog.add(moderationEvent{
+		Time:        time.Now(),
+		EventName:   scope.EventName,
+		QuestionId:  scope.QuestionId,
+		ExecutionId: scope.ExecutionId,
+		Stage:       "copy detection",
+		Text:        answer.Answer,
+		Reason:      flag.kind + ": " + flag.detail,
+		Moderator:   "copy detection",
+		TraceId:     span.SpanContext().TraceID().String(),
+	})
+}
+
+// applyCopyVerdict reduces the score, if that's what the event wants. (Reject happens before we get this far.)
+func applyCopyVerdict(currentContext context.Context, config CopyDetectionConfig, verdict copyVerdict, response *responseToAnswer) {
+	if !verdict.flagged() || verdict.action != COPY_ACTION_REDUCE {
+		return
+	}
+	reduced := int(math.Round(float64(response.score) * config.scoreMultiplier()))
+	trace.SpanFromContext(currentContext).SetAttributes(attribute.Int("app.copy.score_before_reduction", response.score),
+		attribute.Int("app.copy.reduced_score", reduced))
+	response.score = reduced
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
+	"testing"
+)
+
+func burstFlagged(verdict copyVerdict) bool {
+	for _, flag := range verdict.flags {
+		if flag.kind == COPY_FLAG_BURST {
+			return true
+		}
+	}
+	return false
+}
+
+func detectCopyingFrom(t *testing.T, executionId string, sourceIp string) copyVerdict {
+	currentContext := costs.WithScope(context.Background(), costs.Scope{EventName: testEventName, QuestionId: v1QuestionId, ExecutionId: executionId})
+	return detectCopying(currentContext, testEventName, testQuestion(t, v1QuestionId), sourceIp, AnswerBody{Answer: "logs"})
+}
+
+func TestABusyBoothIsNotABurst(t *testing.T) {
+	for i := 0; i < default_copy_burst_answers*2; i++ {
+		if verdict := detectCopyingFrom(t, fmt.Sprintf("booth-attendee-%d", i), "198.51.100.7"); burstFlagged(verdict) {
+			t.Fatalf("answer %d from a different attendee at the same booth was flagged as a burst", i)
+		}
+	}
+}
+
+func TestOneAttendeeSendingTooManyIsABurst(t *testing.T) {
+	var verdict copyVerdict
+	for i := 0; i <= default_copy_burst_answers; i++ {
+		verdict = detectCopyingFrom(t, "bot-attendee", "198.51.100.8")
+	}
+	if !burstFlagged(verdict) {
+		t.Errorf("expected a burst after %d answers from one attendee", default_copy_burst_answers+1)
+	}
+}
@@ -0,0 +1,255 @@
+package costs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Add up what the LLM calls cost, per execution id, question, and event.
+ *
+ * This lives in memory, so each Lambda instance has its own ledger, and it forgets
+ * everything when the instance goes away. That makes the budgets approximate: good
+ * enough to notice a runaway booth, not good enough for accounting.
+ *
+ * The per-attendee cap goes by the attendee's API key, not the execution id: anyone can
+ * make up a new execution id. Calls without a key share one cap between them.
+ */
+
+// Who the LLM call was on behalf of. Put it in the context before calling the LLM.
+type Scope struct {
+	EventName       string
+	QuestionId      string
+	ExecutionId     string
+	AttendeeKeyHash string // empty if they didn't send a key
+}
+
+type scopeContextKey struct{}
+
+func WithScope(currentContext context.Context, scope Scope) context.Context {
+	return context.WithValue(currentContext, scopeContextKey{}, scope)
+}
+
+func ScopeFrom(currentContext context.Context) Scope {
+	scope, _ := currentContext.Value(scopeContextKey{}).(Scope)
+	return scope
+}
+
+type ledgerContextKey struct{}
+
+// WithLedger sends the costs of LLM calls in this context to their own ledger, instead of the usual one.
+// Prompt evaluation does this, so it doesn't count against the booth's budgets.
+func WithLedger(currentContext context.Context, ledger *Ledger) context.Context {
+	return context.WithValue(currentContext, ledgerContextKey{}, ledger)
+}
+
+func LedgerFrom(currentContext context.Context, usual *Ledger) *Ledger {
+	ledger, ok := currentContext.Value(ledgerContextKey{}).(*Ledger)
+	if !ok {
+		return usual
+	}
+	return ledger
+}
+
+// Daily caps in US dollars. Zero means no cap.
+type Budget struct {
+	PerAttendeeDailyUSD float64
+	PerEventDailyUSD    float64
+}
+
+type Charge struct {
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	KnownPrice       bool    `json:"known_price"`
+	Estimated        bool    `json:"estimated"` // token counts were guessed, because streaming
+}
+
+type Total struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+
+	lastCharged time.Time
+}
+
+func (t *Total) add(charge Charge, at time.Time) {
+	t.lastCharged = at
+	t.Calls++
+	t.PromptTokens += charge.PromptTokens
+	t.CompletionTokens += charge.CompletionTokens
+	t.CostUSD += charge.CostUSD
+}
+
+type Ledger struct {
+	lock   sync.Mutex
+	budget Budget
+	now    func() time.Time
+
+	byExecution map[string]*Total
+	byQuestion  map[string]*Total // by event and question
+	byEvent     map[string]*Total
+
+	// these reset at midnight UTC
+	day             string
+	todayByAttendee map[string]*Total // by attendee key hash
+	todayByEvent    map[string]*Total
+}
+
+// Execution ids and attendee keys come from the client, so there's no end to them.
+// Past this many, the ledger forgets whichever was charged least recently.
+const maxTrackedTotals = 10000
+
+const noAttendeeKey = "no attendee key"
+
+func NewLedger(budget Budget) *Ledger {
+	return &Ledger{
+		budget:          budget,
+		now:             time.Now,
+		byExecution:     map[string]*Total{},
+		byQuestion:      map[string]*Total{},
+		byEvent:         map[string]*Total{},
+		todayByAttendee: map[string]*Total{},
+		todayByEvent:    map[string]*Total{},
+	}
+}
+
+func addTo(totals map[string]*Total, key string, charge Charge, at time.Time) *Total {
+	total, ok := totals[key]
+	if !ok {
+		if len(totals) >= maxTrackedTotals {
+			forgetLeastRecent(totals)
+		}
+		total = &Total{}
+		totals[key] = total
+	}
+	total.add(charge, at)
+	return total
+}
+
+func forgetLeastRecent(totals map[string]*Total) {
+	oldestKey, oldest := "", time.Time{}
+	for key, total := range totals {
+		if oldestKey == "" || total.lastCharged.Before(oldest) {
+			oldestKey, oldest = key, total.lastCharged
+		}
+	}
+	delete(totals, oldestKey)
+}
+
+func questionKey(scope Scope) string {
+	return scope.EventName + "/" + scope.QuestionId
+}
+
+func attendeeKey(scope Scope) string {
+	if scope.AttendeeKeyHash == "" {
+		return noAttendeeKey
+	}
+	return scope.AttendeeKeyHash
+}
+
+// call with the lock held
+func (l *Ledger) rollOverDay() {
+	today := l.now().UTC().Format("2006-01-02")
+	if today != l.day {
+		l.day = today
+		l.todayByAttendee = map[string]*Total{}
+		l.todayByEvent = map[string]*Total{}
+	}
+}
+
+// Record one chat call, and put the cost and running totals on the current span.
+func (l *Ledger) Record(currentContext context.Context, model string, promptTokens int, completionTokens int, estimated bool) Charge {
+	price, known := PriceOf(model)
+	charge := Charge{
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		CostUSD:          price.Cost(promptTokens, completionTokens),
+		KnownPrice:       known,
+		Estimated:        estimated,
+	}
+	scope := ScopeFrom(currentContext)
+
+	l.lock.Lock()
+	l.rollOverDay()
+	at := l.now()
+	executionTotal := addTo(l.byExecution, scope.ExecutionId, charge, at)
+	questionTotal := addTo(l.byQuestion, questionKey(scope), charge, at)
+	eventTotal := addTo(l.byEvent, scope.EventName, charge, at)
+	attendeeToday := addTo(l.todayByAttendee, attendeeKey(scope), charge, at)
+	eventToday := addTo(l.todayByEvent, scope.EventName, charge, at)
+	spanAttributes := []attribute.KeyValue{
+		attribute.Float64("app.cost.call_usd", charge.CostUSD),
+		attribute.Bool("app.cost.known_price", charge.KnownPrice),
+		attribute.Bool("app.cost.estimated_tokens", charge.Estimated),
+		attribute.Float64("app.cost.execution_total_usd", executionTotal.CostUSD),
+		attribute.Float64("app.cost.question_total_usd", questionTotal.CostUSD),
+		attribute.Float64("app.cost.event_total_usd", eventTotal.CostUSD),
+		attribute.Float64("app.cost.attendee_today_usd", attendeeToday.CostUSD),
+		attribute.Float64("app.cost.event_today_usd", eventToday.CostUSD),
+	}
+	l.lock.Unlock()
+
+	trace.SpanFromContext(currentContext).SetAttributes(spanAttributes...)
+	return charge
+}
+
+// Is this attendee or event past today's cap? If so, say which.
+func (l *Ledger) OverBudget(scope Scope) (over bool, reason string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rollOverDay()
+
+	if l.budget.PerAttendeeDailyUSD > 0 {
+		if spent, ok := l.todayByAttendee[attendeeKey(scope)]; ok && spent.CostUSD >= l.budget.PerAttendeeDailyUSD {
+			return true, fmt.Sprintf("attendee spent $%.4f today, cap is $%.4f", spent.CostUSD, l.budget.PerAttendeeDailyUSD)
+		}
+	}
+	if l.budget.PerEventDailyUSD > 0 {
+		if spent, ok := l.todayByEvent[scope.EventName]; ok && spent.CostUSD >= l.budget.PerEventDailyUSD {
+			return true, fmt.Sprintf("event spent $%.4f today, cap is $%.4f", spent.CostUSD, l.budget.PerEventDailyUSD)
+		}
+	}
+	return false, ""
+}
+
+type Snapshot struct {
+	Day             string           `json:"day"`
+	Budget          Budget           `json:"budget"`
+	ByExecution     map[string]Total `json:"by_execution_id"`
+	ByQuestion      map[string]Total `json:"by_question"` // "event/question"
+	ByEvent         map[string]Total `json:"by_event"`
+	TodayByAttendee map[string]Total `json:"today_by_attendee_key_hash"`
+	TodayByEvent    map[string]Total `json:"today_by_event"`
+}
+
+func copyTotals(totals map[string]*Total) map[string]Total {
+	copied := make(map[string]Total, len(totals))
+	for k, v := range totals {
+		copied[k] = *v
+	}
+	return copied
+}
+
+func (l *Ledger) Snapshot() Snapshot {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rollOverDay()
+	return Snapshot{
+		Day:             l.day,
+		Budget:          l.budget,
+		ByExecution:     copyTotals(l.byExecution),
+		ByQuestion:      copyTotals(l.byQuestion),
+		ByEvent:         copyTotals(l.byEvent),
+		TodayByAttendee: copyTotals(l.todayByAttendee),
+		TodayByEvent:    copyTotals(l.todayByEvent),
+	}
+}
@@ -0,0 +1,141 @@
+package costs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// a ledger whose clock only moves when the test says so
+func newTestLedger(budget Budget) (*Ledger, *time.Time) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	ledger := NewLedger(budget)
+	ledger.now = func() time.Time { return now }
+	return ledger, &now
+}
+
+// $3 of GPT-4
+func chargeThreeDollars(ledger *Ledger, scope Scope) Charge {
+	return ledger.Record(WithScope(context.Background(), scope), openai.GPT4, 100_000, 0, false)
+}
+
+func TestLedgerAddsUpEachCall(t *testing.T) {
+	ledger, _ := newTestLedger(Budget{})
+	charge := chargeThreeDollars(ledger, Scope{EventName: "ev", QuestionId: "q1", ExecutionId: "one", AttendeeKeyHash: "hash"})
+	if charge.CostUSD != 3 || !charge.KnownPrice {
+		t.Fatalf("expected $3 at a known price, got %+v", charge)
+	}
+	chargeThreeDollars(ledger, Scope{EventName: "ev", QuestionId: "q1", ExecutionId: "two", AttendeeKeyHash: "hash"})
+
+	snapshot := ledger.Snapshot()
+	if snapshot.ByEvent["ev"].CostUSD != 6 || snapshot.ByEvent["ev"].Calls != 2 || snapshot.ByEvent["ev"].PromptTokens != 200_000 {
+		t.Errorf("expected both calls on the event: %+v", snapshot.ByEvent["ev"])
+	}
+	if snapshot.ByExecution["one"].CostUSD != 3 || snapshot.ByExecution["two"].CostUSD != 3 {
+		t.Errorf("expected each execution id charged once: %+v", snapshot.ByExecution)
+	}
+	if snapshot.TodayByAttendee["hash"].CostUSD != 6 {
+		t.Errorf("expected both on the one attendee: %+v", snapshot.TodayByAttendee)
+	}
+}
+
+func TestUnknownModelIsPricedHigh(t *testing.T) {
+	ledger, _ := newTestLedger(Budget{})
+	charge := ledger.Record(context.Background(), "some-new-model", 1_000_000, 0, true)
+	if charge.KnownPrice || charge.CostUSD != unknownModelPrice.PromptPerMillion || !charge.Estimated {
+		t.Errorf("expected the cautious price, marked as a guess: %+v", charge)
+	}
+}
+
+func TestQuestionsAreCountedPerEvent(t *testing.T) {
+	ledger, _ := newTestLedger(Budget{})
+	chargeThreeDollars(ledger, Scope{EventName: "one event", QuestionId: "q1"})
+	chargeThreeDollars(ledger, Scope{EventName: "another event", QuestionId: "q1"})
+
+	byQuestion := ledger.Snapshot().ByQuestion
+	if byQuestion["one event/q1"].CostUSD != 3 || byQuestion["another event/q1"].CostUSD != 3 {
+		t.Errorf("expected the same question id in two events counted separately: %+v", byQuestion)
+	}
+}
+
+func TestAttendeeCapGoesByKeyNotExecutionId(t *testing.T) {
+	ledger, _ := newTestLedger(Budget{PerAttendeeDailyUSD: 5})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", ExecutionId: "one", AttendeeKeyHash: "hash"})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", ExecutionId: "two", AttendeeKeyHash: "hash"})
+
+	if over, reason := ledger.OverBudget(Scope{EventName: "ev", ExecutionId: "a new one", AttendeeKeyHash: "hash"}); !over || reason == "" {
+		t.Errorf("a new execution id shouldn't get around the cap")
+	}
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", ExecutionId: "one", AttendeeKeyHash: "someone else"}); over {
+		t.Errorf("someone else's key has its own cap")
+	}
+}
+
+func TestCallsWithoutAKeyShareACap(t *testing.T) {
+	ledger, _ := newTestLedger(Budget{PerAttendeeDailyUSD: 5})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", ExecutionId: "one"})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", ExecutionId: "two"})
+
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", ExecutionId: "three"}); !over {
+		t.Errorf("leaving out the key shouldn't get around the cap")
+	}
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", ExecutionId: "three", AttendeeKeyHash: "hash"}); over {
+		t.Errorf("an attendee with a key isn't capped by those without")
+	}
+}
+
+func TestEventCap(t *testing.T) {
+	ledger, _ := newTestLedger(Budget{PerEventDailyUSD: 5})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", AttendeeKeyHash: "one"})
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", AttendeeKeyHash: "two"}); over {
+		t.Fatalf("over the cap too early")
+	}
+	chargeThreeDollars(ledger, Scope{EventName: "ev", AttendeeKeyHash: "two"})
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", AttendeeKeyHash: "three"}); !over {
+		t.Errorf("expected everyone at the event over the cap")
+	}
+	if over, _ := ledger.OverBudget(Scope{EventName: "another event", AttendeeKeyHash: "three"}); over {
+		t.Errorf("another event has its own cap")
+	}
+}
+
+func TestCapsResetAtMidnightUTC(t *testing.T) {
+	ledger, now := newTestLedger(Budget{PerAttendeeDailyUSD: 1, PerEventDailyUSD: 1})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", AttendeeKeyHash: "hash"})
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", AttendeeKeyHash: "hash"}); !over {
+		t.Fatalf("expected them over the cap")
+	}
+
+	*now = now.Add(12 * time.Hour)
+	if over, _ := ledger.OverBudget(Scope{EventName: "ev", AttendeeKeyHash: "hash"}); over {
+		t.Errorf("expected a new day's budget")
+	}
+	if ledger.Snapshot().ByEvent["ev"].CostUSD != 3 {
+		t.Errorf("the all-time totals shouldn't reset")
+	}
+}
+
+func TestLedgerForgetsTheLeastRecentlyCharged(t *testing.T) {
+	ledger, now := newTestLedger(Budget{})
+	for i := 0; i < maxTrackedTotals; i++ {
+		chargeThreeDollars(ledger, Scope{EventName: "ev", QuestionId: "q", ExecutionId: fmt.Sprintf("execution %d", i)})
+		*now = now.Add(time.Millisecond)
+	}
+	// the first one was charged again, so the second is the least recent
+	chargeThreeDollars(ledger, Scope{EventName: "ev", QuestionId: "q", ExecutionId: "execution 0"})
+	chargeThreeDollars(ledger, Scope{EventName: "ev", QuestionId: "q", ExecutionId: "one too many"})
+
+	byExecution := ledger.Snapshot().ByExecution
+	if len(byExecution) != maxTrackedTotals {
+		t.Errorf("expected at most %d execution ids, got %d", maxTrackedTotals, len(byExecution))
+	}
+	if _, kept := byExecution["execution 1"]; kept {
+		t.Errorf("expected the least recently charged forgotten")
+	}
+	if byExecution["execution 0"].CostUSD != 6 || byExecution["one too many"].CostUSD != 3 {
+		t.Errorf("expected the recent ones kept")
+	}
+}
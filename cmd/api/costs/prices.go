@@ -0,0 +1,39 @@
+package costs
+
+import "github.com/sashabaranov/go-openai"
+
+// US dollars per million tokens, from https://openai.com/pricing
+type Price struct {
+	PromptPerMillion     float64
+	CompletionPerMillion float64
+}
+
+var prices = map[string]Price{
+	openai.GPT3Dot5Turbo1106: {PromptPerMillion: 1.00, CompletionPerMillion: 2.00},
+	"gpt-3.5-turbo-0125":     {PromptPerMillion: 0.50, CompletionPerMillion: 1.50},
+	openai.GPT3Dot5Turbo:     {PromptPerMillion: 0.50, CompletionPerMillion: 1.50},
+	openai.GPT4TurboPreview:  {PromptPerMillion: 10.00, CompletionPerMillion: 30.00},
+	openai.GPT4VisionPreview: {PromptPerMillion: 10.00, CompletionPerMillion: 30.00},
+	openai.GPT4:              {PromptPerMillion: 30.00, CompletionPerMillion: 60.00},
+	openai.GPT432K:           {PromptPerMillion: 60.00, CompletionPerMillion: 120.00},
+}
+
+// When we don't know the model, guess high, so the budget errs on the side of caution.
+var unknownModelPrice = Price{PromptPerMillion: 30.00, CompletionPerMillion: 60.00}
+
+func PriceOf(model string) (price Price, known bool) {
+	price, known = prices[model]
+	if !known {
+		return unknownModelPrice, false
+	}
+	return price, true
+}
+
+func (p Price) Cost(promptTokens int, completionTokens int) float64 {
+	return (float64(promptTokens)*p.PromptPerMillion + float64(completionTokens)*p.CompletionPerMillion) / 1_000_000
+}
+
+// The streaming API doesn't tell us usage. About four characters per token is OpenAI's rule of thumb.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
@@ -147,6 +147,7 @@ func (settings DeepChecksAPI) send_to_deepchecks(currentContext context.Context,
 	res, err := httpClient.Do(req)
 	if err != nil {
 		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure talking to DeepChecks")))
+		return // no response to read. This happens offline, like when replaying LLM fixtures
 	}
 
 	body, err = io.ReadAll(res.Body) // do this even if there is an error, there might be a message
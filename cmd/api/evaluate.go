@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"observaquiz_lambda/cmd/api/costs"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Score an answer the way the booth would, without it counting for anything. This is what cmd/prompt-eval calls.
+ *
+ * It runs respondToAnswer and stops there: no copy detection, no idempotency, nothing in the attendee store
+ * or on the leaderboard. The LLM calls go on a ledger of their own, so they don't use up the event's budget,
+ * and the response says what they cost.
+ */
+
+var postEvaluationEndpoint = apiEndpoint{
+	"POST",
+	"/api/admin/questions/{questionId}/evaluate",
+	regexp.MustCompile("^/api/admin/questions/([^/]+)/evaluate$"),
+	adminOnly(postEvaluation),
+	true,
+}
+
+const evaluationExecutionId = "prompt-eval"
+
+type EvaluationResponse struct {
+	PostAnswerResponse
+	Category string   `json:"category,omitempty"` // v2 only
+	Flags    []string `json:"flags,omitempty"`
+	CostUSD  float64  `json:"cost_usd"`
+}
+
+func postEvaluation(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+
+	answer := AnswerBody{}
+	err := json.Unmarshal([]byte(request.Body), &answer)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'answer': 'stuff' }", 400), nil
+	}
+
+	eventName := getEventName(request)
+	questionId := strings.Split(request.RequestContext.HTTP.Path, "/")[4]
+	span.SetAttributes(attribute.String("app.evaluate.event_name", eventName),
+		attribute.String("app.evaluate.question_id", questionId))
+	questionDefinition, questionFound := findQuestion(eventName, questionId)
+	if !questionFound {
+		return instrumentation.ErrorResponse("Couldn't find question with that ID", 404), nil
+	}
+
+	ledger := costs.NewLedger(costs.Budget{})
+	currentContext = costs.WithLedger(currentContext, ledger)
+	currentContext = costs.WithScope(currentContext, costs.Scope{EventName: eventName, QuestionId: questionId, ExecutionId: evaluationExecutionId})
+	llmResponse, errorResponse := respondToAnswer(currentContext, eventName, questionDefinition, answer)
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+
+	result := EvaluationResponse{
+		PostAnswerResponse: postAnswerResponseFrom(llmResponse),
+		Category:           llmResponse.category,
+		Flags:              llmResponse.flags,
+		CostUSD:            ledger.Snapshot().ByEvent[eventName].CostUSD,
+	}
+	// always say what it was before scaling, so prompt-eval can compare the components with it
+	if result.RawScore == nil {
+		result.RawScore, result.RawPossible = &result.Score, &result.PossibleScore
+	}
+	span.SetAttributes(attribute.Int("app.evaluate.score", result.Score),
+		attribute.Int("app.evaluate.raw_score", *result.RawScore),
+		attribute.Float64("app.evaluate.cost_usd", result.CostUSD))
+
+	resultJson, err := json.Marshal(result)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(resultJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestEvaluationScoresWithoutRecording(t *testing.T) {
+	settings.AdminApiKey = "test-admin-key"
+	defer func() { settings.AdminApiKey = "" }()
+
+	request := answerRequest(v2QuestionId, evaluationExecutionId, replayedV2Answer)
+	request.Headers = map[string]string{ADMIN_API_KEY_HEADER: "test-admin-key"}
+	request.RequestContext.HTTP.Path = "/api/admin/questions/" + v2QuestionId + "/evaluate"
+	response, err := postEvaluationEndpoint.handler(context.Background(), request)
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d %v: %s", response.StatusCode, err, response.Body)
+	}
+
+	result := EvaluationResponse{}
+	err = json.Unmarshal([]byte(response.Body), &result)
+	if err != nil {
+		t.Fatalf("the response isn't JSON: %v: %s", err, response.Body)
+	}
+	if result.Category != "Observability 1.5" || result.RawScore == nil || len(result.ScoreComponents) == 0 {
+		t.Errorf("expected the category, raw score and components, got %+v", result)
+	}
+
+	_, found, _ := attendeeStore.Session(context.Background(), testEventName, evaluationExecutionId)
+	if found {
+		t.Errorf("an evaluation shouldn't be recorded as an attendee's answer")
+	}
+	if _, spent := costLedger.Snapshot().ByExecution[evaluationExecutionId]; spent {
+		t.Errorf("an evaluation shouldn't count against the event's budget")
+	}
+}
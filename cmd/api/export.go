@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/**
+ * The event's results, for booth staff (admin key required). One row per answer.
+ *
+ *    GET /api/admin/export?format=csv&columns=execution_id,question_id,category,score&redact=execution_id
+ *
+ * format is csv (the default) or jsonl. columns picks and orders the columns; redact hides what's in them.
+ * all_attempts=true includes every answer, not just the latest to each question. See store.Export for the columns.
+ * cmd/export does the same from a store file, without a running API.
+ */
+
+var getExportEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/export",
+	regexp.MustCompile("^/api/admin/export$"),
+	adminOnly(getExport),
+	true,
+}
+
+func getExport(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	currentContext, span := tracer.Start(currentContext, "export results")
+	defer span.End()
+
+	eventName := getEventName(request)
+	options := store.ExportOptions{
+		Format:      request.QueryStringParameters["format"],
+		Columns:     store.ParseColumnList(request.QueryStringParameters["columns"]),
+		Redact:      store.ParseColumnList(request.QueryStringParameters["redact"]),
+		AllAttempts: request.QueryStringParameters["all_attempts"] == "true",
+	}
+	if options.Format == "" {
+		options.Format = store.EXPORT_FORMAT_CSV
+	}
+	span.SetAttributes(attribute.String("app.export.event_name", eventName),
+		attribute.String("app.export.format", options.Format),
+		attribute.StringSlice("app.export.columns", options.Columns),
+		attribute.StringSlice("app.export.redact", options.Redact),
+		attribute.Bool("app.export.all_attempts", options.AllAttempts))
+	if err := options.Check(); err != nil {
+		return instrumentation.ErrorResponse(err.Error(), 400), nil
+	}
+
+	sessions, err := attendeeStore.Sessions(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the attendee store", 500), nil
+	}
+	body := bytes.Buffer{}
+	rowQty, err := store.Export(&body, sessions, options)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't write the export", 500), nil
+	}
+	span.SetAttributes(attribute.Int("app.export.sessions_qty", len(sessions)), attribute.Int("app.export.rows_qty", rowQty))
+
+	contentType := "text/csv"
+	if options.Format == store.EXPORT_FORMAT_JSONL {
+		contentType = "application/x-ndjson"
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body: body.String(),
+		Headers: map[string]string{
+			"Content-Type":        contentType,
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", eventName+"."+options.Format),
+		},
+		StatusCode: 200}, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var getCostsEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/costs",
+	regexp.MustCompile("^/api/admin/costs$"),
+	adminOnly(getCosts),
+	false,
+}
+
+// What this Lambda instance has spent on OpenAI since it started.
+func getCosts(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := oteltrace.SpanFromContext(currentContext)
+
+	snapshot := costLedger.Snapshot()
+	span.SetAttributes(attribute.Int("app.cost.executions_qty", len(snapshot.ByExecution)),
+		attribute.Int("app.cost.events_qty", len(snapshot.ByEvent)))
+
+	costsJson, err := json.Marshal(snapshot)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(costsJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
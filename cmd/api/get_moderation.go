@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var getModerationEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/moderation",
+	regexp.MustCompile("^/api/admin/moderation$"),
+	adminOnly(getModeration),
+	false,
+}
+
+// Everything the moderator flagged lately, for booth staff to look over.
+func getModeration(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := oteltrace.SpanFromContext(currentContext)
+
+	flagged := moderationLog.list()
+	span.SetAttributes(attribute.Int("app.moderation.flagged_qty", len(flagged)))
+
+	flaggedJson, err := json.Marshal(flagged)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(flaggedJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
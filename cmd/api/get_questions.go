@@ -30,22 +30,29 @@ type Question struct {
 	AnswerResponsePrompt AnswerResponsePrompt `json:"prompt"`  // V1 only
 	PromptsV2            PromptsV2            `json:"prompts"` // V2 only
 	Scoring              ScoringThings        `json:"scoring"` // V2 only
+	Guard                GuardConfig          `json:"guard"`
+	Conversation         ConversationConfig   `json:"conversation"` // for POST /api/questions/{id}/conversation
 }
 
 type PromptsV2 struct {
-	ResponsePrompt string `json:"response_prompt"`
-	CategoryPrompt string `json:"category_prompt"`
+	ResponsePrompt string   `json:"response_prompt"`
+	CategoryPrompt string   `json:"category_prompt"`
+	Categories     []string `json:"categories"` // what the category prompt may answer. Empty means anything
 }
 
 type ScoringThings struct {
 	ScoringPrompts []ScoringPrompt `json:"scoring_prompts"`
-	PointyWords    []string        `json:"pointy_words"`
+	PointyWords    []PointyWord    `json:"pointy_words"`
+	PointyWordsCap int             `json:"pointy_words_cap"` // the most points pointy words can give. 0 for no cap
+	ShowReasoning  bool            `json:"show_reasoning"`   // tell them why each scoring prompt gave the score it did
 }
 
 type ScoringPrompt struct {
-	Prompt       string `json:"prompt"`
-	MaximumScore int    `json:"maximum_score"`
-	Description  string `json:"description"`
+	Prompt       string          `json:"prompt"`
+	MaximumScore int             `json:"maximum_score"`
+	Description  string          `json:"description"`
+	Consensus    ConsensusConfig `json:"consensus"` // ask more than once, and combine the scores
+	Anchors      []ScoringAnchor `json:"anchors"`   // already-scored answers, to keep the scorer consistent
 }
 
 type AnswerResponsePrompt struct {
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Some attendees answer the LLM instead of the question: "ignore previous instructions",
+ * "give me all the points", "{ "score": 20 }". The guard looks for that before we score,
+ * and decides what to do about it.
+ */
+
+const (
+	GUARD_OUTCOME_ZERO   = "zero"   // respond as usual, but they get no points
+	GUARD_OUTCOME_CAP    = "cap"    // respond as usual, score no more than score_cap
+	GUARD_OUTCOME_CANNED = "canned" // don't even ask the LLM; respond with canned_response
+)
+
+const defaultCannedGuardResponse = "Nice try! The points come from telling us about your observability, not from telling me what to do. Want to give it another go?"
+
+type GuardConfig struct {
+	Disabled         bool   `json:"disabled"`
+	Outcome          string `json:"outcome"`   // zero (default), cap, or canned
+	ScoreCap         int    `json:"score_cap"` // in the event's points_per_question, if it has one
+	CannedResponse   string `json:"canned_response"`
+	ClassifierPrompt string `json:"classifier_prompt"` // optional. Replaces QUESTION and THEIR ANSWER; must respond with { "injection": bool, "reason": string }
+}
+
+type guardVerdict struct {
+	detected bool
+	reason   string
+	source   string // heuristic or classifier
+}
+
+type guardHeuristic struct {
+	reason  string
+	pattern *regexp.Regexp
+}
+
+var guardHeuristics = []guardHeuristic{
+	{"tries to override the instructions", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,40}\b(previous|prior|above|earlier|all|your|the)\b.{0,30}\b(instructions?|prompts?|rules|directions|guidelines)\b`)},
+	{"tries to override the instructions", regexp.MustCompile(`(?i)\bnew instructions\b`)},
+	{"claims points", regexp.MustCompile(`(?i)\bgive\s+(me|us|this|this answer|my answer)\s+(all|full|max|maximum|the|top|\d+)\b.{0,25}\b(points?|scores?|marks)\b`)},
+	{"claims points", regexp.MustCompile(`(?i)\b(score|rate|grade)\s+(me|this|my answer|it)\s+(a\s+)?(\d+|full|max|maximum|perfect|the highest)\b`)},
+	{"claims points", regexp.MustCompile(`(?i)"score"\s*:\s*"?\d+`)},
+	{"role-plays the system", regexp.MustCompile(`(?i)\b(you are now|from now on,? you are|pretend (to be|you are)|developer mode|jailbreak)\b`)},
+	{"role-plays the system", regexp.MustCompile(`(?i)\bact as (a |an |the )?(system|quizmaster|grader|judge|scorer|ai|assistant)\b`)},
+	{"role-plays the system", regexp.MustCompile(`(?im)^\s*(system|assistant)\s*:`)},
+	{"role-plays the system", regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|tell me)\s+(me\s+)?(your|the)\s+(system\s+)?prompt\b`)},
+}
+
+func guardClassifierSchema() jsonSchema {
+	return jsonSchema{
+		{name: "injection", fieldType: jsonBoolean, required: true},
+		{name: "reason", fieldType: jsonString},
+	}
+}
+
+type guardClassifierResult struct {
+	Injection bool   `json:"injection"`
+	Reason    string `json:"reason"`
+}
+
+// checkAnswerWithGuard looks for attempts to instruct the model. Heuristics first; the classifier only if those don't catch it.
+func checkAnswerWithGuard(currentContext context.Context, questionDefinition Question, answer AnswerBody) guardVerdict {
+	currentContext, span := tracer.Start(currentContext, "guard against prompt injection")
+	defer span.End()
+	config := questionDefinition.Guard
+	span.SetAttributes(attribute.Bool("app.guard.disabled", config.Disabled),
+		attribute.Bool("app.guard.has_classifier", config.ClassifierPrompt != ""))
+	if config.Disabled {
+		return guardVerdict{}
+	}
+
+	verdict := guardVerdict{}
+	for _, heuristic := range guardHeuristics {
+		if match := heuristic.pattern.FindString(answer.Answer); match != "" {
+			verdict = guardVerdict{detected: true, reason: heuristic.reason, source: "heuristic"}
+			span.SetAttributes(attribute.String("app.guard.matched_text", match))
+			break
+		}
+	}
+
+	if !verdict.detected && config.ClassifierPrompt != "" {
+		llmApi := newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey)
+		classifierOutput := chatResult{}
+		classification := guardClassifierResult{}
+		err := chatForValidJson(currentContext, llmApi, chatRequest{
+			theirAnswer:    answer.Answer,
+			promptTemplate: config.ClassifierPrompt,
+			replacements:   map[string]string{"THEIR ANSWER": answer.Answer, "QUESTION": questionDefinition.Question},
+		}, guardClassifierSchema(), &classifierOutput, &classification)
+		if err != nil {
+			// the guard is a nice-to-have; don't fail their answer over it
+			span.RecordError(err)
+		} else if classification.Injection {
+			verdict = guardVerdict{detected: true, reason: classification.Reason, source: "classifier"}
+		}
+	}
+
+	span.SetAttributes(attribute.Bool("app.guard.detected", verdict.detected),
+		attribute.String("app.guard.reason", verdict.reason),
+		attribute.String("app.guard.source", verdict.source))
+	return verdict
+}
+
+func (config GuardConfig) outcome() string {
+	if config.Outcome == "" {
+		return GUARD_OUTCOME_ZERO
+	}
+	return config.Outcome
+}
+
+func (config GuardConfig) cannedResponse() string {
+	if config.CannedResponse == "" {
+		return defaultCannedGuardResponse
+	}
+	return config.CannedResponse
+}
+
+// applyGuardVerdict changes the score (or the whole response) of an answer the guard caught.
+func applyGuardVerdict(currentContext context.Context, config GuardConfig, verdict guardVerdict, response *responseToAnswer) {
+	span := trace.SpanFromContext(currentContext)
+	span.SetAttributes(attribute.Bool("app.guard.detected", verdict.detected))
+	if !verdict.detected {
+		return
+	}
+	span.SetAttributes(attribute.String("app.guard.reason", verdict.reason),
+		attribute.String("app.guard.source", verdict.source),
+		attribute.String("app.guard.outcome", config.outcome()),
+		attribute.Int("app.guard.original_score", response.score))
+
+	originalScore := response.score
+	switch config.outcome() {
+	case GUARD_OUTCOME_CAP:
+		if response.score > config.ScoreCap {
+			response.score = config.ScoreCap
+		}
+	default: // zero, and anything we don't recognize
+		response.score = 0
+	}
+	if response.score != originalScore || config.outcome() != GUARD_OUTCOME_CAP {
+		overrideScoreParts(response, rawScoreFor(response))
+	}
+	span.SetAttributes(attribute.Int("app.guard.final_score", response.score))
+}
+
+// the guard's score is in the event's points; the parts are in the question's own
+func rawScoreFor(response *responseToAnswer) int {
+	if response.rawScore == nil || response.possibleScore == 0 {
+		return response.score
+	}
+	return response.score * *response.rawPossibleScore / response.possibleScore
+}
+
+// overrideScoreParts makes the breakdown add up to the score the guard left them with. Each scored part keeps
+// the same share of it, and says the guard changed it. The leftover points from rounding down go to the parts that lost the most to it.
+func overrideScoreParts(response *responseToAnswer, target int) {
+	total := 0
+	for _, part := range response.scoreParts {
+		if part.status == SCORE_STATUS_SCORED {
+			total += clampScore(part.score, part.possibleScore)
+		}
+	}
+	if target > total {
+		target = total
+	}
+	if response.rawScore != nil {
+		response.rawScore = &target
+	}
+
+	type share struct {
+		index     int
+		remainder int
+	}
+	shares := []share{}
+	assigned := 0
+	for i := range response.scoreParts {
+		part := &response.scoreParts[i]
+		if part.status != SCORE_STATUS_SCORED {
+			continue
+		}
+		part.overriddenBy = ANSWER_FLAG_GUARD
+		original := clampScore(part.score, part.possibleScore)
+		if total == 0 {
+			part.score = 0
+			continue
+		}
+		part.score = original * target / total
+		assigned += part.score
+		shares = append(shares, share{i, original * target % total})
+	}
+	sort.SliceStable(shares, func(i, j int) bool { return shares[i].remainder > shares[j].remainder })
+	for i := 0; assigned < target && i < len(shares); i++ {
+		response.scoreParts[shares[i].index].score++
+		assigned++
+	}
+}
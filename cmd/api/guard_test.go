@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func guardedResponse() *responseToAnswer {
+	rawScore, rawPossibleScore := 30, 40
+	return &responseToAnswer{
+		score:            75,
+		possibleScore:    100,
+		rawScore:         &rawScore,
+		rawPossibleScore: &rawPossibleScore,
+		scoreParts: []partialScore{
+			{description: "first", score: 15, possibleScore: 20, status: SCORE_STATUS_SCORED},
+			{description: "second", score: 0, possibleScore: 10, status: SCORE_STATUS_FAILED},
+			{description: "pointy words", score: 15, possibleScore: 10, status: SCORE_STATUS_SCORED}, // clamps to 10
+			{description: "third", score: 5, possibleScore: 10, status: SCORE_STATUS_SCORED},
+		},
+	}
+}
+
+func scoredPartsTotal(response *responseToAnswer) int {
+	total := 0
+	for _, part := range response.scoreParts {
+		if part.status == SCORE_STATUS_SCORED {
+			total += part.score
+		}
+	}
+	return total
+}
+
+func TestGuardZeroesTheParts(t *testing.T) {
+	response := guardedResponse()
+	applyGuardVerdict(context.Background(), GuardConfig{Outcome: GUARD_OUTCOME_ZERO}, guardVerdict{detected: true}, response)
+
+	if response.score != 0 || *response.rawScore != 0 {
+		t.Fatalf("expected 0 and raw 0, got %d and raw %d", response.score, *response.rawScore)
+	}
+	for _, part := range response.scoreParts {
+		if part.status == SCORE_STATUS_SCORED && (part.score != 0 || part.overriddenBy != ANSWER_FLAG_GUARD) {
+			t.Errorf("%s: expected 0 overridden by the guard, got %d overridden by %q", part.description, part.score, part.overriddenBy)
+		}
+	}
+}
+
+func TestGuardCapScalesThePartsToTheTotal(t *testing.T) {
+	response := guardedResponse()
+	applyGuardVerdict(context.Background(), GuardConfig{Outcome: GUARD_OUTCOME_CAP, ScoreCap: 50}, guardVerdict{detected: true}, response)
+
+	if response.score != 50 {
+		t.Fatalf("expected the cap, got %d", response.score)
+	}
+	if *response.rawScore != 20 {
+		t.Errorf("expected a raw score of 20 (50 of 100 is 20 of 40), got %d", *response.rawScore)
+	}
+	if total := scoredPartsTotal(response); total != *response.rawScore {
+		t.Errorf("the parts add up to %d, not the raw score %d", total, *response.rawScore)
+	}
+	if response.scoreParts[1].overriddenBy != "" {
+		t.Errorf("the failed part wasn't scored, so the guard didn't change it")
+	}
+}
+
+func TestGuardCapWithoutScaling(t *testing.T) {
+	response := guardedResponse()
+	response.score, response.possibleScore, response.rawScore, response.rawPossibleScore = 30, 40, nil, nil
+	applyGuardVerdict(context.Background(), GuardConfig{Outcome: GUARD_OUTCOME_CAP, ScoreCap: 7}, guardVerdict{detected: true}, response)
+
+	if total := scoredPartsTotal(response); total != 7 {
+		t.Errorf("the parts add up to %d, not the capped score 7", total)
+	}
+}
+
+func TestGuardCapBelowTheScoreChangesNothing(t *testing.T) {
+	response := guardedResponse()
+	applyGuardVerdict(context.Background(), GuardConfig{Outcome: GUARD_OUTCOME_CAP, ScoreCap: 90}, guardVerdict{detected: true}, response)
+
+	if response.score != 75 || response.scoreParts[0].score != 15 || response.scoreParts[0].overriddenBy != "" {
+		t.Errorf("a cap above the score shouldn't touch it: %d, first part %d overridden by %q", response.score, response.scoreParts[0].score, response.scoreParts[0].overriddenBy)
+	}
+}
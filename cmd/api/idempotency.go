@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Booth Wi-Fi is flaky, so the UI retries. A retried answer shouldn't run every LLM call again
+ * (or report another interaction to Deepchecks), so within idempotencyWindow of the first one it gets the same response back.
+ *
+ * Which requests are the same: the Idempotency-Key header, if they send one. Otherwise the same execution id,
+ * question, and answer text. (Not just the question: answering again with something better is allowed.)
+ * A duplicate that arrives while the first is still working waits for it.
+ *
+ * Only answers that worked are kept; after an error, a retry tries again. This is in memory, per Lambda instance,
+ * so a retry that lands on another instance is answered again.
+ */
+
+const (
+	IDEMPOTENCY_KEY_HEADER     = "idempotency-key"
+	IDEMPOTENT_REPLAYED_HEADER = "Idempotent-Replayed"
+	idempotencyWindow          = 10 * time.Minute
+)
+
+type answerAttempt struct {
+	answerHash    string
+	done          chan struct{} // closed when the response is ready
+	response      *responseToAnswer
+	errorResponse *errorResponseType
+	abandoned     bool // it failed because the request that sent it went away
+	finishedAt    time.Time
+}
+
+type answerDeduplicator struct {
+	lock     sync.Mutex
+	attempts map[string]*answerAttempt
+	now      func() time.Time
+}
+
+var answerDeduplication = &answerDeduplicator{attempts: map[string]*answerAttempt{}, now: time.Now}
+
+// answerIdempotencyKey is empty when there's nothing to tell retries apart by: no header, and no execution id.
+func answerIdempotencyKey(request events.APIGatewayV2HTTPRequest, eventName string, questionId string, answer AnswerBody) string {
+	executionId := getExecutionId(request)
+	if headerKey := getHeader(request, IDEMPOTENCY_KEY_HEADER); headerKey != "" {
+		return strings.Join([]string{"header", eventName, executionId, questionId, headerKey}, "\x00")
+	}
+	if executionId == "unset" {
+		return ""
+	}
+	return strings.Join([]string{"answer", eventName, executionId, questionId, hashAnswerText(answer)}, "\x00")
+}
+
+func hashAnswerText(answer AnswerBody) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(answer.Answer)))
+}
+
+// once runs answerIt, unless an answer with this key is already done or in progress; then it returns that one.
+// replayed says it came from an earlier request.
+func (d *answerDeduplicator) once(currentContext context.Context, key string, answer AnswerBody, answerIt func() (*responseToAnswer, *errorResponseType)) (response *responseToAnswer, errorResponse *errorResponseType, replayed bool) {
+	span := trace.SpanFromContext(currentContext)
+	if key == "" {
+		response, errorResponse = nonNilResponse(answerIt())
+		return response, errorResponse, false
+	}
+
+	for {
+		d.lock.Lock()
+		d.forgetOld()
+		attempt, found := d.attempts[key]
+		if !found {
+			attempt = &answerAttempt{answerHash: hashAnswerText(answer), done: make(chan struct{})}
+			d.attempts[key] = attempt
+		}
+		d.lock.Unlock()
+
+		if !found {
+			response, errorResponse = d.answer(currentContext, key, attempt, answerIt)
+			return response, errorResponse, false
+		}
+
+		span.SetAttributes(attribute.Bool("app.idempotency.duplicate", true))
+		if attempt.answerHash != hashAnswerText(answer) {
+			return nil, &errorResponseType{message: "That Idempotency-Key was already used with a different answer", statusCode: 422}, false
+		}
+		select {
+		case <-attempt.done:
+		default:
+			span.SetAttributes(attribute.Bool("app.idempotency.waited", true))
+			waitStarted := time.Now()
+			select {
+			case <-attempt.done:
+			case <-currentContext.Done():
+				return nil, &errorResponseType{message: "Gave up waiting for the same answer, sent earlier", statusCode: 504}, false
+			}
+			span.SetAttributes(attribute.Int64("app.idempotency.wait_ms", time.Since(waitStarted).Milliseconds()))
+		}
+		if attempt.abandoned {
+			// whoever sent it first went away before it finished. We're still here, so it's our turn
+			span.SetAttributes(attribute.Bool("app.idempotency.took_over", true))
+			continue
+		}
+		span.SetAttributes(attribute.Bool("app.idempotency.replayed", attempt.errorResponse == nil))
+		return attempt.response, attempt.errorResponse, attempt.errorResponse == nil
+	}
+}
+
+func (d *answerDeduplicator) answer(currentContext context.Context, key string, attempt *answerAttempt, answerIt func() (*responseToAnswer, *errorResponseType)) (response *responseToAnswer, errorResponse *errorResponseType) {
+	// if answerIt panics, anyone waiting still needs to hear about it
+	finished := false
+	defer func() {
+		if !finished {
+			d.finish(key, attempt, nil, &errorResponseType{message: "Something went wrong answering that", statusCode: 500}, false)
+		}
+	}()
+	response, errorResponse = nonNilResponse(answerIt())
+	finished = true
+	d.finish(key, attempt, response, errorResponse, currentContext.Err() != nil)
+	return response, errorResponse
+}
+
+func (d *answerDeduplicator) finish(key string, attempt *answerAttempt, response *responseToAnswer, errorResponse *errorResponseType, abandoned bool) {
+	d.lock.Lock()
+	attempt.response, attempt.errorResponse = response, errorResponse
+	attempt.abandoned = abandoned && errorResponse != nil
+	attempt.finishedAt = d.now()
+	if errorResponse != nil {
+		delete(d.attempts, key) // so the next retry tries again. Anyone already waiting gets this error
+	}
+	d.lock.Unlock()
+	close(attempt.done)
+}
+
+// expects the lock to be held
+func (d *answerDeduplicator) forgetOld() {
+	cutoff := d.now().Add(-idempotencyWindow)
+	for key, attempt := range d.attempts {
+		if !attempt.finishedAt.IsZero() && attempt.finishedAt.Before(cutoff) {
+			delete(d.attempts, key)
+		}
+	}
+}
+
+// when their data is deleted. Anyone already waiting on one of these still gets it
+func (d *answerDeduplicator) forget(eventName string, executionId string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for key := range d.attempts {
+		parts := strings.Split(key, "\x00") // see answerIdempotencyKey
+		if parts[1] == eventName && parts[2] == executionId {
+			delete(d.attempts, key)
+		}
+	}
+}
+
+// respondToAnswer promises one or the other; make sure, because a waiting duplicate can't ask again
+func nonNilResponse(response *responseToAnswer, errorResponse *errorResponseType) (*responseToAnswer, *errorResponseType) {
+	if response == nil && errorResponse == nil {
+		return nil, &errorResponseType{message: "No response to that answer", statusCode: 500}
+	}
+	return response, errorResponse
+}
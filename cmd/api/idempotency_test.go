@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func newTestDeduplicator() (*answerDeduplicator, *time.Time) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	return &answerDeduplicator{attempts: map[string]*answerAttempt{}, now: func() time.Time { return now }}, &now
+}
+
+// counts its calls, and answers with which call it was
+func countedAnswer(calls *atomic.Int32) func() (*responseToAnswer, *errorResponseType) {
+	return func() (*responseToAnswer, *errorResponseType) {
+		return &responseToAnswer{score: int(calls.Add(1))}, nil
+	}
+}
+
+func TestRetriedAnswerGetsTheSameResponse(t *testing.T) {
+	d, now := newTestDeduplicator()
+	var calls atomic.Int32
+	answer := AnswerBody{Answer: "we read the logs"}
+
+	first, _, replayed := d.once(context.Background(), "key", answer, countedAnswer(&calls))
+	if replayed || first.score != 1 {
+		t.Fatalf("the first one should be answered: %+v %v", first, replayed)
+	}
+	second, _, replayed := d.once(context.Background(), "key", answer, countedAnswer(&calls))
+	if !replayed || second != first || calls.Load() != 1 {
+		t.Errorf("expected the first response again, without answering again: %+v %v, %d calls", second, replayed, calls.Load())
+	}
+
+	*now = now.Add(idempotencyWindow + time.Second)
+	third, _, replayed := d.once(context.Background(), "key", answer, countedAnswer(&calls))
+	if replayed || third.score != 2 {
+		t.Errorf("after the window it's a new answer: %+v %v", third, replayed)
+	}
+}
+
+func TestReusedIdempotencyKeyWithAnotherAnswer(t *testing.T) {
+	d, _ := newTestDeduplicator()
+	var calls atomic.Int32
+	d.once(context.Background(), "key", AnswerBody{Answer: "we read the logs"}, countedAnswer(&calls))
+	_, errorResponse, _ := d.once(context.Background(), "key", AnswerBody{Answer: "we have traces"}, countedAnswer(&calls))
+	if errorResponse == nil || errorResponse.statusCode != 422 || calls.Load() != 1 {
+		t.Errorf("expected a 422 without answering, got %+v, %d calls", errorResponse, calls.Load())
+	}
+}
+
+func TestFailedAnswerIsTriedAgain(t *testing.T) {
+	d, _ := newTestDeduplicator()
+	var calls atomic.Int32
+	answer := AnswerBody{Answer: "we read the logs"}
+	_, errorResponse, _ := d.once(context.Background(), "key", answer, func() (*responseToAnswer, *errorResponseType) {
+		calls.Add(1)
+		return nil, &errorResponseType{message: "Could not reach LLM", statusCode: 503}
+	})
+	if errorResponse == nil {
+		t.Fatalf("expected the error")
+	}
+	response, errorResponse, replayed := d.once(context.Background(), "key", answer, countedAnswer(&calls))
+	if errorResponse != nil || replayed || response.score != 2 {
+		t.Errorf("expected the retry to be answered: %+v %+v %v", response, errorResponse, replayed)
+	}
+}
+
+func TestDuplicateWaitsForTheFirst(t *testing.T) {
+	d, _ := newTestDeduplicator()
+	var calls atomic.Int32
+	answer := AnswerBody{Answer: "we read the logs"}
+	started, release := make(chan struct{}), make(chan struct{})
+	slowAnswer := func() (*responseToAnswer, *errorResponseType) {
+		close(started)
+		<-release
+		return countedAnswer(&calls)()
+	}
+
+	var first *responseToAnswer
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first, _, _ = d.once(context.Background(), "key", answer, slowAnswer)
+	}()
+	<-started
+
+	impatient, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, errorResponse, _ := d.once(impatient, "key", answer, countedAnswer(&calls)); errorResponse == nil || errorResponse.statusCode != 504 {
+		t.Errorf("expected a duplicate that gives up to get a 504, got %+v", errorResponse)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	second, _, replayed := d.once(context.Background(), "key", answer, countedAnswer(&calls))
+	wg.Wait()
+	if !replayed || second != first || calls.Load() != 1 {
+		t.Errorf("expected the duplicate to wait for the first response: %+v %v, %d calls", second, replayed, calls.Load())
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	answer := AnswerBody{Answer: "we read the logs"}
+	request := func(headers map[string]string) events.APIGatewayV2HTTPRequest {
+		return events.APIGatewayV2HTTPRequest{Headers: headers}
+	}
+	if key := answerIdempotencyKey(request(map[string]string{}), testEventName, v1QuestionId, answer); key != "" {
+		t.Errorf("without an execution id or a header, there's nothing to go by; got %q", key)
+	}
+	withHeader := answerIdempotencyKey(request(map[string]string{IDEMPOTENCY_KEY_HEADER: "abc"}), testEventName, v1QuestionId, answer)
+	if withHeader == "" {
+		t.Errorf("the header is enough on its own")
+	}
+	sameAnswer := answerIdempotencyKey(request(map[string]string{EXECUTION_ID_HEADER: "one"}), testEventName, v1QuestionId, answer)
+	betterAnswer := answerIdempotencyKey(request(map[string]string{EXECUTION_ID_HEADER: "one"}), testEventName, v1QuestionId, AnswerBody{Answer: "we have traces"})
+	if sameAnswer == "" || sameAnswer == betterAnswer {
+		t.Errorf("answering again with something else isn't a retry")
+	}
+}
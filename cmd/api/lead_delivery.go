@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"observaquiz_lambda/cmd/api/store"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Getting leads to the CRM. Two ways:
+ *
+ * GET /api/admin/leads/export is a CSV with the columns a CRM's lead import expects (first name, last name, email, company, lead source).
+ *
+ * lead_webhook_url gets a POST for every new, corrected or withdrawn lead:
+ *
+ *    { "type": "lead.consented" | "lead.withdrawn", "lead": { ...crmLead... }, "sent_at": "..." }
+ *
+ * signed with lead_webhook_secret, Stripe style: X-Observaquiz-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">.
+ * X-Observaquiz-Delivery is the lead id and version, the same on every retry, so the receiver can ignore one it already has.
+ * Anything but a 2xx is retried, waiting twice as long each time (a minute, then two... up to an hour), maxLeadDeliveryAttempts times.
+ * Withdrawn leads go as just the id and when; there's nothing else left to send. Deleting a session withdraws its lead (attendee_data.go).
+ *
+ * Deliveries happen right after a lead changes, and at most once a minute after that. With run_mode=server they go alongside the request.
+ * A Lambda instance is frozen as soon as it responds, so there they go before the response, for at most leadDeliveryInlineTimeout;
+ * whatever doesn't make it waits for a later request. A Lambda instance's store goes with the instance, though: there, the CSV is the one to rely on.
+ * Without both settings, nothing is sent and the CSV is the way.
+ */
+
+const (
+	LEAD_WEBHOOK_TYPE_CONSENTED = "lead.consented"
+	LEAD_WEBHOOK_TYPE_WITHDRAWN = "lead.withdrawn"
+	LEAD_SIGNATURE_HEADER       = "X-Observaquiz-Signature"
+	LEAD_DELIVERY_HEADER        = "X-Observaquiz-Delivery"
+	LEAD_SOURCE                 = "Observaquiz"
+
+	maxLeadDeliveryAttempts = 24
+	leadDeliverySweepEvery  = time.Minute
+	leadWebhookTimeout      = 10 * time.Second
+	// all of one run before a Lambda responds, so a slow webhook doesn't hold the attendee up for long
+	leadDeliveryInlineTimeout = 10 * time.Second
+)
+
+// what the CRM gets, in the webhook and the CSV
+type crmLead struct {
+	Id                    string     `json:"id"`
+	Version               int        `json:"version"`
+	EventName             string     `json:"event_name"`
+	ExecutionId           string     `json:"execution_id,omitempty"`
+	Name                  string     `json:"name,omitempty"`
+	FirstName             string     `json:"first_name,omitempty"`
+	LastName              string     `json:"last_name,omitempty"`
+	Email                 string     `json:"email,omitempty"`
+	Company               string     `json:"company,omitempty"`
+	LeadSource            string     `json:"lead_source"`
+	ContactConsent        bool       `json:"contact_consent"`
+	MarketingConsent      bool       `json:"marketing_consent"`
+	ConsentedAt           *time.Time `json:"consented_at,omitempty"`
+	WithdrawnAt           *time.Time `json:"withdrawn_at,omitempty"`
+	ObservabilityCategory string     `json:"observability_category,omitempty"`
+	Score                 *int       `json:"score,omitempty"`
+	PossibleScore         *int       `json:"possible_score,omitempty"`
+}
+
+type leadWebhookPayload struct {
+	Type   string    `json:"type"`
+	Lead   crmLead   `json:"lead"`
+	SentAt time.Time `json:"sent_at"`
+}
+
+var crmCsvHeader = []string{"First Name", "Last Name", "Email", "Company", "Lead Source", "Email Opt In", "Consented At", "Observability Category", "Score", "Observaquiz Lead Id", "Event"}
+
+func crmLeadFor(lead store.Lead, session store.Session) crmLead {
+	result := crmLead{Id: lead.Id, Version: lead.Version, EventName: lead.EventName, LeadSource: LEAD_SOURCE}
+	if lead.Withdrawn() {
+		result.WithdrawnAt = lead.WithdrawnAt
+		return result
+	}
+	score, possibleScore := session.TotalScore()
+	consentedAt := lead.SubmittedAt
+	result.ExecutionId = lead.ExecutionId
+	result.Name, result.Email, result.Company = lead.Name, lead.Email, lead.Company
+	result.FirstName, result.LastName = splitName(lead.Name)
+	result.ContactConsent, result.MarketingConsent = lead.Consent.Contact, lead.Consent.Marketing
+	result.ConsentedAt = &consentedAt
+	result.ObservabilityCategory = observabilityCategory(session)
+	result.Score, result.PossibleScore = &score, &possibleScore
+	return result
+}
+
+func (lead crmLead) csvRow() []string {
+	consentedAt, score := "", ""
+	if lead.ConsentedAt != nil {
+		consentedAt = lead.ConsentedAt.UTC().Format(time.RFC3339)
+	}
+	if lead.Score != nil {
+		score = strconv.Itoa(*lead.Score)
+	}
+	return []string{store.SpreadsheetSafe(lead.FirstName), store.SpreadsheetSafe(lead.LastName), store.SpreadsheetSafe(lead.Email),
+		store.SpreadsheetSafe(lead.Company), lead.LeadSource, strconv.FormatBool(lead.MarketingConsent),
+		consentedAt, lead.ObservabilityCategory, score, lead.Id, lead.EventName}
+}
+
+// CRMs want a last name. Everything before the last word is the first name; one word is all last name
+func splitName(name string) (firstName string, lastName string) {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return "", ""
+	}
+	return strings.Join(words[:len(words)-1], " "), words[len(words)-1]
+}
+
+// the category of the question they answered most recently that had one
+func observabilityCategory(session store.Session) string {
+	category, at := "", time.Time{}
+	for _, answer := range session.LatestAnswers() {
+		if answer.Category != "" && !answer.AnsweredAt.Before(at) {
+			category, at = answer.Category, answer.AnsweredAt
+		}
+	}
+	return category
+}
+
+/* the webhook */
+
+type leadWebhook struct {
+	lock      sync.Mutex
+	url       string
+	secret    string
+	sweeping  bool
+	lastSweep time.Time
+	inline    bool // deliver before responding, for Lambda
+	now       func() time.Time
+}
+
+var leadDelivery = &leadWebhook{now: time.Now} // main() sets url, secret and inline
+
+func (w *leadWebhook) configured() bool {
+	return w.url != "" && w.secret != ""
+}
+
+// sweepIfDue is for every request: it starts a delivery run if there hasn't been one in a while
+func (w *leadWebhook) sweepIfDue(currentContext context.Context) {
+	w.lock.Lock()
+	due := w.now().Sub(w.lastSweep) >= leadDeliverySweepEvery
+	w.lock.Unlock()
+	if due {
+		w.deliverSoon(currentContext)
+	}
+}
+
+// deliverSoon starts a delivery run alongside the request, unless one is already going. Inline, it runs it there and then
+func (w *leadWebhook) deliverSoon(currentContext context.Context) {
+	if !w.configured() {
+		return
+	}
+	w.lock.Lock()
+	if w.sweeping {
+		w.lock.Unlock()
+		return
+	}
+	w.sweeping = true
+	w.lastSweep = w.now()
+	w.lock.Unlock()
+
+	if w.inline {
+		defer w.doneSweeping()
+		sweepContext, cancel := context.WithTimeout(currentContext, leadDeliveryInlineTimeout)
+		defer cancel()
+		sweepContext, span := tracer.Start(sweepContext, "deliver leads")
+		defer span.End()
+		w.sweep(sweepContext)
+		return
+	}
+	link := trace.LinkFromContext(currentContext)
+	go func() {
+		defer w.doneSweeping()
+		sweepContext, span := tracer.Start(context.Background(), "deliver leads", trace.WithNewRoot(), trace.WithLinks(link))
+		defer span.End()
+		w.sweep(sweepContext)
+	}()
+}
+
+func (w *leadWebhook) doneSweeping() {
+	w.lock.Lock()
+	w.sweeping = false
+	w.lock.Unlock()
+}
+
+func (w *leadWebhook) sweep(currentContext context.Context) {
+	span := trace.SpanFromContext(currentContext)
+	deliveredQty, failedQty := 0, 0
+	for eventName := range eventQuestions {
+		leads, err := attendeeStore.Leads(currentContext, eventName)
+		if err != nil {
+			span.RecordError(err)
+			continue
+		}
+		for _, lead := range leads {
+			if currentContext.Err() != nil {
+				break // out of time; the rest go next run, without counting this as a failed attempt
+			}
+			if !w.due(lead) {
+				continue
+			}
+			session := store.Session{} // a withdrawn lead needs nothing from it, and its session may be deleted
+			if !lead.Withdrawn() {
+				session, _, err = attendeeStore.Session(currentContext, eventName, lead.ExecutionId)
+				if err != nil {
+					span.RecordError(err)
+					continue
+				}
+			}
+			err := w.deliver(currentContext, lead, session)
+			w.recordAttempt(currentContext, lead, err)
+			if err != nil {
+				failedQty++
+			} else {
+				deliveredQty++
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("app.leads.delivered_qty", deliveredQty), attribute.Int("app.leads.failed_qty", failedQty))
+}
+
+func (w *leadWebhook) due(lead store.Lead) bool {
+	if !lead.DeliveryPending() {
+		return false
+	}
+	if lead.Delivery.Attempts == 0 || lead.Delivery.LastAttemptAt == nil {
+		return true
+	}
+	return !w.now().Before(lead.Delivery.LastAttemptAt.Add(leadDeliveryBackoff(lead.Delivery.Attempts)))
+}
+
+func leadDeliveryBackoff(attempts int) time.Duration {
+	if attempts > 7 {
+		return time.Hour
+	}
+	backoff := time.Minute << (attempts - 1)
+	if backoff > time.Hour {
+		return time.Hour
+	}
+	return backoff
+}
+
+func (w *leadWebhook) deliver(currentContext context.Context, lead store.Lead, session store.Session) error {
+	currentContext, span := tracer.Start(currentContext, "deliver lead")
+	defer span.End()
+	payload := leadWebhookPayload{Type: LEAD_WEBHOOK_TYPE_CONSENTED, Lead: crmLeadFor(lead, session), SentAt: w.now().UTC()}
+	if lead.Withdrawn() {
+		payload.Type = LEAD_WEBHOOK_TYPE_WITHDRAWN
+	}
+	span.SetAttributes(attribute.String("app.lead.id", lead.Id),
+		attribute.Int("app.lead.version", lead.Version),
+		attribute.String("app.lead.webhook_type", payload.Type),
+		attribute.Int("app.lead.attempt", lead.Delivery.Attempts+1))
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	currentContext, cancel := context.WithTimeout(currentContext, leadWebhookTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(currentContext, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(LEAD_SIGNATURE_HEADER, signLeadWebhook(w.secret, w.now(), body))
+	request.Header.Set(LEAD_DELIVERY_HEADER, fmt.Sprintf("%s:%d", lead.Id, lead.Version))
+
+	httpClient := http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	span.SetAttributes(attribute.Int("app.lead.webhook_status", response.StatusCode))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		err = fmt.Errorf("the webhook said %d", response.StatusCode)
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+func signLeadWebhook(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%x", timestamp, mac.Sum(nil))
+}
+
+// recordAttempt saves how the delivery went, unless the lead changed while we were sending it; then the new version goes next time
+func (w *leadWebhook) recordAttempt(currentContext context.Context, sent store.Lead, deliveryErr error) {
+	span := trace.SpanFromContext(currentContext)
+	leadLock.Lock()
+	defer leadLock.Unlock()
+	lead, found, err := currentLead(currentContext, sent.EventName, sent.Id)
+	if err != nil || !found || lead.Version != sent.Version {
+		return
+	}
+	attemptedAt := w.now().UTC()
+	lead.Delivery.LastAttemptAt = &attemptedAt
+	if deliveryErr == nil {
+		lead.Delivery.DeliveredVersion = lead.Version
+		lead.Delivery.DeliveredAt = &attemptedAt
+		lead.Delivery.Attempts, lead.Delivery.LastError = 0, ""
+	} else {
+		lead.Delivery.Attempts++
+		lead.Delivery.LastError = deliveryErr.Error()
+		lead.Delivery.GaveUp = lead.Delivery.Attempts >= maxLeadDeliveryAttempts
+	}
+	err = attendeeStore.SaveLead(currentContext, lead)
+	if err != nil {
+		span.RecordError(err)
+	}
+}
+
+// the lead as it is now, whether or not its session is still there
+func currentLead(currentContext context.Context, eventName string, leadId string) (store.Lead, bool, error) {
+	leads, err := attendeeStore.Leads(currentContext, eventName)
+	if err != nil {
+		return store.Lead{}, false, err
+	}
+	for _, lead := range leads {
+		if lead.Id == leadId {
+			return lead, true, nil
+		}
+	}
+	return store.Lead{}, false, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"observaquiz_lambda/cmd/api/store"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const testLeadWebhookSecret = "test-webhook-secret"
+
+type receivedLead struct {
+	payload   leadWebhookPayload
+	delivery  string
+	signature string
+	body      []byte
+}
+
+// a webhook on localhost that answers with whatever status says, and keeps what it was sent.
+// Deliveries are inline, as in Lambda, so they're done by the time the request is
+type testLeadWebhook struct {
+	lock     sync.Mutex
+	received []receivedLead
+	status   int
+	now      time.Time
+}
+
+func withTestLeadWebhook(t *testing.T) *testLeadWebhook {
+	t.Helper()
+	webhook := &testLeadWebhook{status: 200, now: time.Now()}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		received := receivedLead{delivery: request.Header.Get(LEAD_DELIVERY_HEADER), signature: request.Header.Get(LEAD_SIGNATURE_HEADER), body: body}
+		json.Unmarshal(body, &received.payload)
+		webhook.lock.Lock()
+		defer webhook.lock.Unlock()
+		webhook.received = append(webhook.received, received)
+		writer.WriteHeader(webhook.status)
+	}))
+	usual := leadDelivery
+	leadDelivery = &leadWebhook{url: server.URL, secret: testLeadWebhookSecret, inline: true, now: webhook.clock}
+	t.Cleanup(func() {
+		leadDelivery = usual
+		server.Close()
+	})
+	return webhook
+}
+
+func (webhook *testLeadWebhook) clock() time.Time {
+	webhook.lock.Lock()
+	defer webhook.lock.Unlock()
+	return webhook.now
+}
+
+func (webhook *testLeadWebhook) later(by time.Duration, status int) {
+	webhook.lock.Lock()
+	defer webhook.lock.Unlock()
+	webhook.now = webhook.now.Add(by)
+	webhook.status = status
+}
+
+func (webhook *testLeadWebhook) deliveries() []receivedLead {
+	webhook.lock.Lock()
+	defer webhook.lock.Unlock()
+	return append([]receivedLead{}, webhook.received...)
+}
+
+func postTestLead(t *testing.T, executionId string) LeadResponse {
+	t.Helper()
+	request := events.APIGatewayV2HTTPRequest{
+		Headers: map[string]string{"event-name": testEventName, EXECUTION_ID_HEADER: executionId},
+		Body:    `{"name": "Ada Lovelace", "email": "ada@example.com", "company": "Analytical", "consent": {"contact": true}}`,
+	}
+	response, _ := postLead(context.Background(), request)
+	if response.StatusCode != 201 {
+		t.Fatalf("couldn't post the lead: %d %s", response.StatusCode, response.Body)
+	}
+	lead := LeadResponse{}
+	json.Unmarshal([]byte(response.Body), &lead)
+	return lead
+}
+
+func testLead(t *testing.T, leadId string) store.Lead {
+	t.Helper()
+	lead, found, err := currentLead(context.Background(), testEventName, leadId)
+	if err != nil || !found {
+		t.Fatalf("lead %s isn't in the store: %v", leadId, err)
+	}
+	return lead
+}
+
+func TestLeadWebhookIsSigned(t *testing.T) {
+	withFreshStore(t)
+	webhook := withTestLeadWebhook(t)
+	postTestLead(t, "signed-lead")
+
+	deliveries := webhook.deliveries()
+	if len(deliveries) != 1 {
+		t.Fatalf("expected one delivery, got %d", len(deliveries))
+	}
+	delivered := deliveries[0]
+	if delivered.payload.Type != LEAD_WEBHOOK_TYPE_CONSENTED || delivered.payload.Lead.Email != "ada@example.com" || delivered.payload.Lead.LastName != "Lovelace" {
+		t.Errorf("unexpected payload: %s", delivered.body)
+	}
+
+	timestamp, signature, _ := strings.Cut(strings.TrimPrefix(delivered.signature, "t="), ",v1=")
+	mac := hmac.New(sha256.New, []byte(testLeadWebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(delivered.body)
+	if signature != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("the signature %q doesn't match the body", delivered.signature)
+	}
+}
+
+func TestFailedLeadDeliveryIsRetriedLater(t *testing.T) {
+	withFreshStore(t)
+	webhook := withTestLeadWebhook(t)
+	webhook.status = 500
+	lead := postTestLead(t, "retried-lead")
+
+	stored := testLead(t, lead.Id)
+	if stored.Delivery.Attempts != 1 || stored.Delivery.LastError == "" || !stored.DeliveryPending() {
+		t.Fatalf("expected one failed attempt, still pending: %+v", stored.Delivery)
+	}
+
+	// not due yet: the first retry waits a minute
+	webhook.later(30*time.Second, 200)
+	leadDelivery.deliverSoon(context.Background())
+	if len(webhook.deliveries()) != 1 {
+		t.Fatalf("retried before the backoff was up")
+	}
+
+	webhook.later(time.Minute, 200)
+	leadDelivery.deliverSoon(context.Background())
+	deliveries := webhook.deliveries()
+	if len(deliveries) != 2 {
+		t.Fatalf("expected a retry once the backoff was up, got %d deliveries", len(deliveries))
+	}
+	if deliveries[0].delivery != deliveries[1].delivery {
+		t.Errorf("a retry should have the same %s: %q then %q", LEAD_DELIVERY_HEADER, deliveries[0].delivery, deliveries[1].delivery)
+	}
+	stored = testLead(t, lead.Id)
+	if stored.DeliveryPending() || stored.Delivery.Attempts != 0 || stored.Delivery.DeliveredVersion != 1 {
+		t.Errorf("expected it delivered: %+v", stored.Delivery)
+	}
+}
+
+func TestDeletingASessionWithdrawsItsLead(t *testing.T) {
+	withFreshStore(t)
+	webhook := withTestLeadWebhook(t)
+	executionId, attendeeApiKey := "deleted-with-a-lead", "lead-deleting-key"
+	startTestSession(t, executionId, attendeeApiKey)
+	lead := postTestLead(t, executionId)
+
+	request := events.APIGatewayV2HTTPRequest{Headers: map[string]string{ATTENDEE_API_KEY_HEADER: attendeeApiKey}}
+	response, _ := deleteAttendeeData(context.Background(), request)
+	if response.StatusCode != 200 {
+		t.Fatalf("couldn't delete: %d %s", response.StatusCode, response.Body)
+	}
+
+	deliveries := webhook.deliveries()
+	if len(deliveries) != 2 {
+		t.Fatalf("expected the lead, then its withdrawal; got %d deliveries", len(deliveries))
+	}
+	withdrawal := deliveries[1].payload
+	if withdrawal.Type != LEAD_WEBHOOK_TYPE_WITHDRAWN || withdrawal.Lead.Id != lead.Id || withdrawal.Lead.Version != 2 || withdrawal.Lead.Email != "" {
+		t.Errorf("expected version 2 of %s, withdrawn, with nothing about them: %s", lead.Id, deliveries[1].body)
+	}
+
+	if _, found, _ := attendeeStore.Session(context.Background(), testEventName, executionId); found {
+		t.Errorf("the session is still in the store")
+	}
+	stored := testLead(t, lead.Id)
+	if !stored.Withdrawn() || stored.Email != "" || stored.DeliveryPending() {
+		t.Errorf("expected the withdrawal kept, and delivered: %+v", stored)
+	}
+}
+
+func TestLeadExportEscapesFormulas(t *testing.T) {
+	row := crmLead{FirstName: "Ada", LastName: "=HYPERLINK(\"http://example.com\")", Email: "ada@example.com", Company: "@Analytical"}.csvRow()
+	if row[0] != "Ada" || row[1] != "'=HYPERLINK(\"http://example.com\")" || row[2] != "ada@example.com" || row[3] != "'@Analytical" {
+		t.Errorf("expected what they typed to be text to a spreadsheet: %q", row)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * The leaderboard, for the booth screen: everyone in the event, by total score.
+ *
+ *    GET /api/events/{event}/leaderboard?window=today&limit=10&offset=0
+ *
+ * A total is the latest score for each question they answered. window=event (the default) counts everything;
+ * window=today counts only answers since midnight UTC. People with the same total get the same rank (1, 2, 2, 4),
+ * and whoever got there first is listed first.
+ *
+ * Attendees pick what they're called with POST /api/nickname. Otherwise they have no name on the board.
+ */
+
+const (
+	LEADERBOARD_WINDOW_EVENT = "event"
+	LEADERBOARD_WINDOW_TODAY = "today"
+
+	default_leaderboard_limit = 10
+	maximumLeaderboardLimit   = 100
+	maximumNicknameLength     = 24
+)
+
+var getLeaderboardEndpoint = apiEndpoint{
+	"GET",
+	"/api/events/{event}/leaderboard",
+	regexp.MustCompile("^/api/events/[^/]+/leaderboard$"),
+	getLeaderboard,
+	false, // the event is in the path, not the header
+}
+
+type Leaderboard struct {
+	EventName string             `json:"event_name"`
+	Window    string             `json:"window"`
+	TotalQty  int                `json:"total_qty"` // everyone on the board, not just this page
+	Offset    int                `json:"offset"`
+	Limit     int                `json:"limit"`
+	Entries   []LeaderboardEntry `json:"entries"`
+}
+
+type LeaderboardEntry struct {
+	Rank              int    `json:"rank"`
+	Tied              bool   `json:"tied"`
+	Nickname          string `json:"nickname,omitempty"`
+	Score             int    `json:"score"`
+	PossibleScore     int    `json:"possible_score"`
+	QuestionsAnswered int    `json:"questions_answered"`
+
+	reachedAt time.Time // when they got to this score. Earlier goes first, among ties
+}
+
+func getLeaderboard(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+
+	eventName := strings.Split(request.RequestContext.HTTP.Path, "/")[3]
+	if _, eventFound := eventQuestions[eventName]; !eventFound {
+		return instrumentation.ErrorResponse(fmt.Sprintf("Couldn't find event name %s", eventName), 404), nil
+	}
+
+	window := request.QueryStringParameters["window"]
+	if window == "" {
+		window = LEADERBOARD_WINDOW_EVENT
+	}
+	if window != LEADERBOARD_WINDOW_EVENT && window != LEADERBOARD_WINDOW_TODAY {
+		return instrumentation.ErrorResponse("window is event or today", 400), nil
+	}
+	limit, err := queryInt(request, "limit", default_leaderboard_limit)
+	if err != nil || limit < 1 || limit > maximumLeaderboardLimit {
+		return instrumentation.ErrorResponse(fmt.Sprintf("limit is a number from 1 to %d", maximumLeaderboardLimit), 400), nil
+	}
+	offset, err := queryInt(request, "offset", 0)
+	if err != nil || offset < 0 {
+		return instrumentation.ErrorResponse("offset is a number, 0 or more", 400), nil
+	}
+	span.SetAttributes(attribute.String("app.leaderboard.event_name", eventName),
+		attribute.String("app.leaderboard.window", window),
+		attribute.Int("app.leaderboard.limit", limit),
+		attribute.Int("app.leaderboard.offset", offset))
+
+	sessions, err := attendeeStore.Sessions(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the attendee store", 500), nil
+	}
+	entries := rankSessions(sessions, leaderboardWindowStart(window, time.Now().UTC()))
+	span.SetAttributes(attribute.Int("app.leaderboard.total_qty", len(entries)))
+
+	leaderboard := Leaderboard{EventName: eventName, Window: window, TotalQty: len(entries), Offset: offset, Limit: limit, Entries: []LeaderboardEntry{}}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		leaderboard.Entries = entries[offset:end]
+	}
+
+	leaderboardJson, err := json.Marshal(leaderboard)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(leaderboardJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
+
+func queryInt(request events.APIGatewayV2HTTPRequest, name string, defaultValue int) (int, error) {
+	value := request.QueryStringParameters[name]
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// zero means since forever
+func leaderboardWindowStart(window string, now time.Time) time.Time {
+	if window == LEADERBOARD_WINDOW_TODAY {
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// rankSessions totals each session's answers since windowStart, and ranks them. Sessions with no answers in the window are left off,
+// and so is "unset": that's everyone who didn't send an execution id, not one attendee. Stores from before we stopped keeping those can still have it.
+func rankSessions(sessions []store.Session, windowStart time.Time) []LeaderboardEntry {
+	entries := []LeaderboardEntry{}
+	for _, session := range sessions {
+		if session.ExecutionId == "unset" {
+			continue
+		}
+		inWindow := session
+		inWindow.Answers = []store.Answer{}
+		for _, answer := range session.Answers {
+			if !answer.AnsweredAt.Before(windowStart) {
+				inWindow.Answers = append(inWindow.Answers, answer)
+			}
+		}
+		if len(inWindow.Answers) == 0 {
+			continue
+		}
+		score, possibleScore := inWindow.TotalScore()
+		entries = append(entries, LeaderboardEntry{
+			Nickname:          session.Nickname,
+			Score:             score,
+			PossibleScore:     possibleScore,
+			QuestionsAnswered: len(inWindow.LatestAnswers()),
+			reachedAt:         inWindow.Answers[len(inWindow.Answers)-1].AnsweredAt,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].reachedAt.Before(entries[j].reachedAt)
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Score == entries[i-1].Score {
+			entries[i].Rank = entries[i-1].Rank
+			entries[i].Tied = true
+			entries[i-1].Tied = true
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries
+}
+
+/* nicknames */
+
+var postNicknameEndpoint = apiEndpoint{
+	"POST",
+	"/api/nickname",
+	regexp.MustCompile("^/api/nickname$"),
+	postNickname,
+	true,
+}
+
+type NicknameBody struct {
+	Nickname string `json:"nickname"`
+}
+
+// Nicknames always go through the word list, whatever the moderation setting: they're on the big screen for the whole event.
+var nicknameModerator moderator = newWordListModerator()
+
+func postNickname(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	currentContext, span := tracer.Start(currentContext, "set nickname")
+	defer span.End()
+
+	body := NicknameBody{}
+	err := json.Unmarshal([]byte(request.Body), &body)
+	if err != nil {
+		span.RecordError(fmt.Errorf("error unmarshalling nickname: %w\n request body: %s", err, request.Body))
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'nickname': 'stuff' }", 400), nil
+	}
+
+	eventName := getEventName(request)
+	executionId := getExecutionId(request)
+	if executionId == "unset" {
+		return instrumentation.ErrorResponse(fmt.Sprintf("Send the %s header, so we know whose nickname it is", EXECUTION_ID_HEADER), 400), nil
+	}
+	nickname, problem := cleanNickname(body.Nickname)
+	span.SetAttributes(attribute.String("app.nickname.event_name", eventName),
+		attribute.String("app.nickname.execution_id", executionId),
+		attribute.String("app.nickname.nickname", nickname))
+	if problem != "" {
+		span.SetAttributes(attribute.String("app.nickname.rejected", problem))
+		return instrumentation.ErrorResponse(problem, 400), nil
+	}
+	if errorResponse := checkSessionOwner(currentContext, request, eventName, executionId); errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+
+	currentContext = costs.WithScope(currentContext, costs.Scope{EventName: eventName, ExecutionId: executionId, AttendeeKeyHash: attendeeKeyHashOf(request)})
+	if moderateTextWith(currentContext, nicknameModerator, "nickname", nickname).flagged {
+		span.SetAttributes(attribute.String("app.nickname.rejected", "moderation"))
+		return instrumentation.ErrorResponse("Let's keep it friendly for the booth screen! Please pick a different nickname.", 422), nil
+	}
+
+	err = attendeeStore.SetNickname(currentContext, store.Nickname{EventName: eventName, ExecutionId: executionId, Nickname: nickname, SetAt: time.Now().UTC()})
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't save the nickname", 500), nil
+	}
+
+	nicknameJson, _ := json.Marshal(NicknameBody{Nickname: nickname})
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(nicknameJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
+
+// cleanNickname tidies up the spaces. problem is why we won't take it, or empty if we will.
+func cleanNickname(nickname string) (cleaned string, problem string) {
+	cleaned = strings.Join(strings.Fields(nickname), " ")
+	if cleaned == "" {
+		return cleaned, "A nickname needs at least one letter"
+	}
+	if utf8.RuneCountInString(cleaned) > maximumNicknameLength {
+		return cleaned, fmt.Sprintf("A nickname can be at most %d characters", maximumNicknameLength)
+	}
+	for _, r := range cleaned {
+		if !unicode.IsPrint(r) {
+			return cleaned, "A nickname can only have printable characters"
+		}
+	}
+	return cleaned, ""
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"observaquiz_lambda/cmd/api/store"
+	"testing"
+	"time"
+)
+
+func TestRankSessionsLeavesOutUnset(t *testing.T) {
+	answeredAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	sessions := []store.Session{
+		{ExecutionId: "unset", Answers: []store.Answer{{QuestionId: "q1", Score: 100, PossibleScore: 100, AnsweredAt: answeredAt}}},
+		{ExecutionId: "someone", Nickname: "someone", Answers: []store.Answer{{QuestionId: "q1", Score: 40, PossibleScore: 100, AnsweredAt: answeredAt}}},
+	}
+
+	entries := rankSessions(sessions, time.Time{})
+	if len(entries) != 1 || entries[0].Nickname != "someone" {
+		t.Errorf("expected only the attendee with an execution id, got %+v", entries)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Leads: attendees who want to hear from us. Optional; nothing in the game asks for this.
+ *
+ *    POST   /api/leads                      { "name": "...", "email": "...", "company": "...", "consent": { "contact": true, "marketing": false } }
+ *    DELETE /api/leads                      they changed their mind
+ *    GET    /api/admin/leads                every lead, withdrawn ones too, with how delivery is going
+ *    GET    /api/admin/leads/export         CSV for the CRM's import: consented leads only
+ *    POST   /api/admin/leads/withdraw       { "email": "..." }, for when they ask us instead
+ *    POST   /api/admin/leads/redeliver      try again for the ones delivery gave up on
+ *
+ * Both attendee endpoints need the x-observaquiz-execution-id header: a lead belongs to the session,
+ * and goes to the CRM with that session's observability category and score.
+ * Without consent.contact we don't keep it at all. Withdrawing erases name, email and company, and tells the CRM (lead_delivery.go).
+ */
+
+const maximumLeadFieldLength = 200
+
+var postLeadEndpoint = apiEndpoint{
+	"POST",
+	"/api/leads",
+	regexp.MustCompile("^/api/leads$"),
+	postLead,
+	true,
+}
+
+var deleteLeadEndpoint = apiEndpoint{
+	"DELETE",
+	"/api/leads",
+	regexp.MustCompile("^/api/leads$"),
+	deleteLead,
+	true,
+}
+
+var getLeadsEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/leads",
+	regexp.MustCompile("^/api/admin/leads$"),
+	adminOnly(getLeads),
+	true,
+}
+
+var getLeadsExportEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/leads/export",
+	regexp.MustCompile("^/api/admin/leads/export$"),
+	adminOnly(getLeadsExport),
+	true,
+}
+
+var postLeadWithdrawalEndpoint = apiEndpoint{
+	"POST",
+	"/api/admin/leads/withdraw",
+	regexp.MustCompile("^/api/admin/leads/withdraw$"),
+	adminOnly(postLeadWithdrawal),
+	true,
+}
+
+var postLeadRedeliveryEndpoint = apiEndpoint{
+	"POST",
+	"/api/admin/leads/redeliver",
+	regexp.MustCompile("^/api/admin/leads/redeliver$"),
+	adminOnly(postLeadRedelivery),
+	true,
+}
+
+// held while a lead is read, changed, and saved, so a delivery result doesn't overwrite a withdrawal
+var leadLock sync.Mutex
+
+type PostLeadBody struct {
+	Name    string            `json:"name"`
+	Email   string            `json:"email"`
+	Company string            `json:"company"`
+	Consent store.LeadConsent `json:"consent"`
+}
+
+type LeadResponse struct {
+	Id          string            `json:"id"`
+	Consent     store.LeadConsent `json:"consent"`
+	SubmittedAt time.Time         `json:"submitted_at"`
+	WithdrawnAt *time.Time        `json:"withdrawn_at,omitempty"`
+}
+
+type PostLeadWithdrawalBody struct {
+	Email string `json:"email"`
+}
+
+type LeadsResponse struct {
+	EventName string       `json:"event_name"`
+	Leads     []store.Lead `json:"leads"`
+}
+
+func postLead(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	executionId := getExecutionId(request)
+	if executionId == "unset" {
+		return instrumentation.ErrorResponse(fmt.Sprintf("Send the %s header, so we know which session this goes with", EXECUTION_ID_HEADER), 400), nil
+	}
+	body := PostLeadBody{}
+	err := json.Unmarshal([]byte(request.Body), &body)
+	if err != nil {
+		span.RecordError(fmt.Errorf("error unmarshalling lead: %w", err)) // not the body: it has their email in it
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'name': '...', 'email': '...', 'company': '...', 'consent': { 'contact': true, 'marketing': false } }", 400), nil
+	}
+	if problem := checkLead(&body); problem != "" {
+		return instrumentation.ErrorResponse(problem, 400), nil
+	}
+
+	eventName := getEventName(request)
+	defer leadDelivery.deliverSoon(currentContext) // once leadLock is let go: delivering takes it
+	leadLock.Lock()
+	defer leadLock.Unlock()
+	session, found, err := attendeeStore.Session(currentContext, eventName, executionId)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the attendee store", 500), nil
+	}
+	lead := store.Lead{Id: uuid.NewString(), Version: 1}
+	if found && session.Lead != nil {
+		// the same lead, corrected: the CRM updates it rather than getting another
+		lead.Id = session.Lead.Id
+		lead.Version = session.Lead.Version + 1
+		lead.Delivery = store.LeadDelivery{DeliveredVersion: session.Lead.Delivery.DeliveredVersion, DeliveredAt: session.Lead.Delivery.DeliveredAt}
+	}
+	lead.EventName, lead.ExecutionId = eventName, executionId
+	lead.Name, lead.Email, lead.Company, lead.Consent = body.Name, body.Email, body.Company, body.Consent
+	lead.SubmittedAt = time.Now().UTC()
+	span.SetAttributes(attribute.String("app.lead.id", lead.Id),
+		attribute.Int("app.lead.version", lead.Version),
+		attribute.Bool("app.lead.consent.marketing", lead.Consent.Marketing))
+
+	err = attendeeStore.SaveLead(currentContext, lead)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't save your details", 500), nil
+	}
+	return leadJsonResponse(currentContext, leadResponse(lead), 201)
+}
+
+// checkLead tidies the body up, and says what's wrong with it
+func checkLead(body *PostLeadBody) string {
+	if !body.Consent.Contact {
+		return "We only keep your details if you agree that we can contact you (consent.contact: true)"
+	}
+	body.Name, body.Company = strings.TrimSpace(body.Name), strings.TrimSpace(body.Company)
+	if body.Name == "" {
+		return "Please tell us your name"
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(body.Email))
+	if err != nil || address.Name != "" {
+		return "That doesn't look like an email address"
+	}
+	body.Email = address.Address
+	for _, field := range []string{body.Name, body.Email, body.Company} {
+		if utf8.RuneCountInString(field) > maximumLeadFieldLength {
+			return fmt.Sprintf("Please keep each field under %d characters", maximumLeadFieldLength)
+		}
+	}
+	return ""
+}
+
+func deleteLead(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	executionId := getExecutionId(request)
+	if executionId == "unset" {
+		return instrumentation.ErrorResponse(fmt.Sprintf("Send the %s header, so we know which session this goes with", EXECUTION_ID_HEADER), 400), nil
+	}
+	eventName := getEventName(request)
+	defer leadDelivery.deliverSoon(currentContext) // once leadLock is let go: delivering takes it
+	leadLock.Lock()
+	defer leadLock.Unlock()
+	session, found, err := attendeeStore.Session(currentContext, eventName, executionId)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the attendee store", 500), nil
+	}
+	if !found || session.Lead == nil {
+		return instrumentation.ErrorResponse("We don't have your details", 404), nil
+	}
+	lead, err := withdrawLead(currentContext, *session.Lead)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't withdraw your consent. Please try again", 500), nil
+	}
+	return leadJsonResponse(currentContext, leadResponse(lead), 200)
+}
+
+// withdrawLead expects leadLock to be held. Whoever lets go of it delivers the withdrawal
+func withdrawLead(currentContext context.Context, lead store.Lead) (store.Lead, error) {
+	if lead.Withdrawn() {
+		return lead, nil
+	}
+	span := trace.SpanFromContext(currentContext)
+	lead = lead.Withdraw(time.Now().UTC())
+	span.SetAttributes(attribute.String("app.lead.id", lead.Id), attribute.Bool("app.lead.withdrawn", true))
+	err := attendeeStore.SaveLead(currentContext, lead)
+	return lead, err
+}
+
+func getLeads(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	eventName := getEventName(request)
+	leads, err := attendeeStore.Leads(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the leads", 500), nil
+	}
+	span.SetAttributes(attribute.Int("app.leads.qty", len(leads)))
+	return leadJsonResponse(currentContext, LeadsResponse{EventName: eventName, Leads: leads}, 200)
+}
+
+func getLeadsExport(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	eventName := getEventName(request)
+	sessions, err := attendeeStore.Sessions(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the attendee store", 500), nil
+	}
+
+	body := strings.Builder{}
+	writer := csv.NewWriter(&body)
+	writer.Write(crmCsvHeader)
+	exportedQty := 0
+	for _, session := range sessions {
+		if session.Lead == nil || !session.Lead.Consented() {
+			continue
+		}
+		writer.Write(crmLeadFor(*session.Lead, session).csvRow())
+		exportedQty++
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't write the export", 500), nil
+	}
+	span.SetAttributes(attribute.Int("app.leads.exported_qty", exportedQty))
+	return events.APIGatewayV2HTTPResponse{
+		Body: body.String(),
+		Headers: map[string]string{
+			"Content-Type":        "text/csv",
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", eventName+"-leads.csv"),
+		},
+		StatusCode: 200}, nil
+}
+
+func postLeadWithdrawal(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	body := PostLeadWithdrawalBody{}
+	err := json.Unmarshal([]byte(request.Body), &body)
+	if err != nil || strings.TrimSpace(body.Email) == "" {
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'email': '...' }", 400), nil
+	}
+	eventName := getEventName(request)
+	defer leadDelivery.deliverSoon(currentContext)
+	leadLock.Lock()
+	defer leadLock.Unlock()
+	leads, err := attendeeStore.Leads(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the leads", 500), nil
+	}
+	withdrawn := []LeadResponse{}
+	for _, lead := range leads {
+		if lead.Withdrawn() || !strings.EqualFold(lead.Email, strings.TrimSpace(body.Email)) {
+			continue
+		}
+		lead, err = withdrawLead(currentContext, lead)
+		if err != nil {
+			span.RecordError(err)
+			return instrumentation.ErrorResponse("Couldn't withdraw all of them. Try again", 500), nil
+		}
+		withdrawn = append(withdrawn, leadResponse(lead))
+	}
+	span.SetAttributes(attribute.Int("app.leads.withdrawn_qty", len(withdrawn)))
+	return leadJsonResponse(currentContext, withdrawn, 200)
+}
+
+func postLeadRedelivery(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	eventName := getEventName(request)
+	defer leadDelivery.deliverSoon(currentContext)
+	leadLock.Lock()
+	defer leadLock.Unlock()
+	leads, err := attendeeStore.Leads(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the leads", 500), nil
+	}
+	retried := []store.Lead{}
+	for _, lead := range leads {
+		if !lead.Delivery.GaveUp {
+			continue
+		}
+		lead.Delivery.GaveUp, lead.Delivery.Attempts = false, 0
+		err = attendeeStore.SaveLead(currentContext, lead)
+		if err != nil {
+			span.RecordError(err)
+			return instrumentation.ErrorResponse("Couldn't save the leads", 500), nil
+		}
+		retried = append(retried, lead)
+	}
+	span.SetAttributes(attribute.Int("app.leads.redelivered_qty", len(retried)))
+	return leadJsonResponse(currentContext, LeadsResponse{EventName: eventName, Leads: retried}, 200)
+}
+
+func leadResponse(lead store.Lead) LeadResponse {
+	return LeadResponse{Id: lead.Id, Consent: lead.Consent, SubmittedAt: lead.SubmittedAt, WithdrawnAt: lead.WithdrawnAt}
+}
+
+func leadJsonResponse(currentContext context.Context, body interface{}, statusCode int) (events.APIGatewayV2HTTPResponse, error) {
+	leadJson, err := json.Marshal(body)
+	if err != nil {
+		trace.SpanFromContext(currentContext).RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(leadJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: statusCode}, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Every LLM call is checked against the daily budget before it goes, and added to the cost ledger after.
+ * Past the cap, the call fails with errOverBudget, and each caller falls back to something that doesn't need the LLM:
+ *
+ *    v1 answers and the v2 response      overBudgetResponse, no points from the LLM
+ *    v2 scoring prompts                  skipped; the pointy words still count
+ *    guard classifier                    the heuristics alone
+ *    llm moderation                      the word list
+ *    conversation                        overBudgetResponse, and the turn doesn't count
+ *    session report                      503, with a pointer to the summary
+ */
+
+const overBudgetResponse = "I've been chatting all day and I'm out of words! Thanks for your answer."
+
+var errOverBudget = errors.New("over today's LLM budget")
+
+type budgetedLlmClient struct {
+	ledger *costs.Ledger
+	inner  llmClient
+}
+
+func (c budgetedLlmClient) check(currentContext context.Context) error {
+	overBudget, reason := costs.LedgerFrom(currentContext, c.ledger).OverBudget(costs.ScopeFrom(currentContext))
+	if !overBudget {
+		return nil
+	}
+	trace.SpanFromContext(currentContext).SetAttributes(attribute.Bool("app.cost.degraded", true),
+		attribute.String("app.cost.degraded_reason", reason))
+	return fmt.Errorf("%w: %s", errOverBudget, reason)
+}
+
+func (c budgetedLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	err := c.check(currentContext)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	response, err := c.inner.complete(currentContext, request)
+	if err == nil {
+		costs.LedgerFrom(currentContext, c.ledger).Record(currentContext, request.Model, response.Usage.PromptTokens, response.Usage.CompletionTokens, false)
+	}
+	return response, err
+}
+
+// the streaming API doesn't say how many tokens it used, so this guesses from the text
+func (c budgetedLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	err := c.check(currentContext)
+	if err != nil {
+		return "", err
+	}
+	output, err := c.inner.completeStreaming(currentContext, request, onToken)
+	promptTokens := 0
+	for _, message := range request.Messages {
+		promptTokens += costs.EstimateTokens(message.Content)
+	}
+	costs.LedgerFrom(currentContext, c.ledger).Record(currentContext, request.Model, promptTokens, costs.EstimateTokens(output), true)
+	return output, err
+}
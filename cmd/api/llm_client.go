@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Everything that talks to OpenAI goes through an llmClient, so we can record what it said and play it back later.
+ *
+ * llm_mode=record   talk to OpenAI, and write each request and response to llm_fixtures_dir
+ * llm_mode=replay   never talk to OpenAI; answer from llm_fixtures_dir, or fail
+ *
+ * Fixtures are named by a hash of the request (model, response format, the rendered messages).
+ * Change a prompt, and its hash changes, so replay will tell you which requests no longer match.
+ * Those go in llm_fixtures_dir/missed/, so you can diff them against what was recorded.
+ */
+
+const (
+	LLM_MODE_LIVE               = ""
+	LLM_MODE_RECORD             = "record"
+	LLM_MODE_REPLAY             = "replay"
+	default_llm_fixtures_dir    = "llm_fixtures"
+	replayedTokenSeparatorChars = " \n"
+)
+
+type llmClient interface {
+	complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	// calls onToken with each piece as it arrives, and returns the whole thing at the end
+	completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error)
+}
+
+// the budget first, so a call that can't go doesn't wait in line for nothing
+func newLlmClient(key string) llmClient {
+	return budgetedLlmClient{ledger: costLedger, inner: limitedLlmClient{limiter: llmCallLimiter, inner: newUnlimitedLlmClient(key)}}
+}
+
+func newUnlimitedLlmClient(key string) llmClient {
+	fixturesDir := settings.LlmFixturesDir
+	if fixturesDir == "" {
+		fixturesDir = default_llm_fixtures_dir
+	}
+	switch settings.LlmMode {
+	case LLM_MODE_RECORD:
+		return recordingLlmClient{live: newOpenaiClient(key), fixturesDir: fixturesDir}
+	case LLM_MODE_REPLAY:
+		return replayingLlmClient{fixturesDir: fixturesDir}
+	}
+	return newOpenaiClient(key)
+}
+
+/* the real thing */
+
+type openaiClient struct {
+	client *openai.Client
+}
+
+func newOpenaiClient(key string) openaiClient {
+	httpClient := http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+
+	openAIConfig := openai.DefaultConfig(key)
+	openAIConfig.HTTPClient = &httpClient
+	return openaiClient{client: openai.NewClientWithConfig(openAIConfig)}
+}
+
+func (c openaiClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return c.client.CreateChatCompletion(currentContext, request)
+}
+
+func (c openaiClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	span := trace.SpanFromContext(currentContext)
+	stream, err := c.client.CreateChatCompletionStream(currentContext, request)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	fullResponse := strings.Builder{}
+	tokenCount := 0
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fullResponse.String(), err
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		span.SetAttributes(attribute.String("app.llm.response_id", chunk.ID))
+		token := chunk.Choices[0].Delta.Content
+		if token == "" {
+			continue
+		}
+		tokenCount++
+		fullResponse.WriteString(token)
+		onToken(token)
+	}
+
+	// the streaming API doesn't report usage, so this counts chunks, not billed tokens
+	span.SetAttributes(attribute.String("app.llm.output", fullResponse.String()),
+		attribute.Int("app.llm.streamed_chunks", tokenCount))
+	return fullResponse.String(), nil
+}
+
+/* fixtures */
+
+type llmFixture struct {
+	Hash           string                         `json:"hash"`
+	Model          string                         `json:"model"`
+	ResponseFormat string                         `json:"response_format"`
+	Messages       []openai.ChatCompletionMessage `json:"messages"` // the rendered prompt
+	RawOutput      string                         `json:"raw_output"`
+	Usage          openai.Usage                   `json:"usage"`
+	RecordedAt     time.Time                      `json:"recorded_at"`
+}
+
+// Only the parts of the request that change what the LLM says. Streaming or not doesn't count.
+func hashLlmRequest(request openai.ChatCompletionRequest) string {
+	responseFormat := ""
+	if request.ResponseFormat != nil {
+		responseFormat = string(request.ResponseFormat.Type)
+	}
+	identity, _ := json.Marshal(struct {
+		Model          string                         `json:"model"`
+		ResponseFormat string                         `json:"response_format"`
+		Messages       []openai.ChatCompletionMessage `json:"messages"`
+		MaxTokens      int                            `json:"max_tokens"`
+		Temperature    float32                        `json:"temperature"`
+		Seed           *int                           `json:"seed,omitempty"`
+	}{request.Model, responseFormat, request.Messages, request.MaxTokens, request.Temperature, request.Seed})
+	return fmt.Sprintf("%x", sha256.Sum256(identity))
+}
+
+func fixtureFromRequest(request openai.ChatCompletionRequest) llmFixture {
+	fixture := llmFixture{
+		Hash:       hashLlmRequest(request),
+		Model:      request.Model,
+		Messages:   request.Messages,
+		RecordedAt: time.Now().UTC(),
+	}
+	if request.ResponseFormat != nil {
+		fixture.ResponseFormat = string(request.ResponseFormat.Type)
+	}
+	return fixture
+}
+
+func writeFixture(dir string, fixture llmFixture) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	fixtureJson, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, fixture.Hash+".json"), fixtureJson, 0644)
+}
+
+/* record */
+
+type recordingLlmClient struct {
+	live        llmClient
+	fixturesDir string
+}
+
+func (c recordingLlmClient) record(currentContext context.Context, fixture llmFixture) {
+	span := trace.SpanFromContext(currentContext)
+	span.SetAttributes(attribute.String("app.llm.fixture_hash", fixture.Hash))
+	err := writeFixture(c.fixturesDir, fixture)
+	if err != nil {
+		// recording is for us, not the attendee. Don't fail their request over it
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure recording LLM fixture")))
+	}
+}
+
+func (c recordingLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	response, err := c.live.complete(currentContext, request)
+	if err != nil || len(response.Choices) == 0 {
+		return response, err
+	}
+	fixture := fixtureFromRequest(request)
+	fixture.RawOutput = response.Choices[0].Message.Content
+	fixture.Usage = response.Usage
+	c.record(currentContext, fixture)
+	return response, nil
+}
+
+func (c recordingLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	output, err := c.live.completeStreaming(currentContext, request, onToken)
+	if err != nil {
+		return output, err
+	}
+	fixture := fixtureFromRequest(request)
+	fixture.RawOutput = output
+	c.record(currentContext, fixture)
+	return output, nil
+}
+
+/* replay */
+
+type replayingLlmClient struct {
+	fixturesDir string
+}
+
+func (c replayingLlmClient) find(currentContext context.Context, request openai.ChatCompletionRequest) (llmFixture, error) {
+	span := trace.SpanFromContext(currentContext)
+	hash := hashLlmRequest(request)
+	span.SetAttributes(attribute.String("app.llm.fixture_hash", hash), attribute.Bool("app.llm.replayed", true))
+
+	fixture := llmFixture{}
+	fixtureJson, err := os.ReadFile(filepath.Join(c.fixturesDir, hash+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		// leave the request where someone can diff it against the recordings
+		writeFixture(filepath.Join(c.fixturesDir, "missed"), fixtureFromRequest(request))
+		span.SetAttributes(attribute.Bool("app.llm.fixture_missing", true))
+		return fixture, fmt.Errorf("no LLM fixture for request %s; it is in %s/missed", hash, c.fixturesDir)
+	}
+	if err != nil {
+		return fixture, err
+	}
+	err = json.Unmarshal(fixtureJson, &fixture)
+	return fixture, err
+}
+
+func (c replayingLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	fixture, err := c.find(currentContext, request)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return openai.ChatCompletionResponse{
+		ID:    "replay-" + fixture.Hash,
+		Model: fixture.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: fixture.RawOutput},
+			FinishReason: openai.FinishReasonStop,
+		}},
+		Usage: fixture.Usage,
+	}, nil
+}
+
+// streams it back a word at a time, so the streaming code gets exercised too
+func (c replayingLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	fixture, err := c.find(currentContext, request)
+	if err != nil {
+		return "", err
+	}
+	remaining := fixture.RawOutput
+	for remaining != "" {
+		next := strings.IndexAny(remaining[1:], replayedTokenSeparatorChars)
+		if next == -1 {
+			onToken(remaining)
+			break
+		}
+		onToken(remaining[:next+1])
+		remaining = remaining[next+1:]
+	}
+	return fixture.RawOutput, nil
+}
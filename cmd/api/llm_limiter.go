@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Each answer is a handful of LLM calls: category, response, and one per scoring prompt, mostly at the same time.
+ * At a busy booth, that's a lot of simultaneous requests to OpenAI, and then rate limit errors.
+ *
+ * So every LLM call waits its turn for one of llm_concurrency slots, shared by all requests in this instance.
+ * When a slot frees up, the response stage goes first: the attendee is watching it arrive.
+ * Scoring goes last; the score can come a bit later.
+ */
+
+const default_llm_concurrency = 10
+
+type llmPriority int
+
+const (
+	llmPriorityResponse llmPriority = iota // category and response: the attendee is waiting on these
+	llmPriorityDefault                     // guard, moderation, conversation, v1
+	llmPriorityScoring
+	llmPriorityCount
+)
+
+func (p llmPriority) String() string {
+	switch p {
+	case llmPriorityResponse:
+		return "response"
+	case llmPriorityScoring:
+		return "scoring"
+	default:
+		return "default"
+	}
+}
+
+type llmPriorityKey struct{}
+
+func withLlmPriority(currentContext context.Context, priority llmPriority) context.Context {
+	return context.WithValue(currentContext, llmPriorityKey{}, priority)
+}
+
+func llmPriorityFrom(currentContext context.Context) llmPriority {
+	priority, ok := currentContext.Value(llmPriorityKey{}).(llmPriority)
+	if !ok {
+		return llmPriorityDefault
+	}
+	return priority
+}
+
+type llmLimiter struct {
+	lock     sync.Mutex
+	capacity int
+	inUse    int
+	waiting  [llmPriorityCount][]chan struct{} // first in, first out, within each priority
+}
+
+// main() sets this from the llm_concurrency setting
+var llmCallLimiter = newLlmLimiter(default_llm_concurrency)
+
+func newLlmLimiter(capacity int) *llmLimiter {
+	if capacity <= 0 {
+		capacity = default_llm_concurrency
+	}
+	return &llmLimiter{capacity: capacity}
+}
+
+// acquire waits for a slot. Call release when the LLM call is done, unless it returns an error.
+func (l *llmLimiter) acquire(currentContext context.Context, priority llmPriority) (release func(), err error) {
+	l.lock.Lock()
+	if l.inUse < l.capacity {
+		l.inUse++
+		l.lock.Unlock()
+		return l.release, nil
+	}
+	turn := make(chan struct{})
+	l.waiting[priority] = append(l.waiting[priority], turn)
+	l.lock.Unlock()
+
+	select {
+	case <-turn:
+		return l.release, nil
+	case <-currentContext.Done():
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		for i, waiter := range l.waiting[priority] {
+			if waiter == turn {
+				l.waiting[priority] = append(l.waiting[priority][:i], l.waiting[priority][i+1:]...)
+				return nil, currentContext.Err()
+			}
+		}
+		// too late, release already handed us the slot. Pass it on
+		l.handOff()
+		return nil, currentContext.Err()
+	}
+}
+
+func (l *llmLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.handOff()
+}
+
+// call with the lock held. Gives the slot to the next in line, or frees it
+func (l *llmLimiter) handOff() {
+	for priority := range l.waiting {
+		if len(l.waiting[priority]) > 0 {
+			next := l.waiting[priority][0]
+			l.waiting[priority] = l.waiting[priority][1:]
+			close(next)
+			return
+		}
+	}
+	l.inUse--
+}
+
+func (l *llmLimiter) stats() (inUse int, queued int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, waiters := range l.waiting {
+		queued += len(waiters)
+	}
+	return l.inUse, queued
+}
+
+/* every llmClient goes through the limiter */
+
+type limitedLlmClient struct {
+	limiter *llmLimiter
+	inner   llmClient
+}
+
+func (c limitedLlmClient) wait(currentContext context.Context) (release func(), err error) {
+	span := trace.SpanFromContext(currentContext)
+	priority := llmPriorityFrom(currentContext)
+	inUse, queued := c.limiter.stats()
+	startTime := time.Now()
+	release, err = c.limiter.acquire(currentContext, priority)
+	span.SetAttributes(attribute.String("app.llm.priority", priority.String()),
+		attribute.Int64("app.llm.queue_wait_ms", time.Since(startTime).Milliseconds()),
+		attribute.Int("app.llm.in_flight_before", inUse),
+		attribute.Int("app.llm.queued_before", queued),
+		attribute.Int("app.llm.concurrency_limit", c.limiter.capacity))
+	return release, err
+}
+
+func (c limitedLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	release, err := c.wait(currentContext)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer release()
+	return c.inner.complete(currentContext, request)
+}
+
+func (c limitedLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	release, err := c.wait(currentContext)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return c.inner.completeStreaming(currentContext, request, onToken)
+}
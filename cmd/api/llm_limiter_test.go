@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waits until the limiter has this many in line, so the test knows what order they queued in
+func waitForQueue(t *testing.T, limiter *llmLimiter, queued int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, inLine := limiter.stats(); inLine == queued {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d in line", queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queues up for a slot, says so on got once it has it, and holds it until let go
+func queueForSlot(t *testing.T, limiter *llmLimiter, currentContext context.Context, priority llmPriority, name string, got chan<- string, letGo <-chan struct{}) {
+	t.Helper()
+	_, queued := limiter.stats()
+	go func() {
+		release, err := limiter.acquire(currentContext, priority)
+		if err != nil {
+			got <- name + " gave up"
+			return
+		}
+		got <- name
+		<-letGo
+		release()
+	}()
+	waitForQueue(t, limiter, queued+1)
+}
+
+func holdTheOnlySlot(t *testing.T, limiter *llmLimiter) func() {
+	t.Helper()
+	release, err := limiter.acquire(context.Background(), llmPriorityDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+func TestLimiterServesPriorityThenFirstInFirstOut(t *testing.T) {
+	limiter := newLlmLimiter(1)
+	release := holdTheOnlySlot(t, limiter)
+
+	got := make(chan string, 5)
+	letGo := make(chan struct{})
+	close(letGo) // each one lets go as soon as it has the slot
+	queueForSlot(t, limiter, context.Background(), llmPriorityScoring, "first scoring", got, letGo)
+	queueForSlot(t, limiter, context.Background(), llmPriorityDefault, "guard", got, letGo)
+	queueForSlot(t, limiter, context.Background(), llmPriorityResponse, "first response", got, letGo)
+	queueForSlot(t, limiter, context.Background(), llmPriorityScoring, "second scoring", got, letGo)
+	queueForSlot(t, limiter, context.Background(), llmPriorityResponse, "second response", got, letGo)
+
+	release()
+	for _, expected := range []string{"first response", "second response", "guard", "first scoring", "second scoring"} {
+		if turn := <-got; turn != expected {
+			t.Errorf("expected %s next, got %s", expected, turn)
+		}
+	}
+	waitForQueue(t, limiter, 0)
+	if inUse, _ := limiter.stats(); inUse != 0 {
+		t.Errorf("expected every slot free, %d in use", inUse)
+	}
+}
+
+func TestCancelledWaiterLeavesTheLine(t *testing.T) {
+	limiter := newLlmLimiter(1)
+	release := holdTheOnlySlot(t, limiter)
+
+	got := make(chan string, 2)
+	letGo := make(chan struct{})
+	impatient, cancel := context.WithCancel(context.Background())
+	queueForSlot(t, limiter, impatient, llmPriorityResponse, "impatient", got, letGo)
+	queueForSlot(t, limiter, context.Background(), llmPriorityScoring, "patient", got, letGo)
+
+	cancel()
+	if turn := <-got; turn != "impatient gave up" {
+		t.Fatalf("expected the cancelled one to give up, got %s", turn)
+	}
+	waitForQueue(t, limiter, 1)
+
+	release()
+	if turn := <-got; turn != "patient" {
+		t.Errorf("expected the slot to go to the one still waiting, got %s", turn)
+	}
+	close(letGo)
+}
+
+func TestCancelledWaiterPassesOnASlotItWasHanded(t *testing.T) {
+	limiter := newLlmLimiter(1)
+	holdTheOnlySlot(t, limiter) // handed off below, the way release does it
+
+	got := make(chan string, 2)
+	letGo := make(chan struct{})
+	impatient, cancel := context.WithCancel(context.Background())
+	queueForSlot(t, limiter, impatient, llmPriorityResponse, "impatient", got, letGo)
+	queueForSlot(t, limiter, context.Background(), llmPriorityScoring, "patient", got, letGo)
+
+	// the slot is handed to the impatient one at the same moment it gives up; either can win
+	limiter.lock.Lock()
+	cancel()
+	limiter.handOff()
+	limiter.lock.Unlock()
+
+	first := <-got
+	if first == "impatient" {
+		close(letGo) // it got the slot after all; when it's done, the patient one is next
+	} else if first != "impatient gave up" {
+		t.Fatalf("expected the impatient one first, got %s", first)
+	}
+	if turn := <-got; turn != "patient" {
+		t.Fatalf("expected the slot passed on to the patient one, got %s", turn)
+	}
+	if first != "impatient" {
+		close(letGo)
+	}
+	waitForQueue(t, limiter, 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for inUse, _ := limiter.stats(); inUse != 0; inUse, _ = limiter.stats() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the slot free at the end, %d in use", inUse)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterCeiling(t *testing.T) {
+	limiter := newLlmLimiter(3)
+	got := make(chan string, 10)
+	letGo := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			release, _ := limiter.acquire(context.Background(), llmPriorityDefault)
+			got <- "in"
+			<-letGo
+			release()
+		}()
+		<-got
+	}
+	for i := 0; i < 7; i++ {
+		queueForSlot(t, limiter, context.Background(), llmPriorityDefault, "waiting", got, letGo)
+	}
+
+	if inUse, queued := limiter.stats(); inUse != 3 || queued != 7 {
+		t.Errorf("expected 3 in use and 7 waiting, got %d and %d", inUse, queued)
+	}
+	select {
+	case turn := <-got:
+		t.Errorf("%s got a slot past the limit", turn)
+	default:
+	}
+
+	close(letGo)
+	for i := 0; i < 7; i++ {
+		<-got
+	}
+	waitForQueue(t, limiter, 0)
+}
+
+func TestLimiterCapacityHasADefault(t *testing.T) {
+	if limiter := newLlmLimiter(0); limiter.capacity != default_llm_concurrency {
+		t.Errorf("expected %d, got %d", default_llm_concurrency, limiter.capacity)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
 	"observaquiz_lambda/pkg/instrumentation"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,11 +24,14 @@ const (
 	default_event           = "devopsdays_whenever"
 	ATTENDEE_API_KEY_HEADER = "x-honeycomb-api-key"
 	EXECUTION_ID_HEADER     = "x-observaquiz-execution-id"
+	ADMIN_API_KEY_HEADER    = "x-observaquiz-admin-key"
 	ServiceName             = "observaquiz-bff"
 )
 
 var tracer oteltrace.Tracer
 
+var costLedger = costs.NewLedger(costs.Budget{}) // main() replaces this with one that has the configured budget
+
 const LocalTraceLink = true // feature flag, enable locally and turn off in prod ideally
 
 func RouterWithSpan(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (response events.APIGatewayV2HTTPResponse, err error) {
@@ -41,6 +46,7 @@ func RouterWithSpan(currentContext context.Context, request events.APIGatewayV2H
 
 	currentContext, _ = setAttributesOnSpanAndBaggageFromHeaders(currentContext, request)
 	instrumentation.AddHttpRequestAttributesToSpan(lambdaSpan, request)
+	housekeeping(currentContext)
 
 	response, err = getResponseFromAPIRouter(currentContext, request)
 
@@ -51,6 +57,18 @@ func RouterWithSpan(currentContext context.Context, request events.APIGatewayV2H
 
 }
 
+// the most of a request's time that housekeeping gets: a purge and an inline lead delivery, together
+const housekeepingTimeout = 10 * time.Second
+
+// housekeeping is the background work that Lambda has nowhere to run but in a request.
+// Every request comes through here, whether it's Lambda, the server, or a stream
+func housekeeping(currentContext context.Context) {
+	currentContext, cancel := context.WithTimeout(currentContext, housekeepingTimeout)
+	defer cancel()
+	attendeeDataRetention.purgeIfDue(currentContext)
+	leadDelivery.sweepIfDue(currentContext)
+}
+
 func getResponseFromAPIRouter(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (response events.APIGatewayV2HTTPResponse, err error) {
 	lambdaSpan := oteltrace.SpanFromContext(currentContext)
 
@@ -84,21 +102,30 @@ func addSpanAttributesToResponse(currentContext context.Context, response *event
 
 func setAttributesOnSpanAndBaggageFromHeaders(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (context.Context, error) {
 	var currentSpan = oteltrace.SpanFromContext(currentContext)
-	var attendeeApiKey string
-	var executionId string = "unset"
-	for k, v := range request.Headers {
-		if strings.ToLower(k) == ATTENDEE_API_KEY_HEADER {
-			attendeeApiKey = v
-		}
-		if strings.ToLower(k) == EXECUTION_ID_HEADER {
-			executionId = v
-		}
-	}
+	attendeeApiKey := getHeader(request, ATTENDEE_API_KEY_HEADER)
+	executionId := getExecutionId(request)
 	currentSpan.SetAttributes(attribute.String(instrumentation.ATTENDEE_API_KEY_ATTRIBUTE_KEY, attendeeApiKey))
 	currentSpan.SetAttributes(attribute.String(instrumentation.EXECUTION_ID_ATTRIBUTE_KEY, executionId))
 	return instrumentation.SetApiKeyInBaggage(currentContext, attendeeApiKey, executionId)
 }
 
+func getHeader(request events.APIGatewayV2HTTPRequest, lowercaseName string) string {
+	for k, v := range request.Headers {
+		if strings.ToLower(k) == lowercaseName {
+			return v
+		}
+	}
+	return ""
+}
+
+func getExecutionId(request events.APIGatewayV2HTTPRequest) string {
+	executionId := getHeader(request, EXECUTION_ID_HEADER)
+	if executionId == "" {
+		executionId = "unset"
+	}
+	return executionId
+}
+
 func getEventName(request events.APIGatewayV2HTTPRequest) string {
 	eventName := request.Headers["event-name"]
 	if eventName == "" {
@@ -112,6 +139,40 @@ var settings struct {
 	OpenAIKey        string `env:"openai_key"`
 	QueryDataApiKey  string `env:"query_data_api_key"`
 	DeepchecksApiKey string `env:"deepchecks_api_key"`
+	RunMode          string `env:"run_mode"`             // lambda (default), lambda_streaming, or server
+	ServerAddress    string `env:"server_address"`       // for run_mode=server
+	AdminApiKey      string `env:"admin_api_key"`        // admin endpoints are off when this is empty
+	Moderation       string `env:"moderation"`           // wordlist (default), llm, or off
+	LlmMode          string `env:"llm_mode"`             // empty to talk to OpenAI, or record, or replay
+	LlmFixturesDir   string `env:"llm_fixtures_dir"`     // for llm_mode
+	LlmConcurrency   int    `env:"llm_concurrency"`      // LLM calls at once, across all requests. Default 10
+	CalibrateOnStart bool   `env:"calibrate_on_startup"` // run_mode=server only
+	Store            string `env:"store"`                // memory (default) or file
+	StorePath        string `env:"store_path"`           // for store=file
+	RetentionDays    int    `env:"retention_days"`       // delete attendee data after this many days. 0 (default) keeps it
+	LeadWebhookUrl   string `env:"lead_webhook_url"`     // where leads go; see lead_delivery.go
+	LeadSigningKey   string `env:"lead_webhook_secret"`  // signs them. Both, or leads are only in the CSV export
+	Budget           costs.Budget
+}
+
+// US dollars; zero or unset means no cap
+func parseBudgetFromEnvironment() costs.Budget {
+	parseDollars := func(name string) float64 {
+		value := os.Getenv(name)
+		if value == "" {
+			return 0
+		}
+		dollars, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			fmt.Printf("Ignoring %s, it's not a number: %v\n", name, err)
+			return 0
+		}
+		return dollars
+	}
+	return costs.Budget{
+		PerAttendeeDailyUSD: parseDollars("budget_per_attendee_daily_usd"),
+		PerEventDailyUSD:    parseDollars("budget_per_event_daily_usd"),
+	}
 }
 
 func main() {
@@ -120,11 +181,51 @@ func main() {
 	settings.OpenAIKey = os.Getenv("openai_key")
 	settings.QueryDataApiKey = os.Getenv("query_data_api_key") // whatever, if it works
 	settings.DeepchecksApiKey = os.Getenv("deepchecks_api_key")
+	settings.RunMode = os.Getenv("run_mode")
+	settings.ServerAddress = os.Getenv("server_address")
+	settings.AdminApiKey = os.Getenv("admin_api_key")
+	settings.Budget = parseBudgetFromEnvironment()
+	costLedger = costs.NewLedger(settings.Budget)
+	settings.Moderation = os.Getenv("moderation")
+	contentModerator = chooseModerator(settings.Moderation)
+	settings.LlmMode = os.Getenv("llm_mode")
+	settings.LlmFixturesDir = os.Getenv("llm_fixtures_dir")
+	settings.LlmConcurrency, _ = strconv.Atoi(os.Getenv("llm_concurrency"))
+	llmCallLimiter = newLlmLimiter(settings.LlmConcurrency)
+	settings.Store = os.Getenv("store")
+	settings.StorePath = os.Getenv("store_path")
+	attendeeStore = chooseStore(settings.Store, settings.StorePath)
+	settings.RetentionDays, _ = strconv.Atoi(os.Getenv("retention_days"))
+	attendeeDataRetention.days = settings.RetentionDays
+	settings.LeadWebhookUrl = os.Getenv("lead_webhook_url")
+	settings.LeadSigningKey = os.Getenv("lead_webhook_secret")
+	if settings.LeadWebhookUrl != "" && settings.LeadSigningKey == "" {
+		fmt.Println("lead_webhook_url is set but lead_webhook_secret isn't. Not sending leads anywhere unsigned")
+	}
+	leadDelivery.url, leadDelivery.secret = settings.LeadWebhookUrl, settings.LeadSigningKey
+	leadDelivery.inline = settings.RunMode != RUN_MODE_SERVER // a Lambda is frozen once it responds
 	currentContext := context.Background()
 
 	tracerProvider := instrumentation.CreateTracerProvider(currentContext, ServiceName)
 	tracer = tracerProvider.Tracer("observaquiz-bff/main")
 
+	settings.CalibrateOnStart = os.Getenv("calibrate_on_startup") == "true"
+	if settings.CalibrateOnStart && settings.RunMode == RUN_MODE_SERVER {
+		go calibrateAllEvents(currentContext) // don't hold up the first request for it
+	} else if settings.CalibrateOnStart {
+		// a Lambda would pay for it on every cold start, and might be frozen before it finished
+		fmt.Println("calibrate_on_startup only runs with run_mode=server. In a Lambda, POST /api/admin/calibration instead")
+	}
+
+	switch settings.RunMode {
+	case RUN_MODE_SERVER:
+		runStandaloneServer(settings.ServerAddress)
+		return
+	case RUN_MODE_LAMBDA_STREAMING:
+		runLambdaResponseStreaming(tracerProvider)
+		return
+	}
+
 	lambda.StartWithOptions(
 		otellambda.InstrumentHandler(RouterWithSpan,
 			otellambda.WithFlusher(tracerProvider),
@@ -132,4 +233,3 @@ func main() {
 		lambda.WithContext(currentContext),
 	)
 }
-
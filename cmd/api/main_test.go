@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"observaquiz_lambda/pkg/instrumentation"
+	"os"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+/**
+ * The tests run offline: LLM calls are answered from testdata/llm_fixtures (llm_mode=replay),
+ * and anything else that tries to go out over HTTP (like Deepchecks) fails fast. Only localhost gets through,
+ * for the tests that start their own server.
+ *
+ * A test whose LLM request doesn't match a fixture fails, and leaves the request in testdata/llm_fixtures/missed.
+ */
+
+const testFixturesDir = "testdata/llm_fixtures"
+
+type offlineTransport struct {
+	local http.RoundTripper
+}
+
+func (t offlineTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if ip := net.ParseIP(request.URL.Hostname()); request.URL.Hostname() == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return t.local.RoundTrip(request)
+	}
+	return nil, errors.New("the tests don't talk to " + request.URL.Host)
+}
+
+func TestMain(m *testing.M) {
+	instrumentation.TracerProvider = sdktrace.NewTracerProvider()
+	tracer = instrumentation.TracerProvider.Tracer("observaquiz-bff/test")
+	http.DefaultTransport = offlineTransport{local: http.DefaultTransport}
+
+	settings.LlmMode = LLM_MODE_REPLAY
+	settings.LlmFixturesDir = testFixturesDir
+
+	os.Exit(m.Run())
+}
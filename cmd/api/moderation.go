@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"observaquiz_lambda/cmd/api/costs"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Answers and responses go up on a screen at the booth. Check them before that happens.
+ *
+ * The attendee's answer gets checked before it goes to the LLM; the LLM's response gets checked
+ * before it goes back. Anything flagged is replaced with a safe message, and goes on a list
+ * for booth staff to look at (GET /api/admin/moderation).
+ */
+
+const (
+	MODERATION_WORD_LIST = "wordlist"
+	MODERATION_LLM       = "llm"
+	MODERATION_OFF       = "off"
+)
+
+// on a stored answer, when something caught it
+const (
+	ANSWER_FLAG_MODERATION = "moderation"
+	ANSWER_FLAG_GUARD      = "guard"
+)
+
+const (
+	safeAnswerResponse = "Let's keep it friendly for the booth screen! Try telling us about your observability instead."
+	safeLlmResponse    = "Hmm, I'd rather not put my first reply up on the big screen. Thanks for your answer!"
+)
+
+type moderationResult struct {
+	flagged bool
+	reason  string
+}
+
+type moderator interface {
+	name() string
+	moderate(currentContext context.Context, text string) moderationResult
+}
+
+var contentModerator moderator = newWordListModerator() // main() picks according to the moderation setting
+
+func chooseModerator(setting string) moderator {
+	switch setting {
+	case MODERATION_LLM:
+		return llmModerator{}
+	case MODERATION_OFF:
+		return noModerator{}
+	default:
+		return newWordListModerator()
+	}
+}
+
+// moderateText runs the moderator in its own span, and records anything it flags for review.
+func moderateText(currentContext context.Context, stage string, text string) moderationResult {
+	return moderateTextWith(currentContext, contentModerator, stage, text)
+}
+
+func moderateTextWith(currentContext context.Context, contentModerator moderator, stage string, text string) moderationResult {
+	currentContext, span := tracer.Start(currentContext, "moderate "+stage)
+	defer span.End()
+
+	result := contentModerator.moderate(currentContext, text)
+	span.SetAttributes(attribute.String("app.moderation.moderator", contentModerator.name()),
+		attribute.String("app.moderation.stage", stage),
+		attribute.Bool("app.moderation.flagged", result.flagged),
+		attribute.String("app.moderation.reason", result.reason))
+
+	if result.flagged {
+		scope := costs.ScopeFrom(currentContext)
+		event := moderationEvent{
+			Time:        time.Now(),
+			EventName:   scope.EventName,
+			QuestionId:  scope.QuestionId,
+			ExecutionId: scope.ExecutionId,
+			Stage:       stage,
+			Text:        text,
+			Reason:      result.reason,
+			Moderator:   contentModerator.name(),
+			TraceId:     span.SpanContext().TraceID().String(),
+		}
+		moderationLog.add(event)
+		trace.SpanFromContext(currentContext).AddEvent("content flagged for review", trace.WithAttributes(
+			attribute.String("app.moderation.stage", stage),
+			attribute.String("app.moderation.reason", result.reason)))
+	}
+	return result
+}
+
+/* no moderation */
+
+type noModerator struct{}
+
+func (noModerator) name() string { return MODERATION_OFF }
+func (noModerator) moderate(context.Context, string) moderationResult {
+	return moderationResult{}
+}
+
+/* word list */
+
+//go:embed moderation_words.txt
+var moderationWordsFile string
+
+type wordListModerator struct {
+	words []*regexp.Regexp
+}
+
+func newWordListModerator() wordListModerator {
+	moderator := wordListModerator{}
+	scanner := bufio.NewScanner(strings.NewReader(moderationWordsFile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		moderator.words = append(moderator.words, regexp.MustCompile(`\b`+regexp.QuoteMeta(strings.ToLower(line))+`\b`))
+	}
+	return moderator
+}
+
+var leetspeak = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i")
+
+func (wordListModerator) name() string { return MODERATION_WORD_LIST }
+
+func (m wordListModerator) moderate(currentContext context.Context, text string) moderationResult {
+	lowered := strings.ToLower(text)
+	for _, candidate := range []string{lowered, leetspeak.Replace(lowered)} {
+		for _, word := range m.words {
+			if found := word.FindString(candidate); found != "" {
+				return moderationResult{flagged: true, reason: "contains \"" + found + "\""}
+			}
+		}
+	}
+	return moderationResult{}
+}
+
+/* ask the LLM */
+
+const llmModerationPrompt = `You are moderating text that will be shown on a public screen at a conference booth, in front of a general professional audience.
+Flag it if it contains profanity, slurs, harassment, sexual content, threats, personal information about someone, or anything else you wouldn't want on a big screen at a tech conference.
+Mild frustration about software is fine. Technical words are fine.
+
+Respond in JSON: { "flagged": true or false, "reason": "short explanation" }
+
+The text is:
+` + "```" + `
+TEXT TO MODERATE
+` + "```"
+
+type llmModerator struct{}
+
+type llmModerationResult struct {
+	Flagged bool   `json:"flagged"`
+	Reason  string `json:"reason"`
+}
+
+func (llmModerator) name() string { return MODERATION_LLM }
+
+func (llmModerator) moderate(currentContext context.Context, text string) moderationResult {
+	llmApi := newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey)
+	output := chatResult{}
+	result := llmModerationResult{}
+	err := chatForValidJson(currentContext, llmApi, chatRequest{
+		theirAnswer:    text,
+		promptTemplate: llmModerationPrompt,
+		replacements:   map[string]string{"TEXT TO MODERATE": text},
+	}, jsonSchema{
+		{name: "flagged", fieldType: jsonBoolean, required: true},
+		{name: "reason", fieldType: jsonString},
+	}, &output, &result)
+	if err != nil {
+		// if the moderator is down, fall back to the word list rather than show anything
+		trace.SpanFromContext(currentContext).RecordError(err)
+		return newWordListModerator().moderate(currentContext, text)
+	}
+	return moderationResult{flagged: result.Flagged, reason: result.Reason}
+}
+
+/* for staff review */
+
+const moderationLogSize = 500
+
+type moderationEvent struct {
+	Time        time.Time `json:"time"`
+	EventName   string    `json:"event_name"`
+	QuestionId  string    `json:"question_id"`
+	ExecutionId string    `json:"execution_id"`
+	Stage       string    `json:"stage"` // answer, response, nickname, or copy detection
+	Text        string    `json:"text"`
+	Reason      string    `json:"reason"`
+	Moderator   string    `json:"moderator"`
+	TraceId     string    `json:"trace_id"`
+}
+
+// the most recent flagged content, in memory. Per Lambda instance, like the cost ledger.
+type moderationEventLog struct {
+	lock   sync.Mutex
+	events []moderationEvent
+}
+
+var moderationLog = &moderationEventLog{}
+
+func (l *moderationEventLog) add(event moderationEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events = append(l.events, event)
+	if len(l.events) > moderationLogSize {
+		l.events = l.events[len(l.events)-moderationLogSize:]
+	}
+}
+
+// when an attendee's data is deleted
+func (l *moderationEventLog) forget(eventName string, executionId string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	kept := []moderationEvent{}
+	for _, event := range l.events {
+		if event.EventName != eventName || event.ExecutionId != executionId {
+			kept = append(kept, event)
+		}
+	}
+	l.events = kept
+}
+
+func (l *moderationEventLog) list() []moderationEvent {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]moderationEvent{}, l.events...)
+}
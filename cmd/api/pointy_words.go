@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/**
+ * Pointy words: points for saying the words we hope to hear. No LLM involved.
+ *
+ * In questions.json, each pointy word is either a plain string, or a rule:
+ *
+ *    "OpenTelemetry"
+ *    { "word": "OpenTelemetry", "aliases": ["OTel"], "weight": 2 }
+ *    { "word": "blah blah", "negative": true }                 takes away its weight instead
+ *
+ * Matching ignores case, and only counts whole words (plus a plural): "SLO" matches "SLOs" but not "SLOsh".
+ * A whole word is one with no letter, digit, or underscore on either side, so "C++" and "CI/CD" work too.
+ * Each rule counts once, however many of its aliases they use.
+ * Set "pointy_words_cap" in the scoring section to limit how many points they can get this way.
+ */
+
+type PointyWord struct {
+	Word     string   `json:"word"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Weight   int      `json:"weight,omitempty"` // default 1
+	Negative bool     `json:"negative,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// A plain string is a rule with no aliases, weight 1. That's what all the old question files have.
+func (w *PointyWord) UnmarshalJSON(data []byte) error {
+	var plain string
+	if err := json.Unmarshal(data, &plain); err == nil {
+		*w = PointyWord{Word: plain}
+	} else {
+		type pointyWordFields PointyWord // without this UnmarshalJSON, or we'd be right back here
+		fields := pointyWordFields{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("a pointy word is a string, or { \"word\": ..., \"aliases\": [...], \"weight\": n, \"negative\": bool }: %w", err)
+		}
+		*w = PointyWord(fields)
+	}
+	if strings.TrimSpace(w.Word) == "" {
+		return fmt.Errorf("a pointy word needs a word")
+	}
+	w.pattern = pointyWordPattern(append([]string{w.Word}, w.Aliases...))
+	return nil
+}
+
+func pointyWordPattern(terms []string) *regexp.Regexp {
+	alternatives := []string{}
+	for _, term := range terms {
+		// runs of spaces in the rule match any whitespace in the answer
+		words := strings.Fields(term)
+		for i, word := range words {
+			words[i] = regexp.QuoteMeta(word)
+		}
+		alternatives = append(alternatives, strings.Join(words, `\s+`))
+	}
+	// not \b: that needs a letter or digit on the inside, so "C++" and ".NET" would never match
+	return regexp.MustCompile(`(?i)(?:^|\W)(?:` + strings.Join(alternatives, "|") + `)(?:s|es)?(?:$|\W)`)
+}
+
+func (w PointyWord) weight() int {
+	if w.Weight <= 0 {
+		return 1
+	}
+	return w.Weight
+}
+
+func (w PointyWord) matches(answer string) bool {
+	if w.pattern == nil { // built in code, not read from JSON
+		w.pattern = pointyWordPattern(append([]string{w.Word}, w.Aliases...))
+	}
+	return w.pattern.MatchString(answer)
+}
+
+// what they get for saying all the (not negative) words, up to the cap
+func (scoring ScoringThings) pointyWordsPossibleScore() int {
+	possibleScore := 0
+	for _, word := range scoring.PointyWords {
+		if !word.Negative {
+			possibleScore += word.weight()
+		}
+	}
+	if scoring.PointyWordsCap > 0 && possibleScore > scoring.PointyWordsCap {
+		return scoring.PointyWordsCap
+	}
+	return possibleScore
+}
+
+func scorePointyWords(currentContext context.Context, scoring ScoringThings, answer string) partialScore {
+	_, span := tracer.Start(currentContext, "score pointy words")
+	defer span.End()
+
+	result := partialScore{description: "pointy words", status: SCORE_STATUS_SCORED, possibleScore: scoring.pointyWordsPossibleScore()}
+	for _, word := range scoring.PointyWords {
+		if !word.matches(answer) {
+			continue
+		}
+		if word.Negative {
+			result.score -= word.weight()
+			result.penaltyWords = append(result.penaltyWords, word.Word)
+		} else {
+			result.score += word.weight()
+			result.matchedWords = append(result.matchedWords, word.Word)
+		}
+	}
+
+	uncappedScore := result.score
+	if result.score > result.possibleScore {
+		result.score = result.possibleScore
+	}
+	if result.score < 0 {
+		result.score = 0
+	}
+
+	span.SetAttributes(attribute.Int("app.score.score", result.score),
+		attribute.Int("app.score.possible_score", result.possibleScore),
+		attribute.Int("app.score.uncapped_score", uncappedScore),
+		attribute.Int("app.score.pointy_words_cap", scoring.PointyWordsCap),
+		attribute.StringSlice("app.score.matched_words", result.matchedWords),
+		attribute.StringSlice("app.score.penalty_words", result.penaltyWords),
+		attribute.String("app.llm.answer", answer))
+	return result
+}
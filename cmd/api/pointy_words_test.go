@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestPointyWordMatching(t *testing.T) {
+	tests := []struct {
+		word    string
+		aliases []string
+		answer  string
+		matches bool
+	}{
+		{"SLO", nil, "we alert on SLOs", true},
+		{"SLO", nil, "we alert on an slo.", true},
+		{"SLO", nil, "our SLOsh process", false},
+		{"trace", nil, "we have traces", true},
+		{"process", nil, "we have processes", true},
+		{"log", nil, "we read the catalog", false},
+		{"log", nil, "we read the logbook", false},
+		{"log", nil, "log", true},
+		{"distributed tracing", nil, "we do distributed\n  tracing now", true},
+		{"C++", nil, "our backend is C++, mostly", true},
+		{"C++", nil, "C++", true},
+		{"C++", nil, "it's in C", false},
+		{".NET", nil, "we run .NET.", true},
+		{".NET", nil, "see example.network", false},
+		{"CI/CD", nil, "alerts come from CI/CD.", true},
+		{"CI/CD", nil, "(CI/CD)", true},
+		{"OpenTelemetry", []string{"OTel"}, "we use otel for metrics", true},
+		{"OpenTelemetry", []string{"OTel"}, "a hotel", false},
+	}
+	for _, test := range tests {
+		word := PointyWord{Word: test.word, Aliases: test.aliases}
+		if word.matches(test.answer) != test.matches {
+			t.Errorf("%q in %q: expected %v", test.word, test.answer, test.matches)
+		}
+	}
+}
+
+func TestPointyWordRules(t *testing.T) {
+	words := []PointyWord{}
+	err := json.Unmarshal([]byte(`["log", { "word": "OpenTelemetry", "aliases": ["OTel"], "weight": 2 }, { "word": "blah", "negative": true }]`), &words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoring := ScoringThings{PointyWords: words}
+
+	result := scorePointyWords(context.Background(), scoring, "Logs and OTel and OpenTelemetry")
+	if result.score != 3 || result.possibleScore != 3 || len(result.matchedWords) != 2 {
+		t.Errorf("expected each rule counted once, with its weight: %+v", result)
+	}
+	result = scorePointyWords(context.Background(), scoring, "blah blah log")
+	if result.score != 0 || len(result.penaltyWords) != 1 {
+		t.Errorf("expected the negative word to take its point away: %+v", result)
+	}
+
+	scoring.PointyWordsCap = 2
+	if result := scorePointyWords(context.Background(), scoring, "logs and OTel"); result.score != 2 || result.possibleScore != 2 {
+		t.Errorf("expected the cap: %+v", result)
+	}
+
+	if err := json.Unmarshal([]byte(`[{ "aliases": ["OTel"] }]`), &words); err == nil {
+		t.Errorf("a rule without a word should be turned down")
+	}
+}
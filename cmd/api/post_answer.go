@@ -3,8 +3,8 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"observaquiz_lambda/pkg/instrumentation"
 	"regexp"
 	"strings"
@@ -12,11 +12,11 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/sashabaranov/go-openai"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 
+	"observaquiz_lambda/cmd/api/costs"
 	"observaquiz_lambda/cmd/api/deepchecks"
 )
 
@@ -92,46 +92,132 @@ func postAnswer(currentContext context.Context, request events.APIGatewayV2HTTPR
 	postQuestionSpan.SetAttributes(attribute.String("app.post_answer.question_id", questionId))
 
 	/* find that question in our question definitions */
-	var questionDefinition Question
+	questionDefinition, questionFound := findQuestion(eventName, questionId)
+	if !questionFound {
+		postQuestionSpan.SetAttributes(attribute.String("error.message", "Couldn't find question"))
+		postQuestionSpan.SetStatus(codes.Error, "Couldn't find question")
+		return instrumentation.ErrorResponse("Couldn't find question with that ID", 404), nil
+	}
 
-	eventQuestions := eventQuestions[eventName]
+	executionId := getExecutionId(request)
+	if errorResponse := checkSessionOwner(currentContext, request, eventName, executionId); errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+	currentContext = costs.WithScope(currentContext, costs.Scope{EventName: eventName, QuestionId: questionId, ExecutionId: executionId, AttendeeKeyHash: attendeeKeyHashOf(request)})
+	idempotencyKey := answerIdempotencyKey(request, eventName, questionId, answer)
+	llmResponse, errorResponse, replayed := answerDeduplication.once(currentContext, idempotencyKey, answer, func() (*responseToAnswer, *errorResponseType) {
+		return respondToAnswerAndRecord(currentContext, eventName, questionId, executionId, attendeeKeyHashOf(request), request.RequestContext.HTTP.SourceIP, questionDefinition, answer)
+	})
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
 
-	for _, v := range eventQuestions {
+	/* tell the UI what we got */
+	result := postAnswerResponseFrom(llmResponse)
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		postQuestionSpan.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure marshalling JSON")))
+		return instrumentation.ErrorResponse("wtaf", 500), nil
+	}
+
+	response = events.APIGatewayV2HTTPResponse{Body: string(jsonData), StatusCode: 200}
+	if replayed {
+		response.Headers = map[string]string{IDEMPOTENT_REPLAYED_HEADER: "true"}
+	}
+	return response, nil
+}
+
+// respondToAnswerAndRecord is what a retry doesn't do again: check for copying, ask the LLMs, and write it down.
+func respondToAnswerAndRecord(currentContext context.Context, eventName string, questionId string, executionId string, attendeeKeyHash string, sourceIp string, questionDefinition Question, answer AnswerBody) (*responseToAnswer, *errorResponseType) {
+	copyVerdict := detectCopying(currentContext, eventName, questionDefinition, sourceIp, answer)
+	if copyVerdict.flagged() && copyVerdict.action == COPY_ACTION_REJECT {
+		return nil, &errorResponseType{message: copyRejectedMessage, statusCode: 422}
+	}
+
+	llmResponse, errorResponse := respondToAnswer(currentContext, eventName, questionDefinition, answer)
+	if errorResponse != nil {
+		return nil, errorResponse
+	}
+	applyCopyVerdict(currentContext, eventConfigs[eventName].CopyDetection, copyVerdict, llmResponse)
+	for _, flag := range copyVerdict.flags {
+		llmResponse.flags = append(llmResponse.flags, flag.kind)
+	}
+	recordAnswer(currentContext, eventName, questionId, executionId, attendeeKeyHash, answer, llmResponse)
+	return llmResponse, nil
+}
+
+func findQuestion(eventName string, questionId string) (Question, bool) {
+	for _, v := range eventQuestions[eventName] {
 		if v.Id.String() == questionId {
-			questionDefinition = v
-			break
+			return v, true
 		}
 	}
+	return Question{}, false
+}
 
-	if questionDefinition.Version == "" {
-		postQuestionSpan.SetAttributes(attribute.String("error.message", "Couldn't find question"))
-		postQuestionSpan.SetStatus(codes.Error, "Couldn't find question")
-		return instrumentation.ErrorResponse("Couldn't find question with that ID", 404), nil
+func respondToAnswer(currentContext context.Context, eventName string, questionDefinition Question, answer AnswerBody) (llmResponse *responseToAnswer, errorResponse *errorResponseType) {
+	span := trace.SpanFromContext(currentContext)
+	scoringPolicy := scoringPolicyFor(eventName)
+
+	if moderation := moderateText(currentContext, "answer", answer.Answer); moderation.flagged {
+		// it doesn't go to the LLM, and it doesn't get points
+		span.SetAttributes(attribute.Bool("app.moderation.answer_flagged", true))
+		llmResponse = &responseToAnswer{response: safeAnswerResponse, score: 0, possibleScore: possibleScoreOf(questionDefinition), flags: []string{ANSWER_FLAG_MODERATION}}
+		applyScoringPolicy(currentContext, scoringPolicy, llmResponse, false)
+		return llmResponse, nil
 	}
 
-	var llmResponse *responseToAnswer // why is this a pointer. Because I wanted to pass nil in case of error.
-	var errorResponse *errorResponseType
+	guardVerdict := checkAnswerWithGuard(currentContext, questionDefinition, answer)
+	if guardVerdict.detected && questionDefinition.Guard.outcome() == GUARD_OUTCOME_CANNED {
+		span.SetAttributes(attribute.String("app.guard.outcome", GUARD_OUTCOME_CANNED),
+			attribute.String("app.guard.reason", guardVerdict.reason))
+		llmResponse = &responseToAnswer{response: questionDefinition.Guard.cannedResponse(), score: 0, possibleScore: possibleScoreOf(questionDefinition), flags: []string{ANSWER_FLAG_GUARD}}
+		applyScoringPolicy(currentContext, scoringPolicy, llmResponse, false)
+		return llmResponse, nil
+	}
 
+	// why is llmResponse a pointer. Because I wanted to pass nil in case of error.
 	if questionDefinition.Version == "v1" {
 		llmResponse, errorResponse = respondToAnswerV1(currentContext, questionDefinition, answer)
-
 	} else if questionDefinition.Version == "v2" {
 		llmResponse, errorResponse = respondToAnswerV2(currentContext, questionDefinition, answer)
-		postQuestionSpan.SetAttributes(attribute.String("app.llm.output", llmResponse.response))
+		if errorResponse == nil {
+			span.SetAttributes(attribute.String("app.llm.output", llmResponse.response))
+		}
+	} else {
+		return nil, &errorResponseType{message: "Unknown question version " + questionDefinition.Version, statusCode: 500}
 	}
 	if errorResponse != nil {
-		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+		return nil, errorResponse
 	}
 
-	/* tell the UI what we got */
-	result := PostAnswerResponse{Response: llmResponse.response, Score: llmResponse.score, PossibleScore: llmResponse.possibleScore, EvaluationId: llmResponse.evaluationId}
-	jsonData, err := json.Marshal(result)
-	if err != nil {
-		postQuestionSpan.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure marshalling JSON")))
-		return instrumentation.ErrorResponse("wtaf", 500), nil
+	// scale first, so the guard's score_cap is in the same points as everything else
+	applyScoringPolicy(currentContext, scoringPolicy, llmResponse, !guardVerdict.detected)
+	applyGuardVerdict(currentContext, questionDefinition.Guard, guardVerdict, llmResponse)
+	if guardVerdict.detected {
+		llmResponse.flags = append(llmResponse.flags, ANSWER_FLAG_GUARD)
 	}
 
-	return events.APIGatewayV2HTTPResponse{Body: string(jsonData), StatusCode: 200}, nil
+	if llmResponse.responseModerated {
+		return llmResponse, nil
+	}
+	if moderation := moderateText(currentContext, "response", llmResponse.response); moderation.flagged {
+		span.SetAttributes(attribute.Bool("app.moderation.response_flagged", true))
+		llmResponse.response = safeLlmResponse
+	}
+	return llmResponse, nil
+}
+
+// what they could have gotten, without asking the LLM
+func possibleScoreOf(questionDefinition Question) int {
+	if questionDefinition.Version == "v1" {
+		return 100
+	}
+	possibleScore := questionDefinition.Scoring.pointyWordsPossibleScore()
+	for _, scoringPrompt := range questionDefinition.Scoring.ScoringPrompts {
+		possibleScore += scoringPrompt.MaximumScore
+	}
+	return possibleScore
 }
 
 type responseToAnswer struct {
@@ -139,6 +225,17 @@ type responseToAnswer struct {
 	score         int
 	possibleScore int
 	evaluationId  string
+	category      string         // v2 only
+	scoreParts    []partialScore // v2 only
+	partialScore  bool
+	showReasoning bool // include each part's reasoning in the response
+	// before the event's scoring policy scaled it. nil if it didn't
+	rawScore         *int
+	rawPossibleScore *int
+	// what caught it: moderation, guard, or a copy detection flag. Flagged sessions don't win prizes
+	flags []string
+	// v2 moderates its response before it streams it, so respondToAnswer doesn't do it again
+	responseModerated bool
 }
 
 type errorResponseType struct {
@@ -159,19 +256,13 @@ func respondToAnswerV1(currentContext context.Context, questionDefinition Questi
 		attribute.String("app.llm.full_prompt", fullPrompt))
 
 	/* now call OpenAI */
-	httpClient := http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
-	}
-
-	openAIConfig := openai.DefaultConfig(settings.OpenAIKey)
-	openAIConfig.HTTPClient = &httpClient
-	client := openai.NewClientWithConfig(openAIConfig)
+	client := newLlmClient(settings.OpenAIKey)
 
 	startTime := time.Now()
 	model := openai.GPT3Dot5Turbo1106
 	responseType := openai.ChatCompletionResponseFormatTypeJSONObject // openai.ChatCompletionResponseFormatTypeText
 	postQuestionSpan.SetAttributes(attribute.String("app.llm.responseType", fmt.Sprintf("%v", responseType)))
-	openaiChatCompletionResponse, err := client.CreateChatCompletion(
+	openaiChatCompletionResponse, err := client.complete(
 		currentContext,
 		openai.ChatCompletionRequest{
 			ResponseFormat: &openai.ChatCompletionResponseFormat{
@@ -182,6 +273,10 @@ func respondToAnswerV1(currentContext context.Context, questionDefinition Questi
 			Messages:  openaiMessages,
 		},
 	)
+	if errors.Is(err, errOverBudget) {
+		// degraded mode: no feedback and no points, but they still get a response
+		return &responseToAnswer{response: overBudgetResponse, score: 0, possibleScore: 100, partialScore: true}, nil
+	}
 	if err != nil {
 		postQuestionSpan.RecordError(err,
 			trace.WithAttributes(
@@ -212,7 +307,8 @@ func respondToAnswerV1(currentContext context.Context, questionDefinition Questi
 		return nil, &errorResponseType{message: "Could not parse LLM response", statusCode: 500}
 	}
 
-	return &responseToAnswer{response: parsedLlmResponse.Response, score: parsedLlmResponse.Score, evaluationId: interactionReported.EvaluationId, possibleScore: 100}, nil
+	// the LLM doesn't always stay between 0 and 100
+	return &responseToAnswer{response: parsedLlmResponse.Response, score: clampScore(parsedLlmResponse.Score, 100), evaluationId: interactionReported.EvaluationId, possibleScore: 100}, nil
 }
 
 type LlmResponse struct {
@@ -239,10 +335,47 @@ func parseLLMResponse(currentContext context.Context, llmResponse string) (respo
 }
 
 type PostAnswerResponse struct {
-	Response      string `json:"response"`
-	Score         int    `json:"score"`
-	PossibleScore int    `json:"possible_score"`
-	EvaluationId  string `json:"evaluation_id"`
+	Response        string           `json:"response"`
+	Score           int              `json:"score"`
+	PossibleScore   int              `json:"possible_score"`
+	EvaluationId    string           `json:"evaluation_id"`
+	PartialScore    bool             `json:"partial_score,omitempty"` // some scoring prompts failed or were skipped; they count as 0 out of their maximum
+	RawScore        *int             `json:"raw_score,omitempty"`     // before scaling to the event's points per question. The components add up to this
+	RawPossible     *int             `json:"raw_possible_score,omitempty"`
+	ScoreComponents []ScoreComponent `json:"score_components,omitempty"` // v2 only
+}
+
+// One piece of the score, so the booth UI can explain why they got 35/60.
+// Confidence and reasoning are only there when the question's scoring has show_reasoning.
+type ScoreComponent struct {
+	Description  string   `json:"description"`
+	Status       string   `json:"status"` // scored, failed, cancelled, or skipped
+	Score        int      `json:"score"`
+	MaximumScore int      `json:"maximum_score"`
+	Confidence   string   `json:"confidence,omitempty"`
+	Reasoning    string   `json:"reasoning,omitempty"`
+	MatchedWords []string `json:"matched_words,omitempty"` // for the pointy words
+	PenaltyWords []string `json:"penalty_words,omitempty"`
+	// with consensus
+	Samples       []int   `json:"samples,omitempty"`
+	SampleStdDev  float64 `json:"sample_stddev,omitempty"`
+	LowConfidence bool    `json:"low_confidence,omitempty"`
+	OverriddenBy  string  `json:"overridden_by,omitempty"` // guard: the score was cut down to match the total, because the answer tried to instruct the model
+}
+
+func postAnswerResponseFrom(llmResponse *responseToAnswer) PostAnswerResponse {
+	result := PostAnswerResponse{Response: llmResponse.response, Score: llmResponse.score, PossibleScore: llmResponse.possibleScore, EvaluationId: llmResponse.evaluationId, PartialScore: llmResponse.partialScore,
+		RawScore: llmResponse.rawScore, RawPossible: llmResponse.rawPossibleScore}
+	for _, part := range llmResponse.scoreParts {
+		component := ScoreComponent{Description: part.description, Status: part.status, Score: part.score, MaximumScore: part.possibleScore, MatchedWords: part.matchedWords, PenaltyWords: part.penaltyWords,
+			Samples: part.samples, SampleStdDev: part.spread, LowConfidence: part.lowConfidence, OverriddenBy: part.overriddenBy}
+		if llmResponse.showReasoning {
+			component.Confidence = part.confidence
+			component.Reasoning = part.reasoning
+		}
+		result.ScoreComponents = append(result.ScoreComponents, component)
+	}
+	return result
 }
 
 func addLlmResponseAttributesToSpan(span trace.Span, llmResponse openai.ChatCompletionResponse) {
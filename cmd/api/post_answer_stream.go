@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var postAnswerStreamEndpoint = streamingEndpoint{
+	"POST",
+	"/api/questions/{questionId}/answer/stream",
+	regexp.MustCompile("^/api/questions/([^/]+)/answer/stream$"),
+	postAnswerStream,
+	true,
+}
+
+/**
+ * The same as postAnswer, except the attendee sees each stage as it finishes,
+ * instead of a spinner for the whole time.
+ *
+ * event: category       { "category": "...", "confidence": "...", "reasoning": "..." }   (v2 only)
+ * event: token          { "token": "..." }                                               (v2 only)
+ * event: partial_score  { "description": "...", "score": 10, "possible_score": 20 }      (v2 only)
+ * event: result         same as the body of POST /api/questions/{questionId}/answer
+ *                       Show this response, not the tokens: moderation might have replaced it.
+ * event: error          { "error": "...", "status_code": 500 }
+ *
+ * The tokens go on the booth screen, so unless moderation is off, they go out a sentence at a time: each sentence
+ * is held back until moderation has passed the response so far. Once anything is flagged, no more tokens are sent.
+ */
+func postAnswerStream(currentContext context.Context, request events.APIGatewayV2HTTPRequest, stream *sseWriter) {
+	currentContext, span := tracer.Start(currentContext, "Ask LLM for Response, streaming")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("request.body", request.Body))
+	answer := AnswerBody{}
+	err := json.Unmarshal([]byte(request.Body), &answer)
+	if err != nil {
+		span.RecordError(fmt.Errorf("error unmarshalling answer: %w\n request body: %s", err, request.Body))
+		stream.sendError("Bad request. Expected format: { 'answer': 'stuff' }", 400)
+		return
+	}
+
+	eventName := getEventName(request)
+	questionId := strings.Split(request.RequestContext.HTTP.Path, "/")[3]
+	span.SetAttributes(attribute.String("app.post_answer.event_name", eventName),
+		attribute.String("app.post_answer.question_id", questionId))
+
+	questionDefinition, questionFound := findQuestion(eventName, questionId)
+	if !questionFound {
+		span.SetStatus(codes.Error, "Couldn't find question")
+		stream.sendError("Couldn't find question with that ID", 404)
+		return
+	}
+
+	executionId := getExecutionId(request)
+	if errorResponse := checkSessionOwner(currentContext, request, eventName, executionId); errorResponse != nil {
+		stream.sendError(errorResponse.message, errorResponse.statusCode)
+		return
+	}
+	currentContext = costs.WithScope(currentContext, costs.Scope{EventName: eventName, QuestionId: questionId, ExecutionId: executionId, AttendeeKeyHash: attendeeKeyHashOf(request)})
+	currentContext = withAnswerProgress(currentContext, newStreamedProgress(currentContext, stream, contentModerator))
+	// a retry gets only the result event, not the progress along the way
+	idempotencyKey := answerIdempotencyKey(request, eventName, questionId, answer)
+	llmResponse, errorResponse, _ := answerDeduplication.once(currentContext, idempotencyKey, answer, func() (*responseToAnswer, *errorResponseType) {
+		return respondToAnswerAndRecord(currentContext, eventName, questionId, executionId, attendeeKeyHashOf(request), request.RequestContext.HTTP.SourceIP, questionDefinition, answer)
+	})
+	if errorResponse != nil {
+		span.SetStatus(codes.Error, errorResponse.message)
+		stream.sendError(errorResponse.message, errorResponse.statusCode)
+		return
+	}
+
+	stream.send("result", postAnswerResponseFrom(llmResponse))
+}
+
+// answerProgress hears about each stage of answering a question as it completes.
+// Most callers don't care; the streaming endpoint passes each stage on to the attendee.
+type answerProgress interface {
+	categoryAssigned(category CategoryResult)
+	responseTokenListener() func(token string) // nil means "don't bother streaming"
+	responseModerated(flagged bool)            // after the whole response, before anyone sees the last of the tokens
+	partialScoreComputed(score partialScore)
+}
+
+type answerProgressContextKey struct{}
+
+func withAnswerProgress(currentContext context.Context, progress answerProgress) context.Context {
+	return context.WithValue(currentContext, answerProgressContextKey{}, progress)
+}
+
+func answerProgressFrom(currentContext context.Context) answerProgress {
+	progress, ok := currentContext.Value(answerProgressContextKey{}).(answerProgress)
+	if !ok {
+		return ignoredProgress{}
+	}
+	return progress
+}
+
+type ignoredProgress struct{}
+
+func (ignoredProgress) categoryAssigned(CategoryResult)     {}
+func (ignoredProgress) responseTokenListener() func(string) { return nil }
+func (ignoredProgress) responseModerated(bool)              {}
+func (ignoredProgress) partialScoreComputed(partialScore)   {}
+
+type streamedProgress struct {
+	currentContext context.Context
+	stream         *sseWriter
+	moderator      moderator
+
+	lock      sync.Mutex
+	sentSoFar string   // everything moderation has passed
+	held      []string // the sentence in progress
+	flagged   bool     // once something's flagged, nothing more goes out
+}
+
+func newStreamedProgress(currentContext context.Context, stream *sseWriter, moderator moderator) *streamedProgress {
+	return &streamedProgress{currentContext: currentContext, stream: stream, moderator: moderator}
+}
+
+// a sentence is over when a token ends one. Good enough for moderation; it doesn't need to be grammar
+func endsSentence(token string) bool {
+	return strings.ContainsAny(token, ".!?\n")
+}
+
+type streamedToken struct {
+	Token string `json:"token"`
+}
+
+type streamedPartialScore struct {
+	Description   string `json:"description"`
+	Score         int    `json:"score"`
+	PossibleScore int    `json:"possible_score"`
+}
+
+func (p *streamedProgress) categoryAssigned(category CategoryResult) {
+	p.stream.send("category", category)
+}
+
+func (p *streamedProgress) responseTokenListener() func(string) {
+	return func(token string) {
+		if p.moderator.name() == MODERATION_OFF {
+			p.stream.send("token", streamedToken{Token: token})
+			return
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.flagged {
+			return
+		}
+		p.held = append(p.held, token)
+		if endsSentence(token) {
+			p.releaseHeldTokens()
+		}
+	}
+}
+
+// moderate the whole response so far, not just this sentence, so that nothing gets through by being split across two
+func (p *streamedProgress) releaseHeldTokens() {
+	sentence := strings.Join(p.held, "")
+	if p.moderator.moderate(p.currentContext, p.sentSoFar+sentence).flagged {
+		// the response as a whole gets moderated again after this, and that's what goes on the list for staff
+		p.flagged = true
+		p.held = nil
+		return
+	}
+	for _, token := range p.held {
+		p.stream.send("token", streamedToken{Token: token})
+	}
+	p.sentSoFar += sentence
+	p.held = nil
+}
+
+// the last sentence might not have ended with punctuation; moderation has passed it now, along with the rest
+func (p *streamedProgress) responseModerated(flagged bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if flagged {
+		p.flagged = true
+	}
+	if !p.flagged {
+		for _, token := range p.held {
+			p.stream.send("token", streamedToken{Token: token})
+		}
+	}
+	p.held = nil
+}
+
+func (p *streamedProgress) partialScoreComputed(score partialScore) {
+	p.stream.send("partial_score", streamedPartialScore{Description: score.description, Score: score.score, PossibleScore: score.possibleScore})
+}
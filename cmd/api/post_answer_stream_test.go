@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func sendTokens(progress answerProgress, tokens ...string) {
+	listener := progress.responseTokenListener()
+	for _, token := range tokens {
+		listener(token)
+	}
+}
+
+// flags anything containing "rude"
+type testModerator struct{}
+
+func (testModerator) name() string { return MODERATION_WORD_LIST }
+func (testModerator) moderate(currentContext context.Context, text string) moderationResult {
+	return moderationResult{flagged: strings.Contains(text, "rude"), reason: "rude"}
+}
+
+func TestTokensGoOutASentenceAtATime(t *testing.T) {
+	sent := bytes.Buffer{}
+	progress := newStreamedProgress(context.Background(), newSseWriter(&sent, nil), testModerator{})
+
+	sendTokens(progress, "We ", "watch ")
+	if sent.Len() != 0 {
+		t.Fatalf("tokens went out before moderation saw the sentence: %s", sent.String())
+	}
+	sendTokens(progress, "dashboards.")
+	if strings.Count(sent.String(), "event: token") != 3 {
+		t.Fatalf("expected the sentence once it was over, got %s", sent.String())
+	}
+
+	sendTokens(progress, " And ", "logs")
+	if strings.Count(sent.String(), "event: token") != 3 {
+		t.Fatalf("the next sentence went out before it was over: %s", sent.String())
+	}
+	progress.responseModerated(false)
+	if strings.Count(sent.String(), "event: token") != 5 || !strings.Contains(sent.String(), "logs") {
+		t.Errorf("expected the rest once moderation passed the response, got %s", sent.String())
+	}
+}
+
+func TestNothingGoesOutAfterAFlaggedSentence(t *testing.T) {
+	sent := bytes.Buffer{}
+	progress := newStreamedProgress(context.Background(), newSseWriter(&sent, nil), testModerator{})
+
+	sendTokens(progress, "Fine. ", "Something ", "rude. ", "Fine ", "again.")
+	progress.responseModerated(true)
+	if strings.Count(sent.String(), "event: token") != 1 || strings.Contains(sent.String(), "rude") || strings.Contains(sent.String(), "again") {
+		t.Errorf("expected only the sentence before the flagged one, got %s", sent.String())
+	}
+}
+
+func TestFlaggedResponseHoldsBackTheLastSentence(t *testing.T) {
+	sent := bytes.Buffer{}
+	progress := newStreamedProgress(context.Background(), newSseWriter(&sent, nil), testModerator{})
+
+	sendTokens(progress, "something ", "unfinished")
+	progress.responseModerated(true)
+	if sent.Len() != 0 {
+		t.Errorf("a flagged response went to the screen: %s", sent.String())
+	}
+}
+
+// remembers what it was asked to moderate
+type listeningModerator struct {
+	seen []string
+}
+
+func (m *listeningModerator) name() string { return MODERATION_LLM }
+func (m *listeningModerator) moderate(currentContext context.Context, text string) moderationResult {
+	m.seen = append(m.seen, text)
+	return moderationResult{}
+}
+
+func TestModerationSeesTheResponseSoFar(t *testing.T) {
+	sent := bytes.Buffer{}
+	moderator := &listeningModerator{}
+	progress := newStreamedProgress(context.Background(), newSseWriter(&sent, nil), moderator)
+
+	sendTokens(progress, "One. ", "Two ", "three!")
+	if len(moderator.seen) != 2 || moderator.seen[1] != "One. Two three!" {
+		t.Errorf("expected each sentence moderated along with what came before, got %q", moderator.seen)
+	}
+}
+
+func TestTokensStreamWhenModerationIsOff(t *testing.T) {
+	sent := bytes.Buffer{}
+	progress := newStreamedProgress(context.Background(), newSseWriter(&sent, nil), noModerator{})
+
+	sendTokens(progress, "right ", "away")
+	if strings.Count(sent.String(), "event: token") != 2 {
+		t.Errorf("expected the tokens as they arrived, got %s", sent.String())
+	}
+}
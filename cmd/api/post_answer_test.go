@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"observaquiz_lambda/cmd/api/costs"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/**
+ * The answer paths, end to end, against the LLM responses in testdata/llm_fixtures.
+ * Change a prompt, and these fail with the new request in testdata/llm_fixtures/missed:
+ * record it again with llm_mode=record, or write its raw_output by hand, and move it up a directory.
+ */
+
+const (
+	testEventName    = "devopsdays_whenever"
+	v1QuestionId     = "e46ab4ba-b284-49dd-b12f-ecd2e9755767" // What can we get out of great observability?
+	v2QuestionId     = "6f032388-e80a-47ef-aa05-d8aac6ef3c42" // How does your software tell you what is happening?
+	replayedV1Answer = "We can find out why things are slow, and fix bugs faster, because we can see what the code is doing in production."
+	replayedV2Answer = "When a customer complains we search our logs in Splunk and look at dashboards in Datadog. We started sending OpenTelemetry traces to Honeycomb last month."
+)
+
+func testQuestion(t *testing.T, questionId string) Question {
+	t.Helper()
+	question, found := findQuestion(testEventName, questionId)
+	if !found {
+		t.Fatalf("no question %s in %s", questionId, testEventName)
+	}
+	return question
+}
+
+func answerRequest(questionId string, executionId string, answer string) events.APIGatewayV2HTTPRequest {
+	body, _ := json.Marshal(AnswerBody{Answer: answer})
+	request := events.APIGatewayV2HTTPRequest{
+		Headers: map[string]string{EXECUTION_ID_HEADER: executionId, ATTENDEE_API_KEY_HEADER: "test-key-" + executionId},
+		Body:    string(body),
+	}
+	request.RequestContext.HTTP.Method = "POST"
+	request.RequestContext.HTTP.Path = "/api/questions/" + questionId + "/answer"
+	request.RequestContext.HTTP.SourceIP = "192.0.2.10"
+	return request
+}
+
+func postTestAnswer(t *testing.T, questionId string, executionId string, answer string) PostAnswerResponse {
+	t.Helper()
+	response, err := postAnswer(context.Background(), answerRequest(questionId, executionId, answer))
+	if err != nil || response.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d %v: %s", response.StatusCode, err, response.Body)
+	}
+	result := PostAnswerResponse{}
+	err = json.Unmarshal([]byte(response.Body), &result)
+	if err != nil {
+		t.Fatalf("the response isn't JSON: %v: %s", err, response.Body)
+	}
+	return result
+}
+
+func scopedContext(questionId string, executionId string) context.Context {
+	return costs.WithScope(context.Background(), costs.Scope{EventName: testEventName, QuestionId: questionId, ExecutionId: executionId})
+}
+
+func TestRespondToAnswerV1Replayed(t *testing.T) {
+	response, errorResponse := respondToAnswerV1(scopedContext(v1QuestionId, "replay-v1-direct"), testQuestion(t, v1QuestionId), AnswerBody{Answer: replayedV1Answer})
+	if errorResponse != nil {
+		t.Fatalf("%d %s", errorResponse.statusCode, errorResponse.message)
+	}
+	if response.score != 85 || response.possibleScore != 100 {
+		t.Errorf("expected 85 of 100, got %d of %d", response.score, response.possibleScore)
+	}
+	if response.response == "" {
+		t.Errorf("expected the response from the fixture")
+	}
+}
+
+func TestRespondToAnswerV2Replayed(t *testing.T) {
+	response, errorResponse := respondToAnswerV2(scopedContext(v2QuestionId, "replay-v2-direct"), testQuestion(t, v2QuestionId), AnswerBody{Answer: replayedV2Answer})
+	if errorResponse != nil {
+		t.Fatalf("%d %s", errorResponse.statusCode, errorResponse.message)
+	}
+	if response.category != "Observability 1.5" {
+		t.Errorf("expected the category from the fixture, got %q", response.category)
+	}
+	if response.partialScore {
+		t.Errorf("every scoring prompt has a fixture, so the score shouldn't be partial")
+	}
+	expectedScores := map[string]int{
+		"respond about their current observability": 18,
+		"did they talk about feedback loops":        4,
+	}
+	total := 0
+	for _, part := range response.scoreParts {
+		if part.status != SCORE_STATUS_SCORED {
+			t.Errorf("%s: expected scored, got %s", part.description, part.status)
+		}
+		if expected, ok := expectedScores[part.description]; ok && part.score != expected {
+			t.Errorf("%s: expected %d, got %d", part.description, expected, part.score)
+		}
+		total += part.score
+	}
+	if response.score != total {
+		t.Errorf("the parts add up to %d, but the score is %d", total, response.score)
+	}
+}
+
+func TestPostAnswerV1Replayed(t *testing.T) {
+	result := postTestAnswer(t, v1QuestionId, "replay-v1-endpoint", replayedV1Answer)
+
+	if result.Score != 85 || result.PossibleScore != 100 {
+		t.Errorf("expected 85 of 100, got %d of %d", result.Score, result.PossibleScore)
+	}
+	if len(result.ScoreComponents) != 0 {
+		t.Errorf("v1 has no score components, got %v", result.ScoreComponents)
+	}
+}
+
+func TestPostAnswerV2Replayed(t *testing.T) {
+	result := postTestAnswer(t, v2QuestionId, "replay-v2-endpoint", replayedV2Answer)
+
+	total, possible := 0, 0
+	for _, component := range result.ScoreComponents {
+		total += component.Score
+		possible += component.MaximumScore
+	}
+	if result.Score != total || result.PossibleScore != possible || result.RawScore != nil {
+		t.Errorf("without a scoring policy, expected the components' %d of %d as they are, got %+v", total, possible, result)
+	}
+	if result.ScoreComponents[0].Reasoning == "" {
+		t.Errorf("the question has show_reasoning, so the reasoning should be there")
+	}
+
+	session, found, err := attendeeStore.Session(context.Background(), testEventName, "replay-v2-endpoint")
+	if err != nil || !found || len(session.Answers) != 1 || session.Answers[0].Score != result.Score {
+		t.Errorf("expected the answer in the attendee store with score %d, got %+v (found %v, %v)", result.Score, session.Answers, found, err)
+	}
+}
+
+func TestPostAnswerV2ScaledByTheEventsPolicy(t *testing.T) {
+	withTestScoringPolicy(t, ScoringPolicy{PointsPerQuestion: 100, Rounding: ROUNDING_NEAREST, MinimumParticipationScore: 5})
+	result := postTestAnswer(t, v2QuestionId, "replay-v2-scaled", replayedV2Answer)
+
+	if result.PossibleScore != 100 || result.RawScore == nil || result.RawPossible == nil {
+		t.Fatalf("expected a score scaled to 100 with the raw score alongside, got %+v", result)
+	}
+	rawTotal := 0
+	for _, component := range result.ScoreComponents {
+		rawTotal += component.Score
+	}
+	if rawTotal != *result.RawScore {
+		t.Errorf("the components add up to %d, not the raw score %d", rawTotal, *result.RawScore)
+	}
+	if expected := (*result.RawScore*100 + *result.RawPossible/2) / *result.RawPossible; result.Score != expected {
+		t.Errorf("expected %d of 100 scaled to be %d, got %d", *result.RawScore, expected, result.Score)
+	}
+}
+
+func TestPostAnswerStreamV2Replayed(t *testing.T) {
+	sent := bytes.Buffer{}
+	request := answerRequest(v2QuestionId, "replay-v2-stream", replayedV2Answer)
+	request.RequestContext.HTTP.Path += "/stream"
+	postAnswerStream(context.Background(), request, newSseWriter(&sent, nil))
+
+	streamed := sent.String()
+	for _, event := range []string{"event: category", "event: token", "event: partial_score", "event: result"} {
+		if !strings.Contains(streamed, event) {
+			t.Errorf("expected %q in the stream: %s", event, streamed)
+		}
+	}
+	if strings.Contains(streamed, "event: error") {
+		t.Errorf("expected no errors: %s", streamed)
+	}
+}
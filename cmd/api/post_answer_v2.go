@@ -2,16 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"math"
 	"observaquiz_lambda/cmd/api/deepchecks"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/sourcegraph/conc"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
@@ -19,19 +19,11 @@ import (
 
 type openaiApi struct {
 	model  string
-	client *openai.Client
+	client llmClient
 }
 
 func newOpenaiApi(model string, key string) *openaiApi {
-	httpClient := http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
-	}
-
-	openAIConfig := openai.DefaultConfig(key)
-	openAIConfig.HTTPClient = &httpClient
-	client := openai.NewClientWithConfig(openAIConfig)
-
-	return &openaiApi{model: model, client: client}
+	return &openaiApi{model: model, client: newLlmClient(key)}
 }
 
 type chatResult struct {
@@ -39,23 +31,40 @@ type chatResult struct {
 	evaluationId    string
 }
 
+type chatRequest struct {
+	theirAnswer    string
+	promptTemplate string
+	replacements   map[string]string
+	wantsJson      bool
+	followUp       []openai.ChatCompletionMessage // after the system prompt, like when we ask it to fix its JSON
+	onToken        func(string)                   // receives each piece of the response as OpenAI streams it. nil to wait for the whole thing
+	temperature    *float32                       // nil for OpenAI's default
+	seed           *int
+}
+
 func (api openaiApi) chat(currentContext context.Context, theirAnswer string, promptTemplate string, replacements map[string]string, wantsJson bool, output *chatResult) (err error) {
+	return api.send(currentContext, chatRequest{theirAnswer: theirAnswer, promptTemplate: promptTemplate, replacements: replacements, wantsJson: wantsJson}, output)
+}
+
+func (api openaiApi) send(currentContext context.Context, request chatRequest, output *chatResult) (err error) {
 	currentContext, span := tracer.Start(currentContext, "chat with AI")
 	defer span.End()
 	span.SetAttributes(attribute.String("app.llm.model", api.model),
-		attribute.String("app.llm.input", theirAnswer),
-		attribute.String("app.llm.prompt_template", promptTemplate),
-		attribute.Bool("app.llm.wantsJson", wantsJson),
+		attribute.String("app.llm.input", request.theirAnswer),
+		attribute.String("app.llm.prompt_template", request.promptTemplate),
+		attribute.Bool("app.llm.wantsJson", request.wantsJson),
+		attribute.Bool("app.llm.streaming", request.onToken != nil),
+		attribute.Int("app.llm.follow_up_messages_qty", len(request.followUp)),
 	)
 
 	startTime := time.Now()
 	model := openai.GPT3Dot5Turbo1106
 
-	prompt := replaceInString(currentContext, promptTemplate, replacements)
+	prompt := replaceInString(currentContext, request.promptTemplate, request.replacements)
 	span.SetAttributes(attribute.String("app.llm.prompt", prompt))
 
 	var responseType openai.ChatCompletionResponseFormatType // boo, get a real ternary operator golang
-	if wantsJson {
+	if request.wantsJson {
 		responseType = openai.ChatCompletionResponseFormatTypeJSONObject
 	} else {
 		responseType = openai.ChatCompletionResponseFormatTypeText
@@ -64,17 +73,40 @@ func (api openaiApi) chat(currentContext context.Context, theirAnswer string, pr
 
 	openaiMessage := openai.ChatCompletionMessage{Role: "system", Content: prompt}
 
-	openaiChatCompletionResponse, err := api.client.CreateChatCompletion(
-		currentContext,
-		openai.ChatCompletionRequest{
-			ResponseFormat: &openai.ChatCompletionResponseFormat{
-				Type: responseType,
-			},
-			MaxTokens: 2000,
-			Model:     model,
-			Messages:  []openai.ChatCompletionMessage{openaiMessage},
+	completionRequest := openai.ChatCompletionRequest{
+		ResponseFormat: &openai.ChatCompletionResponseFormat{
+			Type: responseType,
 		},
-	)
+		MaxTokens: 2000,
+		Model:     model,
+		Messages:  append([]openai.ChatCompletionMessage{openaiMessage}, request.followUp...),
+		Seed:      request.seed,
+	}
+	if request.temperature != nil {
+		completionRequest.Temperature = *request.temperature
+		if completionRequest.Temperature == 0 {
+			completionRequest.Temperature = zeroTemperature
+		}
+		span.SetAttributes(attribute.Float64("app.llm.temperature", float64(completionRequest.Temperature)))
+	}
+	if request.seed != nil {
+		span.SetAttributes(attribute.Int("app.llm.seed", *request.seed))
+	}
+
+	var llmResponse string
+	if request.onToken == nil {
+		var openaiChatCompletionResponse openai.ChatCompletionResponse
+		openaiChatCompletionResponse, err = api.client.complete(currentContext, completionRequest)
+		if err == nil {
+			addLlmResponseAttributesToSpan(span, openaiChatCompletionResponse)
+			llmResponse = openaiChatCompletionResponse.Choices[0].Message.Content
+		}
+	} else {
+		llmResponse, err = api.client.completeStreaming(currentContext, completionRequest, request.onToken)
+	}
+	if errors.Is(err, errOverBudget) {
+		return err // not a failure; the caller has a fallback
+	}
 	if err != nil {
 		span.RecordError(err,
 			trace.WithAttributes(
@@ -85,14 +117,11 @@ func (api openaiApi) chat(currentContext context.Context, theirAnswer string, pr
 		return err
 	}
 
-	addLlmResponseAttributesToSpan(span, openaiChatCompletionResponse)
-	llmResponse := openaiChatCompletionResponse.Choices[0].Message.Content
-
 	/* report for analysis */
 
 	interactionReported := deepchecks.DeepChecksAPI{ApiKey: settings.DeepchecksApiKey}.ReportInteraction(currentContext, deepchecks.LLMInteractionDescription{
 		FullPrompt: prompt,
-		Input:      theirAnswer,
+		Input:      request.theirAnswer,
 		Output:     llmResponse,
 		StartedAt:  startTime,
 		FinishedAt: time.Now(),
@@ -127,70 +156,116 @@ func respondToAnswerV2(currentContext context.Context, questionDefinition Questi
 
 	var question string = questionDefinition.Question
 	span.SetAttributes(attribute.String("app.post_answer.question", question))
-	llmApi := newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey)
+	return respondToAnswerV2With(currentContext, newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey), questionDefinition, answer)
+}
 
+// the response and the scoring at once, with whatever LLM you give it. The tests give it a fake one
+func respondToAnswerV2With(currentContext context.Context, llmApi *openaiApi, questionDefinition Question, answer AnswerBody) (response *responseToAnswer, errorResponse *errorResponseType) {
+	span := trace.SpanFromContext(currentContext)
 	substitutions := map[string]string{
 		"THEIR ANSWER": answer.Answer,
 		"QUESTION":     questionDefinition.Question,
 	}
 
+	// if we can't respond, there's no point finishing the scoring
+	currentContext, cancel := context.WithCancel(currentContext)
+	defer cancel()
+
+	// each goroutine gets its own copy of the substitutions (determineResponse adds CATEGORY) and its own error
 	responseResponse := chatResult{}
+	assignedCategory := ""
 	scoreOutput := scoreResult{}
-	errList := []errorResponseType{}
+	var responseErr, scoreErr *errorResponseType
 	var wg conc.WaitGroup
 	wg.Go(func() {
-		err := determineResponse(currentContext, llmApi, questionDefinition, answer, substitutions, &responseResponse)
-		if err != nil {
-			errList = append(errList, *err)
+		responseErr = determineResponse(currentContext, llmApi, questionDefinition, answer, copySubstitutions(substitutions), &responseResponse, &assignedCategory)
+		if responseErr != nil {
+			cancel()
 		}
 	})
 	wg.Go(func() {
-		err := scoreAnswer(currentContext, llmApi, questionDefinition, answer, substitutions, &scoreOutput)
-		if err != nil {
-			errList = append(errList, *err)
-		}
+		scoreErr = scoreAnswer(currentContext, llmApi, questionDefinition, answer, copySubstitutions(substitutions), &scoreOutput)
 	})
 	wg.Wait()
 
-	if len(errList) != 0 {
-		// TODO: put a span event for each, or soemthing
-		return nil, &errList[0]
+	if responseErr != nil {
+		span.SetAttributes(attribute.String("app.post_answer.failed_stage", "response"))
+		return nil, responseErr
+	}
+	if scoreErr != nil {
+		span.SetAttributes(attribute.String("app.post_answer.failed_stage", "score"))
+		return nil, scoreErr
 	}
 
 	return &responseToAnswer{
-		response:      responseResponse.responseContent,
-		score:         scoreOutput.score,
-		possibleScore: scoreOutput.possibleScore,
-		evaluationId:  responseResponse.evaluationId}, nil
+		response:          responseResponse.responseContent,
+		score:             scoreOutput.score,
+		possibleScore:     scoreOutput.possibleScore,
+		evaluationId:      responseResponse.evaluationId,
+		category:          assignedCategory,
+		scoreParts:        scoreOutput.parts,
+		partialScore:      scoreOutput.partial,
+		showReasoning:     questionDefinition.Scoring.ShowReasoning,
+		responseModerated: true}, nil
 }
 
-func determineResponse(currentContext context.Context, llmApi *openaiApi, questionDefinition Question, answer AnswerBody, substitutions map[string]string, output *chatResult) (errorResponse *errorResponseType) {
+func copySubstitutions(substitutions map[string]string) map[string]string {
+	copied := make(map[string]string, len(substitutions))
+	for k, v := range substitutions {
+		copied[k] = v
+	}
+	return copied
+}
+
+func determineResponse(currentContext context.Context, llmApi *openaiApi, questionDefinition Question, answer AnswerBody, substitutions map[string]string, output *chatResult, assignedCategory *string) (errorResponse *errorResponseType) {
 	span := trace.SpanFromContext(currentContext)
+	currentContext = withLlmPriority(currentContext, llmPriorityResponse)
 	categoryResult := CategoryResult{}
 	{
 		categoryResponse := chatResult{}
-		err := llmApi.chat(currentContext, answer.Answer, questionDefinition.PromptsV2.CategoryPrompt, substitutions, true, &categoryResponse)
-		if err != nil {
+		err := chatForValidJson(currentContext, llmApi, chatRequest{theirAnswer: answer.Answer, promptTemplate: questionDefinition.PromptsV2.CategoryPrompt, replacements: substitutions},
+			categorySchema(questionDefinition.PromptsV2.Categories), &categoryResponse, &categoryResult)
+		span.SetAttributes(attribute.String("app.llm.category_response", categoryResponse.responseContent))
+		if errors.Is(err, errOverBudget) {
+			output.responseContent = overBudgetResponse
+			return nil
+		}
+		if errors.Is(err, errLlmUnreachable) {
 			return &errorResponseType{message: "Could not reach LLM. No fallback in place", statusCode: 500}
-
 		}
-		span.SetAttributes(attribute.String("app.llm.category_response", categoryResponse.responseContent))
-
-		err = json.Unmarshal([]byte(categoryResponse.responseContent), &categoryResult)
 		if err != nil {
+			span.RecordError(err)
 			return &errorResponseType{message: "Could not parse category response", statusCode: 500}
 		}
 		span.SetAttributes(attribute.String("app.llm.assigned_category", categoryResult.Category))
+		answerProgressFrom(currentContext).categoryAssigned(categoryResult)
 	}
 	substitutions["CATEGORY"] = categoryResult.Category
+	*assignedCategory = categoryResult.Category
 	/* now the RESPONSE */
 	{
-		err := llmApi.chat(currentContext, answer.Answer, questionDefinition.PromptsV2.ResponsePrompt, substitutions, false, output)
+		err := llmApi.send(currentContext, chatRequest{
+			theirAnswer:    answer.Answer,
+			promptTemplate: questionDefinition.PromptsV2.ResponsePrompt,
+			replacements:   substitutions,
+			onToken:        answerProgressFrom(currentContext).responseTokenListener(),
+		}, output)
+		if errors.Is(err, errOverBudget) {
+			output.responseContent = overBudgetResponse
+			return nil
+		}
 		if err != nil {
 			return &errorResponseType{message: "Could not reach LLM. No fallback in place", statusCode: 500}
 		}
 		span.SetAttributes(attribute.String("app.llm.response", output.responseContent))
 	}
+	// here rather than after scoring, so the held-back tokens can go out as soon as the response passes
+	moderation := moderateText(currentContext, "response", output.responseContent)
+	if moderation.flagged {
+		span.SetAttributes(attribute.Bool("app.moderation.response_flagged", true))
+		output.responseContent = safeLlmResponse
+	}
+	answerProgressFrom(currentContext).responseModerated(moderation.flagged)
 	return
 }
 
@@ -198,12 +273,29 @@ type scoreResult struct {
 	possibleScore int
 	score         int
 	parts         []partialScore
+	partial       bool // some scoring prompts didn't work out, so they got nothing for those
 }
 
+const (
+	SCORE_STATUS_SCORED    = "scored"
+	SCORE_STATUS_FAILED    = "failed"    // the LLM didn't answer, or we couldn't make sense of it
+	SCORE_STATUS_CANCELLED = "cancelled" // the response failed, so we stopped scoring
+	SCORE_STATUS_SKIPPED   = "skipped"   // over budget
+)
+
 type partialScore struct {
+	description   string
 	possibleScore int
 	score         int
 	reasoning     string
+	confidence    string
+	matchedWords  []string // pointy words only
+	penaltyWords  []string // the negative pointy words they said
+	samples       []int    // with consensus: what each sample said
+	spread        float64  // standard deviation of the samples
+	lowConfidence bool     // the samples disagreed too much
+	status        string
+	overriddenBy  string // the guard, when it changed the score after the fact
 }
 
 // { "score": 0-20, "confidence": "string describing your confidence in your answer", "reasoning": "Why you gave the score you did"}
@@ -216,6 +308,7 @@ type ScoreResponse struct {
 func scoreAnswer(currentContext context.Context, llmApi *openaiApi, questionDefinition Question, answer AnswerBody, substitutions map[string]string, output *scoreResult) (errorResponse *errorResponseType) {
 	currentContext, span := tracer.Start(currentContext, "score answer")
 	defer span.End()
+	currentContext = withLlmPriority(currentContext, llmPriorityScoring)
 	span.SetAttributes(attribute.String("app.llm.input", answer.Answer))
 
 	var question string = questionDefinition.Question
@@ -223,74 +316,138 @@ func scoreAnswer(currentContext context.Context, llmApi *openaiApi, questionDefi
 		attribute.String("app.score.answer", answer.Answer),
 		attribute.Int("app.score.prompts_qty", len(questionDefinition.Scoring.ScoringPrompts)))
 
-	// TODO: run these in parallel
-
-	partialScores := []partialScore{}
-	errList := []errorResponseType{}
+	scoringPrompts := questionDefinition.Scoring.ScoringPrompts
+	// each goroutine fills in its own spot; no appending from more than one at once
+	promptScores := make([]partialScore, len(scoringPrompts))
 	var wg conc.WaitGroup
-
-	for _, scoreComponent := range questionDefinition.Scoring.ScoringPrompts {
+	for i, scoreComponent := range scoringPrompts {
+		i, scoreComponent := i, scoreComponent
 		wg.Go(func() {
-			promptScore := partialScore{}
-			currentContext, span := tracer.Start(currentContext, "score with llm")
-			defer span.End()
-			scoreChatResult := chatResult{}
-			err := llmApi.chat(currentContext, answer.Answer, scoreComponent.Prompt, substitutions, true, &scoreChatResult)
-			if err != nil {
-				errList = append(errList, errorResponseType{message: "Could not reach LLM. No fallback in place", statusCode: 500})
-				return
-			}
-			span.SetAttributes(attribute.String("app.llm.output", scoreChatResult.responseContent))
-
-			scoreResponse := ScoreResponse{}
-			err = json.Unmarshal([]byte(scoreChatResult.responseContent), &scoreResponse)
-			if err != nil {
-				errList = append(errList, errorResponseType{message: "Could not parse score response", statusCode: 500})
-				return
-			}
-			span.SetAttributes(attribute.Int("app.score.maximum_score", scoreComponent.MaximumScore),
-				attribute.Int("app.score.score", scoreResponse.Score),
-				attribute.String("app.llm.confidence", scoreResponse.Confidence),
-				attribute.String("app.llm.reasoning", scoreResponse.Reasoning))
-
-			promptScore.possibleScore = scoreComponent.MaximumScore
-			promptScore.score = scoreResponse.Score
-			promptScore.reasoning = scoreResponse.Reasoning
-			partialScores = append(partialScores, promptScore) // is append threadSafe??
+			promptScores[i] = scoreWithPrompt(currentContext, llmApi, answer, substitutions, scoreComponent)
 		})
 	}
 	wg.Wait()
 
-	pointyWordScore := partialScore{}
-	{
-		_, span := tracer.Start(currentContext, "score pointy words")
-		defer span.End()
-		pointyWords := questionDefinition.Scoring.PointyWords
-		pointyWordScore.possibleScore = len(pointyWords)
-		for _, word := range pointyWords {
-			if strings.Contains(answer.Answer, word) {
-				pointyWordScore.score++
+	statusCounts := map[string]int{}
+	for _, promptScore := range promptScores {
+		statusCounts[promptScore.status]++
+	}
+	span.SetAttributes(attribute.Int("app.score.prompts_scored", statusCounts[SCORE_STATUS_SCORED]),
+		attribute.Int("app.score.prompts_failed", statusCounts[SCORE_STATUS_FAILED]),
+		attribute.Int("app.score.prompts_cancelled", statusCounts[SCORE_STATUS_CANCELLED]),
+		attribute.Int("app.score.prompts_skipped", statusCounts[SCORE_STATUS_SKIPPED]))
+	if statusCounts[SCORE_STATUS_CANCELLED] > 0 {
+		return &errorResponseType{message: "Scoring was cancelled", statusCode: 500}
+	}
+	if len(scoringPrompts) > 0 && statusCounts[SCORE_STATUS_FAILED] == len(scoringPrompts) {
+		// none of them worked. That's not a partial score, that's no score
+		return &errorResponseType{message: "Could not score answer", statusCode: 500}
+	}
+
+	pointyWordScore := scorePointyWords(currentContext, questionDefinition.Scoring, answer.Answer)
+	answerProgressFrom(currentContext).partialScoreComputed(pointyWordScore)
+
+	sumPartialScores(output, append(promptScores, pointyWordScore))
+
+	span.SetAttributes(attribute.Bool("app.score.partial", output.partial))
+	return
+
+}
+
+func scoreWithPrompt(currentContext context.Context, llmApi *openaiApi, answer AnswerBody, substitutions map[string]string, scoreComponent ScoringPrompt) partialScore {
+	promptScore := partialScore{description: scoreComponent.Description, possibleScore: scoreComponent.MaximumScore}
+	currentContext, span := tracer.Start(currentContext, "score with llm")
+	defer span.End()
+	span.SetAttributes(attribute.String("app.score.description", scoreComponent.Description),
+		attribute.Int("app.score.samples_qty", scoreComponent.Consensus.samples()))
+
+	samples := sampleScores(currentContext, llmApi, chatRequest{theirAnswer: answer.Answer, promptTemplate: scoreComponent.promptWithAnchors(), replacements: substitutions}, scoreComponent)
+	scores := []int{}
+	var firstErr error
+	for _, sample := range samples {
+		if sample.err != nil {
+			if firstErr == nil {
+				firstErr = sample.err
 			}
+			continue
+		}
+		scores = append(scores, sample.response.Score)
+	}
+	if len(scores) == 0 {
+		promptScore.status = SCORE_STATUS_FAILED
+		if currentContext.Err() != nil {
+			promptScore.status = SCORE_STATUS_CANCELLED
+		} else if errors.Is(firstErr, errOverBudget) {
+			// degraded mode: no LLM for scoring, only the pointy words
+			promptScore.status = SCORE_STATUS_SKIPPED
 		}
-		span.SetAttributes(attribute.Int("app.score.score", pointyWordScore.score),
-			attribute.Int("app.score.possible_score", pointyWordScore.possibleScore),
-			attribute.String("app.llm.answer", answer.Answer))
+		span.RecordError(firstErr)
+		span.SetAttributes(attribute.String("app.llm.output", samples[0].output.responseContent),
+			attribute.String("app.score.status", promptScore.status))
+		return promptScore
 	}
 
-	partialScores = append(partialScores, pointyWordScore)
+	promptScore.score = scoreComponent.Consensus.combine(scores)
+	// the reasoning comes from whichever sample is closest to the combined score
+	closest := ScoreResponse{Score: math.MaxInt}
+	for _, sample := range samples {
+		if sample.err == nil && absoluteDifference(sample.response.Score, promptScore.score) < absoluteDifference(closest.Score, promptScore.score) {
+			closest = sample.response
+			span.SetAttributes(attribute.String("app.llm.output", sample.output.responseContent))
+		}
+	}
+	promptScore.reasoning = closest.Reasoning
+	promptScore.confidence = closest.Confidence
+
+	if len(samples) > 1 {
+		promptScore.samples = scores
+		promptScore.spread = standardDeviationOf(scores)
+		promptScore.lowConfidence = promptScore.spread > scoreComponent.Consensus.lowConfidenceSpread()*float64(scoreComponent.MaximumScore)
+		if promptScore.lowConfidence {
+			promptScore.confidence = "low: the samples disagreed"
+		}
+		sortedScores := append([]int{}, scores...)
+		sort.Ints(sortedScores)
+		span.SetAttributes(attribute.IntSlice("app.score.samples", scores),
+			attribute.Int("app.score.samples_failed", len(samples)-len(scores)),
+			attribute.Float64("app.score.sample_stddev", promptScore.spread),
+			attribute.Int("app.score.sample_disagreement", sortedScores[len(sortedScores)-1]-sortedScores[0]),
+			attribute.String("app.score.combine", scoreComponent.Consensus.Combine),
+			attribute.Bool("app.score.low_confidence", promptScore.lowConfidence))
+	}
 
-	sumPartialScores(output, partialScores)
+	span.SetAttributes(attribute.Int("app.score.maximum_score", scoreComponent.MaximumScore),
+		attribute.Int("app.score.score", promptScore.score),
+		attribute.String("app.llm.confidence", promptScore.confidence),
+		attribute.String("app.llm.reasoning", promptScore.reasoning),
+		attribute.String("app.score.status", SCORE_STATUS_SCORED))
 
-	return
+	promptScore.status = SCORE_STATUS_SCORED
+	answerProgressFrom(currentContext).partialScoreComputed(promptScore)
+	return promptScore
+}
 
+func absoluteDifference(a int, b int) int {
+	if a > b {
+		return a - b
+	}
+	return b - a
 }
 
+// Every part counts toward the possible score, scored or not: a prompt that failed or was skipped is points they
+// didn't get, not points that don't exist. Otherwise scaling to the event's points per question would blow a
+// partial score up to look like a whole one.
 func sumPartialScores(output *scoreResult, partialScores []partialScore) {
 	output.score = 0
 	output.possibleScore = 0
+	output.partial = false
 	for _, s := range partialScores {
-		output.score += s.score
 		output.possibleScore += s.possibleScore
+		if s.status != SCORE_STATUS_SCORED {
+			output.partial = true
+			continue
+		}
+		output.score += clampScore(s.score, s.possibleScore)
 	}
 	output.parts = partialScores
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+/**
+ * The response and the scoring run at once, and the scoring prompts run at once.
+ * These use a fake LLM to make them fail, and overlap, on purpose. Run them with -race.
+ */
+
+// answers each request by its system prompt
+type fakeLlmClient struct {
+	respond func(currentContext context.Context, prompt string) (string, error)
+}
+
+func (c fakeLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	output, err := c.respond(currentContext, request.Messages[0].Content)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: output}}}}, nil
+}
+
+func (c fakeLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	output, err := c.respond(currentContext, request.Messages[0].Content)
+	if err == nil {
+		onToken(output)
+	}
+	return output, err
+}
+
+func fakeLlmApi(respond func(currentContext context.Context, prompt string) (string, error)) *openaiApi {
+	return &openaiApi{model: "fake", client: fakeLlmClient{respond: respond}}
+}
+
+const (
+	fakeCategoryPrompt = "categorize: THEIR ANSWER"
+	fakeResponsePrompt = "respond to CATEGORY: THEIR ANSWER"
+)
+
+// scoring prompt i is "score i", and it's out of 20
+func fakeQuestion(scoringPromptsQty int) Question {
+	question := Question{
+		Question:  "How does your software tell you what is happening?",
+		Version:   "v2",
+		PromptsV2: PromptsV2{CategoryPrompt: fakeCategoryPrompt, ResponsePrompt: fakeResponsePrompt},
+	}
+	for i := 0; i < scoringPromptsQty; i++ {
+		question.Scoring.ScoringPrompts = append(question.Scoring.ScoringPrompts,
+			ScoringPrompt{Prompt: fmt.Sprintf("score %d", i), MaximumScore: 20, Description: fmt.Sprintf("prompt %d", i)})
+	}
+	return question
+}
+
+// the prompts that go through fine
+func fakeAnswer(prompt string) (string, error) {
+	switch {
+	case strings.HasPrefix(prompt, "categorize"):
+		return `{"category": "logs", "confidence": "high", "reasoning": "they said logs"}`, nil
+	case strings.HasPrefix(prompt, "respond"):
+		return "Logs are a fine place to start.", nil
+	}
+	var i int
+	fmt.Sscanf(prompt, "score %d", &i)
+	return fmt.Sprintf(`{"score": %d, "confidence": "high", "reasoning": "because %d"}`, i, i), nil
+}
+
+func TestOneScoringPromptFailingLeavesAPartialScore(t *testing.T) {
+	llmApi := fakeLlmApi(func(currentContext context.Context, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "score 1") {
+			return "", errors.New("the LLM fell over")
+		}
+		return fakeAnswer(prompt)
+	})
+
+	response, errorResponse := respondToAnswerV2With(context.Background(), llmApi, fakeQuestion(3), AnswerBody{Answer: "we read the logs"})
+	if errorResponse != nil {
+		t.Fatalf("one failed prompt shouldn't fail the answer: %d %s", errorResponse.statusCode, errorResponse.message)
+	}
+	if !response.partialScore {
+		t.Errorf("expected a partial score")
+	}
+	if response.scoreParts[1].status != SCORE_STATUS_FAILED {
+		t.Errorf("expected prompt 1 to have failed, got %s", response.scoreParts[1].status)
+	}
+	if response.scoreParts[0].score != 0 || response.scoreParts[2].score != 2 || response.score != 2 {
+		t.Errorf("expected 0 + 2 from the prompts that worked, got %d", response.score)
+	}
+	if response.response != "Logs are a fine place to start." {
+		t.Errorf("the response should be unaffected, got %q", response.response)
+	}
+	if response.possibleScore != 60 {
+		t.Errorf("the failed prompt still counts toward the possible score: expected 60, got %d", response.possibleScore)
+	}
+
+	// 2 of 60 is 3 of 100, not 2 of the 40 that worked scaled up to 5
+	applyScoringPolicy(context.Background(), ScoringPolicy{PointsPerQuestion: 100}, response, true)
+	if response.score != 3 || *response.rawPossibleScore != 60 {
+		t.Errorf("expected 3 of 100 from 2 of 60, got %d of %d from %d of %d", response.score, response.possibleScore, *response.rawScore, *response.rawPossibleScore)
+	}
+}
+
+func TestFailedResponseCancelsTheScoring(t *testing.T) {
+	var cancelledQty atomic.Int32
+	llmApi := fakeLlmApi(func(currentContext context.Context, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "respond") {
+			return "", errors.New("the LLM fell over")
+		}
+		if strings.HasPrefix(prompt, "score") {
+			// hold on until the response fails; if it never cancels us, answer anyway
+			select {
+			case <-currentContext.Done():
+				cancelledQty.Add(1)
+				return "", currentContext.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return fakeAnswer(prompt)
+	})
+
+	_, errorResponse := respondToAnswerV2With(context.Background(), llmApi, fakeQuestion(3), AnswerBody{Answer: "we read the logs"})
+	if errorResponse == nil {
+		t.Fatalf("expected the failed response to fail the answer")
+	}
+	if errorResponse.message == "Scoring was cancelled" {
+		t.Errorf("the error should be the response's, not the scoring's")
+	}
+	if cancelledQty.Load() != 3 {
+		t.Errorf("expected all 3 scoring prompts to be cancelled, %d were", cancelledQty.Load())
+	}
+}
+
+func TestScoringPromptsFillTheirOwnSlots(t *testing.T) {
+	const scoringPromptsQty = 16
+	var inFlight atomic.Int32
+	allInFlight := make(chan struct{})
+	var closeOnce sync.Once
+	llmApi := fakeLlmApi(func(currentContext context.Context, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "score") {
+			// wait for every scoring prompt to be running, so they all finish together
+			if inFlight.Add(1) == scoringPromptsQty {
+				closeOnce.Do(func() { close(allInFlight) })
+			}
+			select {
+			case <-allInFlight:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return fakeAnswer(prompt)
+	})
+
+	response, errorResponse := respondToAnswerV2With(context.Background(), llmApi, fakeQuestion(scoringPromptsQty), AnswerBody{Answer: "we read the logs"})
+	if errorResponse != nil {
+		t.Fatalf("%d %s", errorResponse.statusCode, errorResponse.message)
+	}
+	if inFlight.Load() != scoringPromptsQty {
+		t.Fatalf("expected %d scoring calls, got %d", scoringPromptsQty, inFlight.Load())
+	}
+	expectedTotal := 0
+	for i := 0; i < scoringPromptsQty; i++ {
+		part := response.scoreParts[i]
+		if part.description != fmt.Sprintf("prompt %d", i) || part.score != i || part.reasoning != fmt.Sprintf("because %d", i) {
+			t.Errorf("slot %d has %q scored %d (%s)", i, part.description, part.score, part.reasoning)
+		}
+		expectedTotal += i
+	}
+	if response.score != expectedTotal || response.partialScore {
+		t.Errorf("expected %d, not partial, got %d (partial %v)", expectedTotal, response.score, response.partialScore)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var postConversationEndpoint = apiEndpoint{
+	"POST",
+	"/api/questions/{questionId}/conversation",
+	regexp.MustCompile("^/api/questions/([^/]+)/conversation$"),
+	postConversation,
+	true,
+}
+
+/**
+ * Instead of one answer and one response, have a conversation: "an ongoing dialog, modeling a real conversation."
+ * The question declares objectives, things we want to find out ("how do they know when something is wrong?").
+ * The LLM steers toward the ones they haven't covered yet, and they get the points for each one they cover.
+ *
+ * The conversation is kept per event, execution id, and question, in memory. A new Lambda instance means a new conversation.
+ */
+
+const (
+	defaultConversationMaxTurns  = 5
+	defaultConversationBailAfter = 3 // after this many, the UI can offer a way out
+	conversationIdleTimeout      = 2 * time.Hour
+)
+
+type ConversationConfig struct {
+	SystemPrompt   string                  `json:"system_prompt"` // optional. Replaces QUESTION, OBJECTIVES, and TURNS LEFT
+	Objectives     []ConversationObjective `json:"objectives"`
+	MaxTurns       int                     `json:"max_turns"`
+	BailAfterTurns int                     `json:"bail_after_turns"`
+}
+
+type ConversationObjective struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+	Points      int    `json:"points"`
+}
+
+const defaultConversationSystemPrompt = `You are Jessitron, an evangelist for great observability. You speak in a casual, informal tone.
+You are having a short conversation with someone at a conference booth, to find out about how they observe their software.
+You opened with: QUESTION
+
+Here is what you'd like to find out, and they haven't told you yet:
+OBJECTIVES
+
+Respond to what they said with enthusiasm, and ask one follow-up question that moves toward something you haven't found out yet.
+You have TURNS LEFT more replies in this conversation. If that is 0, wrap up warmly and don't ask a question.
+
+Respond in JSON:
+{ "response": "what you say to them", "objectives_covered": ["ids of the objectives their latest message covers"] }`
+
+type ConversationBody struct {
+	Message string `json:"message"`
+}
+
+type ConversationObjectiveStatus struct {
+	ConversationObjective
+	Covered bool `json:"covered"`
+}
+
+type PostConversationResponse struct {
+	Response      string                        `json:"response"`
+	Turn          int                           `json:"turn"`
+	MaxTurns      int                           `json:"max_turns"`
+	CanBail       bool                          `json:"can_bail"`
+	Finished      bool                          `json:"finished"`
+	Objectives    []ConversationObjectiveStatus `json:"objectives"`
+	Score         int                           `json:"score"`
+	PossibleScore int                           `json:"possible_score"`
+	EvaluationId  string                        `json:"evaluation_id"`
+}
+
+type conversationTurnResult struct {
+	Response          string   `json:"response"`
+	ObjectivesCovered []string `json:"objectives_covered"`
+}
+
+type conversationState struct {
+	lock     sync.Mutex // one turn at a time
+	messages []openai.ChatCompletionMessage
+	turns    int
+	covered  map[string]bool
+	updated  time.Time // belongs to the store's lock, not this one
+}
+
+type conversationStore struct {
+	lock          sync.Mutex
+	conversations map[string]*conversationState
+}
+
+var conversations = &conversationStore{conversations: map[string]*conversationState{}}
+
+func conversationKey(eventName string, executionId string, questionId string) string {
+	return eventName + "\x00" + executionId + "\x00" + questionId
+}
+
+func (store *conversationStore) get(eventName string, executionId string, questionId string) *conversationState {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key, state := range store.conversations { // forget the ones nobody's talking to anymore
+		if time.Since(state.updated) > conversationIdleTimeout {
+			delete(store.conversations, key)
+		}
+	}
+	key := conversationKey(eventName, executionId, questionId)
+	state, ok := store.conversations[key]
+	if !ok {
+		state = &conversationState{covered: map[string]bool{}}
+		store.conversations[key] = state
+	}
+	state.updated = time.Now() // only touched with the store locked
+	return state
+}
+
+// when an attendee's data is deleted: all their conversations, whatever the question
+func (store *conversationStore) forget(eventName string, executionId string) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key := range store.conversations {
+		if strings.HasPrefix(key, conversationKey(eventName, executionId, "")) {
+			delete(store.conversations, key)
+		}
+	}
+}
+
+func (config ConversationConfig) maxTurns() int {
+	if config.MaxTurns <= 0 {
+		return defaultConversationMaxTurns
+	}
+	return config.MaxTurns
+}
+
+func (config ConversationConfig) bailAfterTurns() int {
+	if config.BailAfterTurns <= 0 {
+		return defaultConversationBailAfter
+	}
+	return config.BailAfterTurns
+}
+
+func (config ConversationConfig) objectiveIds() []string {
+	ids := []string{}
+	for _, objective := range config.Objectives {
+		ids = append(ids, objective.Id)
+	}
+	return ids
+}
+
+func postConversation(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (response events.APIGatewayV2HTTPResponse, err error) {
+	currentContext, span := tracer.Start(currentContext, "converse")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("request.body", request.Body))
+	body := ConversationBody{}
+	err = json.Unmarshal([]byte(request.Body), &body)
+	if err != nil || strings.TrimSpace(body.Message) == "" {
+		span.RecordError(fmt.Errorf("error unmarshalling conversation message: %v\n request body: %s", err, request.Body))
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'message': 'stuff' }", 400), nil
+	}
+
+	eventName := getEventName(request)
+	questionId := strings.Split(request.RequestContext.HTTP.Path, "/")[3]
+	executionId := getExecutionId(request)
+	span.SetAttributes(attribute.String("app.conversation.event_name", eventName),
+		attribute.String("app.conversation.question_id", questionId))
+	if executionId == "unset" {
+		return instrumentation.ErrorResponse("A conversation needs the "+EXECUTION_ID_HEADER+" header", 400), nil
+	}
+
+	questionDefinition, questionFound := findQuestion(eventName, questionId)
+	if !questionFound || len(questionDefinition.Conversation.Objectives) == 0 {
+		span.SetStatus(codes.Error, "Couldn't find conversation question")
+		return instrumentation.ErrorResponse("Couldn't find a conversation question with that ID", 404), nil
+	}
+	if errorResponse := checkSessionOwner(currentContext, request, eventName, executionId); errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+	config := questionDefinition.Conversation
+
+	state := conversations.get(eventName, executionId, questionId)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	span.SetAttributes(attribute.Int("app.conversation.turn", state.turns+1),
+		attribute.Int("app.conversation.max_turns", config.maxTurns()))
+	if state.turns >= config.maxTurns() {
+		return instrumentation.ErrorResponse("This conversation is over", 409), nil
+	}
+
+	currentContext = costs.WithScope(currentContext, costs.Scope{EventName: eventName, QuestionId: questionId, ExecutionId: executionId, AttendeeKeyHash: attendeeKeyHashOf(request)})
+	result, errorResponse := takeConversationTurn(currentContext, questionDefinition, state, body.Message)
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		span.RecordError(err, trace.WithAttributes(attribute.String("error.message", "Failure marshalling JSON")))
+		return instrumentation.ErrorResponse("wtaf", 500), nil
+	}
+
+	return events.APIGatewayV2HTTPResponse{Body: string(jsonData), StatusCode: 200}, nil
+}
+
+// call with the state locked
+func takeConversationTurn(currentContext context.Context, questionDefinition Question, state *conversationState, message string) (*PostConversationResponse, *errorResponseType) {
+	span := trace.SpanFromContext(currentContext)
+	config := questionDefinition.Conversation
+	turnsLeft := config.maxTurns() - state.turns - 1
+
+	var reply string
+	var evaluationId string
+	newlyCovered := []string{}
+	if moderation := moderateText(currentContext, "answer", message); moderation.flagged {
+		reply = safeAnswerResponse
+		// don't put it in the history, the LLM doesn't need to see it
+	} else {
+		guardVerdict := checkAnswerWithGuard(currentContext, questionDefinition, AnswerBody{Answer: message})
+
+		history := state.messages
+		if len(history) == 0 {
+			history = []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleAssistant, Content: questionDefinition.Question}}
+		}
+		history = append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: message})
+		llmApi := newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey)
+		output := chatResult{}
+		turnResult := conversationTurnResult{}
+		err := chatForValidJson(currentContext, llmApi, chatRequest{
+			theirAnswer:    message,
+			promptTemplate: config.systemPrompt(),
+			replacements: map[string]string{
+				"QUESTION":   questionDefinition.Question,
+				"OBJECTIVES": describeUncoveredObjectives(config, state.covered),
+				"TURNS LEFT": fmt.Sprintf("%d", turnsLeft),
+			},
+			followUp: history,
+		}, jsonSchema{
+			{name: "response", fieldType: jsonString, required: true},
+			{name: "objectives_covered", fieldType: jsonStrings, allowed: config.objectiveIds()},
+		}, &output, &turnResult)
+		if errors.Is(err, errOverBudget) {
+			// they can pick it up tomorrow; this turn doesn't count
+			span.SetAttributes(attribute.Bool("app.cost.degraded", true))
+			return conversationResponse(config, state, overBudgetResponse, "", newlyCovered), nil
+		}
+		if errors.Is(err, errLlmUnreachable) {
+			return nil, &errorResponseType{message: "Could not reach LLM. No fallback in place", statusCode: 500}
+		}
+		if err != nil {
+			span.RecordError(err)
+			return nil, &errorResponseType{message: "Could not parse conversation response", statusCode: 500}
+		}
+
+		reply = turnResult.Response
+		evaluationId = output.evaluationId
+		if moderation := moderateText(currentContext, "response", reply); moderation.flagged {
+			reply = safeLlmResponse
+		}
+		state.messages = append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply})
+
+		if guardVerdict.detected {
+			// they can keep talking, but instructing the model doesn't count as covering anything
+			span.SetAttributes(attribute.String("app.guard.outcome", "no objectives this turn"))
+		} else {
+			for _, id := range turnResult.ObjectivesCovered {
+				if !state.covered[id] {
+					state.covered[id] = true
+					newlyCovered = append(newlyCovered, id)
+				}
+			}
+		}
+	}
+
+	state.turns++
+
+	result := conversationResponse(config, state, reply, evaluationId, newlyCovered)
+	span.SetAttributes(attribute.Int("app.conversation.turns", state.turns),
+		attribute.StringSlice("app.conversation.newly_covered", newlyCovered),
+		attribute.Int("app.conversation.objectives_covered_qty", len(state.covered)),
+		attribute.Int("app.score.score", result.Score),
+		attribute.Int("app.score.possible_score", result.PossibleScore),
+		attribute.Bool("app.conversation.finished", result.Finished))
+	return result, nil
+}
+
+func conversationResponse(config ConversationConfig, state *conversationState, reply string, evaluationId string, newlyCovered []string) *PostConversationResponse {
+	result := &PostConversationResponse{
+		Response:     reply,
+		Turn:         state.turns,
+		MaxTurns:     config.maxTurns(),
+		CanBail:      state.turns >= config.bailAfterTurns(),
+		Finished:     state.turns >= config.maxTurns(),
+		EvaluationId: evaluationId,
+	}
+	for _, objective := range config.Objectives {
+		covered := state.covered[objective.Id]
+		result.Objectives = append(result.Objectives, ConversationObjectiveStatus{ConversationObjective: objective, Covered: covered})
+		result.PossibleScore += objective.Points
+		if covered {
+			result.Score += objective.Points
+		}
+	}
+	return result
+}
+
+func (config ConversationConfig) systemPrompt() string {
+	if config.SystemPrompt == "" {
+		return defaultConversationSystemPrompt
+	}
+	return config.SystemPrompt
+}
+
+func describeUncoveredObjectives(config ConversationConfig, covered map[string]bool) string {
+	description := strings.Builder{}
+	for _, objective := range config.Objectives {
+		if !covered[objective.Id] {
+			description.WriteString(fmt.Sprintf("- %s: %s\n", objective.Id, objective.Description))
+		}
+	}
+	if description.Len() == 0 {
+		return "(nothing, they've told you everything. Just chat.)"
+	}
+	return description.String()
+}
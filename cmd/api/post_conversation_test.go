@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// the devopsdays v2 question has a conversation section
+const replayedConversationMessage = "An alert goes off in PagerDuty, and then we search the logs in Splunk until we find what broke."
+
+func conversationRequest(executionId string, attendeeApiKey string, message string) events.APIGatewayV2HTTPRequest {
+	body, _ := json.Marshal(ConversationBody{Message: message})
+	request := events.APIGatewayV2HTTPRequest{
+		Headers: map[string]string{"event-name": testEventName, EXECUTION_ID_HEADER: executionId, ATTENDEE_API_KEY_HEADER: attendeeApiKey},
+		Body:    string(body),
+	}
+	request.RequestContext.HTTP.Method = "POST"
+	request.RequestContext.HTTP.Path = "/api/questions/" + v2QuestionId + "/conversation"
+	return request
+}
+
+func postTestConversation(t *testing.T, request events.APIGatewayV2HTTPRequest) (int, PostConversationResponse) {
+	t.Helper()
+	response, err := postConversation(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := PostConversationResponse{}
+	if response.StatusCode == 200 {
+		if err := json.Unmarshal([]byte(response.Body), &result); err != nil {
+			t.Fatalf("the response isn't JSON: %v: %s", err, response.Body)
+		}
+	}
+	return response.StatusCode, result
+}
+
+func TestConversationTurnReplayed(t *testing.T) {
+	status, result := postTestConversation(t, conversationRequest("conversation-replayed", "conversation-key", replayedConversationMessage))
+	if status != 200 {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if result.Turn != 1 || result.MaxTurns != 5 || result.CanBail || result.Finished || result.Response == "" {
+		t.Errorf("expected the first of five turns, with a reply: %+v", result)
+	}
+	covered := map[string]bool{}
+	for _, objective := range result.Objectives {
+		covered[objective.Id] = objective.Covered
+	}
+	if !covered["detect"] || !covered["investigate"] || covered["act"] || result.Score != 70 || result.PossibleScore != 120 {
+		t.Errorf("expected detect and investigate covered, 70 of 120: %+v", result)
+	}
+
+	state := conversations.get(testEventName, "conversation-replayed", v2QuestionId)
+	if state.turns != 1 || len(state.messages) != 3 {
+		t.Errorf("expected the question, their message, and the reply remembered: %d turns, %d messages", state.turns, len(state.messages))
+	}
+}
+
+func TestConversationNeedsAnExecutionId(t *testing.T) {
+	request := conversationRequest("", "conversation-key", "hello")
+	delete(request.Headers, EXECUTION_ID_HEADER)
+	if status, _ := postTestConversation(t, request); status != 400 {
+		t.Errorf("expected 400 without an execution id, got %d", status)
+	}
+}
+
+func TestConversationNeedsAConversationQuestion(t *testing.T) {
+	request := conversationRequest("conversation-v1", "conversation-key", "hello")
+	request.RequestContext.HTTP.Path = "/api/questions/" + v1QuestionId + "/conversation"
+	if status, _ := postTestConversation(t, request); status != 404 {
+		t.Errorf("expected 404 for a question without objectives, got %d", status)
+	}
+}
+
+func TestConversationWithSomeoneElsesSession(t *testing.T) {
+	startTestSession(t, "conversation-owned", "owner-key")
+	if status, _ := postTestConversation(t, conversationRequest("conversation-owned", "other-key", "hello")); status != 403 {
+		t.Errorf("expected 403 with someone else's key, got %d", status)
+	}
+	if state := conversations.get(testEventName, "conversation-owned", v2QuestionId); state.turns != 0 {
+		t.Errorf("someone else's message took a turn")
+	}
+}
+
+func TestFlaggedMessageTakesATurnWithoutTheLlm(t *testing.T) {
+	status, result := postTestConversation(t, conversationRequest("conversation-flagged", "conversation-key", "this is shit"))
+	if status != 200 || result.Response != safeAnswerResponse || result.Turn != 1 || result.Score != 0 {
+		t.Errorf("expected the safe response, one turn, and no points: %d %+v", status, result)
+	}
+	if state := conversations.get(testEventName, "conversation-flagged", v2QuestionId); len(state.messages) != 0 {
+		t.Errorf("the flagged message went into the history: %+v", state.messages)
+	}
+}
+
+func TestFinishedConversation(t *testing.T) {
+	state := conversations.get(testEventName, "conversation-finished", v2QuestionId)
+	state.turns = 5
+	if status, _ := postTestConversation(t, conversationRequest("conversation-finished", "conversation-key", "one more thing")); status != 409 {
+		t.Errorf("expected 409 after the last turn, got %d", status)
+	}
+}
+
+func TestConversationsArePerEvent(t *testing.T) {
+	store := &conversationStore{conversations: map[string]*conversationState{}}
+	here := store.get("one event", "same-attendee", "q1")
+	there := store.get("another event", "same-attendee", "q1")
+	here.turns = 3
+	if there.turns != 0 || here == there {
+		t.Fatalf("the same execution id at another event is another conversation")
+	}
+
+	store.get("one event", "same-attendee", "q2")
+	store.forget("one event", "same-attendee")
+	if len(store.conversations) != 1 || store.get("another event", "same-attendee", "q1") != there {
+		t.Errorf("expected only the other event's conversation kept: %d", len(store.conversations))
+	}
+}
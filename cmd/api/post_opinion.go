@@ -110,6 +110,7 @@ func postOpinion(currentContext context.Context, request events.APIGatewayV2HTTP
 	})
 
 	postOpinionSpan.SetAttributes(attribute.Bool("app.reported", interactionReported.Reported), attribute.Bool("app.success", interactionReported.Success))
+	recordOpinion(currentContext, getEventName(request), getExecutionId(request), attendeeKeyHashOf(request), opinionReport.EvaluationId, opinionReport.Opinion)
 
 	/* tell the UI what we got */
 	result := PostOpinionResponse{EvaluationId: opinionReport.EvaluationId,
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Prizes, for booth staff (admin key required).
+ *
+ *    PUT  /api/admin/prizes              { "tiers": [ { "name": "t-shirt", "prize": "...", "minimum_score": 150, "winners": 0 }, ... ] }
+ *    GET  /api/admin/prizes              the tiers, and every drawing so far
+ *    POST /api/admin/prizes/draw         { "tier": "t-shirt", "seed": 1234 }
+ *    GET  /api/admin/prizes/draws/{id}   one drawing, to print it again
+ *
+ * Everyone whose total score reaches a tier's minimum_score qualifies for it, unless something (moderation, the guard,
+ * copy detection) flagged one of their answers. A tier with winners: 0 gives everyone who qualifies a prize;
+ * otherwise that many are drawn at random. Whoever already won this tier is left out, unless exclude_previous_winners is false;
+ * set exclude_other_tier_winners to leave out winners of the other tiers too.
+ *
+ * The draw is reproducible: the drawing records its seed (one is picked if you don't send one) and the sorted candidates,
+ * and the same seed and candidates always give the same winners. Drawings are kept in the attendee store.
+ */
+
+var putPrizeTiersEndpoint = apiEndpoint{
+	"PUT",
+	"/api/admin/prizes",
+	regexp.MustCompile("^/api/admin/prizes$"),
+	adminOnly(putPrizeTiers),
+	true,
+}
+
+var getPrizesEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/prizes",
+	regexp.MustCompile("^/api/admin/prizes$"),
+	adminOnly(getPrizes),
+	true,
+}
+
+var postPrizeDrawEndpoint = apiEndpoint{
+	"POST",
+	"/api/admin/prizes/draw",
+	regexp.MustCompile("^/api/admin/prizes/draw$"),
+	adminOnly(postPrizeDraw),
+	true,
+}
+
+var getPrizeDrawEndpoint = apiEndpoint{
+	"GET",
+	"/api/admin/prizes/draws/{id}",
+	regexp.MustCompile("^/api/admin/prizes/draws/[^/]+$"),
+	adminOnly(getPrizeDraw),
+	true,
+}
+
+type PutPrizeTiersBody struct {
+	Tiers []store.PrizeTier `json:"tiers"`
+}
+
+type PrizesResponse struct {
+	EventName string            `json:"event_name"`
+	Tiers     []store.PrizeTier `json:"tiers"`
+	Drawings  []store.Drawing   `json:"drawings"`
+}
+
+type PostPrizeDrawBody struct {
+	Tier                    string `json:"tier"`
+	Seed                    *int64 `json:"seed"`                     // optional. One is picked, and recorded, if not
+	ExcludePreviousWinners  *bool  `json:"exclude_previous_winners"` // of this tier. Default true
+	ExcludeOtherTierWinners bool   `json:"exclude_other_tier_winners"`
+}
+
+func putPrizeTiers(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	body := PutPrizeTiersBody{}
+	err := json.Unmarshal([]byte(request.Body), &body)
+	if err != nil {
+		span.RecordError(fmt.Errorf("error unmarshalling prize tiers: %w\n request body: %s", err, request.Body))
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'tiers': [ { 'name': 't-shirt', 'prize': '...', 'minimum_score': 150, 'winners': 0 } ] }", 400), nil
+	}
+	if problem := checkPrizeTiers(body.Tiers); problem != "" {
+		return instrumentation.ErrorResponse(problem, 400), nil
+	}
+
+	eventName := getEventName(request)
+	tiers := store.PrizeTiers{EventName: eventName, Tiers: body.Tiers, SetAt: time.Now().UTC()}
+	err = attendeeStore.SavePrizeTiers(currentContext, tiers)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't save the prize tiers", 500), nil
+	}
+	span.SetAttributes(attribute.String("app.prizes.event_name", eventName), attribute.Int("app.prizes.tiers_qty", len(tiers.Tiers)))
+	return prizesJsonResponse(currentContext, tiers, 200)
+}
+
+func checkPrizeTiers(tiers []store.PrizeTier) string {
+	if len(tiers) == 0 {
+		return "At least one tier, please"
+	}
+	names := map[string]bool{}
+	for _, tier := range tiers {
+		name := strings.TrimSpace(tier.Name)
+		if name == "" {
+			return "Every tier needs a name"
+		}
+		if names[name] {
+			return fmt.Sprintf("There are two tiers called %q", name)
+		}
+		names[name] = true
+		if tier.MinimumScore < 0 || tier.Winners < 0 {
+			return fmt.Sprintf("Tier %q: minimum_score and winners can't be negative", name)
+		}
+	}
+	return ""
+}
+
+func getPrizes(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	eventName := getEventName(request)
+	tiers, _, err := attendeeStore.PrizeTiers(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the prize tiers", 500), nil
+	}
+	drawings, err := attendeeStore.Drawings(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the drawings", 500), nil
+	}
+	response := PrizesResponse{EventName: eventName, Tiers: tiers.Tiers, Drawings: drawings}
+	if response.Tiers == nil {
+		response.Tiers = []store.PrizeTier{}
+	}
+	return prizesJsonResponse(currentContext, response, 200)
+}
+
+func postPrizeDraw(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	currentContext, span := tracer.Start(currentContext, "draw prize winners")
+	defer span.End()
+
+	body := PostPrizeDrawBody{}
+	err := json.Unmarshal([]byte(request.Body), &body)
+	if err != nil {
+		span.RecordError(fmt.Errorf("error unmarshalling prize draw: %w\n request body: %s", err, request.Body))
+		return instrumentation.ErrorResponse("Bad request. Expected format: { 'tier': 't-shirt', 'seed': 1234 }", 400), nil
+	}
+
+	eventName := getEventName(request)
+	tiers, _, err := attendeeStore.PrizeTiers(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the prize tiers", 500), nil
+	}
+	tier, found := findPrizeTier(tiers.Tiers, body.Tier)
+	if !found {
+		return instrumentation.ErrorResponse(fmt.Sprintf("No prize tier called %q in %s. PUT /api/admin/prizes to set them", body.Tier, eventName), 404), nil
+	}
+
+	sessions, err := attendeeStore.Sessions(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the attendee store", 500), nil
+	}
+	drawings, err := attendeeStore.Drawings(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the drawings", 500), nil
+	}
+	previousWinners := map[string]bool{}
+	for _, drawing := range drawings {
+		sameTier := drawing.Tier.Name == tier.Name
+		if (sameTier && (body.ExcludePreviousWinners == nil || *body.ExcludePreviousWinners)) || (!sameTier && body.ExcludeOtherTierWinners) {
+			for _, winner := range drawing.Winners {
+				previousWinners[winner.ExecutionId] = true
+			}
+		}
+	}
+	seed := time.Now().UnixNano()
+	if body.Seed != nil {
+		seed = *body.Seed
+	}
+
+	drawing := drawPrize(sessions, tier, seed, previousWinners)
+	drawing.Id = uuid.NewString()
+	drawing.EventName = eventName
+	drawing.DrawnAt = time.Now().UTC()
+	span.SetAttributes(attribute.String("app.prizes.event_name", eventName),
+		attribute.String("app.prizes.tier", tier.Name),
+		attribute.Int64("app.prizes.seed", seed),
+		attribute.Int("app.prizes.candidates_qty", len(drawing.CandidateIds)),
+		attribute.Int("app.prizes.excluded_flagged_qty", drawing.ExcludedFlaggedQty),
+		attribute.Int("app.prizes.excluded_winner_qty", drawing.ExcludedWinnerQty),
+		attribute.Int("app.prizes.winners_qty", len(drawing.Winners)))
+
+	err = attendeeStore.SaveDrawing(currentContext, drawing)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't record the drawing, so it doesn't count. Try again", 500), nil
+	}
+	return prizesJsonResponse(currentContext, drawing, 201)
+}
+
+func findPrizeTier(tiers []store.PrizeTier, name string) (store.PrizeTier, bool) {
+	for _, tier := range tiers {
+		if strings.TrimSpace(tier.Name) == strings.TrimSpace(name) {
+			return tier, true
+		}
+	}
+	return store.PrizeTier{}, false
+}
+
+// drawPrize picks the winners. Only the seed makes it random, so the same sessions give the same drawing.
+func drawPrize(sessions []store.Session, tier store.PrizeTier, seed int64, previousWinners map[string]bool) store.Drawing {
+	drawing := store.Drawing{Tier: tier, Seed: seed, CandidateIds: []string{}, Winners: []store.PrizeWinner{}}
+	candidates := map[string]store.PrizeWinner{}
+	for _, session := range sessions {
+		if session.ExecutionId == "unset" {
+			continue // everyone without an execution id, not someone we could hand a prize to
+		}
+		score, _ := session.TotalScore()
+		if len(session.Answers) == 0 || score < tier.MinimumScore {
+			continue
+		}
+		if session.Flagged() {
+			drawing.ExcludedFlaggedQty++
+			continue
+		}
+		if previousWinners[session.ExecutionId] {
+			drawing.ExcludedWinnerQty++
+			continue
+		}
+		candidates[session.ExecutionId] = store.PrizeWinner{ExecutionId: session.ExecutionId, Nickname: session.Nickname, Score: score}
+		drawing.CandidateIds = append(drawing.CandidateIds, session.ExecutionId)
+	}
+	sort.Strings(drawing.CandidateIds) // whatever order the store had them in, the draw starts from the same place
+
+	winnerQty := tier.Winners
+	if winnerQty == 0 || winnerQty > len(drawing.CandidateIds) {
+		winnerQty = len(drawing.CandidateIds)
+	}
+	order := rand.New(rand.NewSource(seed)).Perm(len(drawing.CandidateIds))
+	for _, i := range order[:winnerQty] {
+		drawing.Winners = append(drawing.Winners, candidates[drawing.CandidateIds[i]])
+	}
+	return drawing
+}
+
+func getPrizeDraw(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+	eventName := getEventName(request)
+	drawingId := strings.Split(request.RequestContext.HTTP.Path, "/")[5]
+	drawings, err := attendeeStore.Drawings(currentContext, eventName)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't read the drawings", 500), nil
+	}
+	for _, drawing := range drawings {
+		if drawing.Id == drawingId {
+			return prizesJsonResponse(currentContext, drawing, 200)
+		}
+	}
+	return instrumentation.ErrorResponse("Couldn't find a drawing with that ID in this event", 404), nil
+}
+
+func prizesJsonResponse(currentContext context.Context, body interface{}, statusCode int) (events.APIGatewayV2HTTPResponse, error) {
+	prizesJson, err := json.Marshal(body)
+	if err != nil {
+		trace.SpanFromContext(currentContext).RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(prizesJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: statusCode}, nil
+}
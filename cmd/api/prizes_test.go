@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"observaquiz_lambda/cmd/api/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func prizeSession(executionId string, score int, flags ...string) store.Session {
+	return store.Session{ExecutionId: executionId, Nickname: executionId, Answers: []store.Answer{
+		{QuestionId: "q1", Score: score, PossibleScore: 100, Flags: flags, AnsweredAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+	}}
+}
+
+func prizeSessions() []store.Session {
+	sessions := []store.Session{}
+	for i := 0; i < 10; i++ {
+		sessions = append(sessions, prizeSession(fmt.Sprintf("attendee-%d", i), 50+i))
+	}
+	return append(sessions, prizeSession("unset", 100), prizeSession("flagged", 100, ANSWER_FLAG_MODERATION), prizeSession("too-low", 10))
+}
+
+func winnerIds(drawing store.Drawing) []string {
+	ids := []string{}
+	for _, winner := range drawing.Winners {
+		ids = append(ids, winner.ExecutionId)
+	}
+	return ids
+}
+
+func TestTheSameSeedDrawsTheSameWinners(t *testing.T) {
+	tier := store.PrizeTier{Name: "t-shirt", MinimumScore: 40, Winners: 3}
+	sessions := prizeSessions()
+	first := drawPrize(sessions, tier, 1234, map[string]bool{})
+
+	// the store can give them back in any order
+	reversed := append([]store.Session{}, sessions...)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	again := drawPrize(reversed, tier, 1234, map[string]bool{})
+
+	if len(first.Winners) != 3 || !reflect.DeepEqual(winnerIds(first), winnerIds(again)) {
+		t.Errorf("expected the same 3 winners from the same seed, got %v and %v", winnerIds(first), winnerIds(again))
+	}
+}
+
+func TestWhoQualifiesForAPrize(t *testing.T) {
+	drawing := drawPrize(prizeSessions(), store.PrizeTier{Name: "sticker", MinimumScore: 40}, 1, map[string]bool{"attendee-3": true})
+
+	if len(drawing.CandidateIds) != 9 {
+		t.Errorf("expected the 9 attendees who qualify and haven't won, got %v", drawing.CandidateIds)
+	}
+	for _, id := range drawing.CandidateIds {
+		if id == "unset" || id == "flagged" || id == "too-low" || id == "attendee-3" {
+			t.Errorf("%s shouldn't be a candidate", id)
+		}
+	}
+	if drawing.ExcludedFlaggedQty != 1 || drawing.ExcludedWinnerQty != 1 {
+		t.Errorf("expected one excluded for a flag and one for winning before, got %d and %d", drawing.ExcludedFlaggedQty, drawing.ExcludedWinnerQty)
+	}
+	if len(drawing.Winners) != 9 {
+		t.Errorf("winners: 0 means everyone who qualifies, got %d", len(drawing.Winners))
+	}
+}
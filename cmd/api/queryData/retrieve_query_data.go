@@ -54,13 +54,18 @@ func errorQueryDataResponse(err error) (QueryDataResponse, error) {
 	return QueryDataResponse{Error: err.Error()}, err
 }
 
+// HashAttendeeApiKey is what we record instead of their API key: in app.honeycomb_api_key, and on their session.
+func HashAttendeeApiKey(attendeeApiKey string) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(attendeeApiKey))
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
 func CreateAndRunHoneycombQuery(currentContext context.Context, queryDataApiKey string, request QueryDataRequest) (response QueryDataResponse, err error) {
 	// 0. Construct the query
 	queryDefinition := request.QueryDefinition
 
-	hasher := sha256.New()
-	hasher.Write([]byte(request.AttendeeApiKey))
-	expectedValueOfApiKey := fmt.Sprintf("%x", hasher.Sum(nil))
+	expectedValueOfApiKey := HashAttendeeApiKey(request.AttendeeApiKey)
 	oteltrace.SpanFromContext(currentContext).SetAttributes(attribute.String("observaquiz.hashed_honeycomb_api_key", expectedValueOfApiKey))
 
 	newFilter := Filter{
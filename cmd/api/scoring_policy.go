@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * v1 questions are out of 100. v2 questions are out of whatever their scoring prompts and pointy words add up to.
+ * That makes a leaderboard unfair: one question can be worth three times another.
+ *
+ * An event can say how many points every question is worth, in questions/<event>/event.json:
+ *
+ *    { "scoring": { "points_per_question": 100, "rounding": "nearest", "minimum_participation_score": 5 } }
+ *
+ * Then each score is scaled to that, and rounded (nearest, down, or up).
+ * Anyone who answers, and isn't caught by moderation or the guard, gets at least minimum_participation_score.
+ * Without event.json, scores stay as they are.
+ */
+
+const (
+	ROUNDING_NEAREST = "nearest"
+	ROUNDING_DOWN    = "down"
+	ROUNDING_UP      = "up"
+)
+
+type EventConfig struct {
+	Scoring       ScoringPolicy       `json:"scoring"`
+	CopyDetection CopyDetectionConfig `json:"copy_detection"`
+	Report        ReportConfig        `json:"report"`
+}
+
+type ScoringPolicy struct {
+	PointsPerQuestion         int    `json:"points_per_question"` // 0 to leave scores as they are
+	Rounding                  string `json:"rounding"`            // nearest (default), down, or up
+	MinimumParticipationScore int    `json:"minimum_participation_score"`
+}
+
+var eventConfigs = parseEventConfigs()
+
+func parseEventConfigs() map[string]EventConfig {
+	configs := map[string]EventConfig{}
+	for _, v := range eventsWithQuestions {
+		if !v.IsDir() {
+			continue
+		}
+		configFile, err := eventDirectories.ReadFile(fmt.Sprintf("questions/%v/event.json", v.Name()))
+		if err != nil {
+			continue // it's optional
+		}
+		config := EventConfig{}
+		err = json.Unmarshal(configFile, &config)
+		if err != nil {
+			fmt.Printf("Error unmarshalling event config for %v: %v\n", v.Name(), err)
+			continue
+		}
+		configs[v.Name()] = config
+	}
+	return configs
+}
+
+func scoringPolicyFor(eventName string) ScoringPolicy {
+	return eventConfigs[eventName].Scoring
+}
+
+func clampScore(score int, possibleScore int) int {
+	if score < 0 {
+		return 0
+	}
+	if score > possibleScore {
+		return possibleScore
+	}
+	return score
+}
+
+func (policy ScoringPolicy) round(value float64) int {
+	switch policy.Rounding {
+	case ROUNDING_DOWN:
+		return int(math.Floor(value))
+	case ROUNDING_UP:
+		return int(math.Ceil(value))
+	default:
+		return int(math.Round(value))
+	}
+}
+
+// applyScoringPolicy clamps the score, scales it to the event's points per question, and gives participation points.
+// participated is false when moderation or the guard stopped the answer.
+func applyScoringPolicy(currentContext context.Context, policy ScoringPolicy, response *responseToAnswer, participated bool) {
+	span := trace.SpanFromContext(currentContext)
+	span.SetAttributes(attribute.Int("app.score.raw_score", response.score),
+		attribute.Int("app.score.raw_possible_score", response.possibleScore),
+		attribute.Int("app.score.policy.points_per_question", policy.PointsPerQuestion))
+
+	response.score = clampScore(response.score, response.possibleScore)
+
+	if policy.PointsPerQuestion > 0 && response.possibleScore > 0 && response.possibleScore != policy.PointsPerQuestion {
+		rawScore, rawPossibleScore := response.score, response.possibleScore
+		response.rawScore, response.rawPossibleScore = &rawScore, &rawPossibleScore
+		response.score = policy.round(float64(response.score) * float64(policy.PointsPerQuestion) / float64(response.possibleScore))
+		response.possibleScore = policy.PointsPerQuestion
+	} else if policy.PointsPerQuestion > 0 && response.possibleScore == 0 {
+		response.possibleScore = policy.PointsPerQuestion // nothing to scale, but it's still worth the same as the others
+	}
+
+	if participated && response.score < policy.MinimumParticipationScore {
+		span.SetAttributes(attribute.Bool("app.score.participation_minimum_applied", true))
+		response.score = clampScore(policy.MinimumParticipationScore, response.possibleScore)
+	}
+
+	span.SetAttributes(attribute.Int("app.score.normalized_score", response.score),
+		attribute.Int("app.score.normalized_possible_score", response.possibleScore))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// the test event scores by this policy until the test is over. The real events' event.json is for the people running them
+func withTestScoringPolicy(t *testing.T, policy ScoringPolicy) {
+	t.Helper()
+	usual, had := eventConfigs[testEventName]
+	config := usual
+	config.Scoring = policy
+	eventConfigs[testEventName] = config
+	t.Cleanup(func() {
+		if had {
+			eventConfigs[testEventName] = usual
+		} else {
+			delete(eventConfigs, testEventName)
+		}
+	})
+}
+
+func TestScoringPolicy(t *testing.T) {
+	tests := []struct {
+		description          string
+		policy               ScoringPolicy
+		score, possible      int
+		participated         bool
+		expected, expectedOf int
+	}{
+		{"no policy leaves it alone", ScoringPolicy{}, 7, 20, true, 7, 20},
+		{"no policy still clamps", ScoringPolicy{}, 25, 20, true, 20, 20},
+		{"negative is zero", ScoringPolicy{}, -3, 20, true, 0, 20},
+		{"scaled to the event's points", ScoringPolicy{PointsPerQuestion: 100}, 7, 20, true, 35, 100},
+		{"rounded to nearest", ScoringPolicy{PointsPerQuestion: 100}, 1, 3, true, 33, 100},
+		{"rounded down", ScoringPolicy{PointsPerQuestion: 100, Rounding: ROUNDING_DOWN}, 2, 3, true, 66, 100},
+		{"rounded up", ScoringPolicy{PointsPerQuestion: 100, Rounding: ROUNDING_UP}, 1, 3, true, 34, 100},
+		{"nothing to scale", ScoringPolicy{PointsPerQuestion: 100}, 0, 0, false, 0, 100},
+		{"participation points", ScoringPolicy{PointsPerQuestion: 100, MinimumParticipationScore: 5}, 0, 20, true, 5, 100},
+		{"no participation points for a moderated answer", ScoringPolicy{PointsPerQuestion: 100, MinimumParticipationScore: 5}, 0, 20, false, 0, 100},
+		{"participation points never past the maximum", ScoringPolicy{MinimumParticipationScore: 5}, 0, 3, true, 3, 3},
+	}
+	for _, test := range tests {
+		response := &responseToAnswer{score: test.score, possibleScore: test.possible}
+		applyScoringPolicy(context.Background(), test.policy, response, test.participated)
+		if response.score != test.expected || response.possibleScore != test.expectedOf {
+			t.Errorf("%s: expected %d of %d, got %d of %d", test.description, test.expected, test.expectedOf, response.score, response.possibleScore)
+		}
+	}
+}
+
+func TestScaledScoreKeepsTheRawScore(t *testing.T) {
+	response := &responseToAnswer{score: 7, possibleScore: 20}
+	applyScoringPolicy(context.Background(), ScoringPolicy{PointsPerQuestion: 100}, response, true)
+	if response.rawScore == nil || *response.rawScore != 7 || *response.rawPossibleScore != 20 {
+		t.Errorf("expected 7 of 20 kept alongside, got %+v", response)
+	}
+}
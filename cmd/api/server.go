@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"observaquiz_lambda/pkg/instrumentation"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdaurl"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * Run the API as a plain HTTP server instead of behind API Gateway.
+ * This is the only way to get streaming responses: either standalone (run_mode=server)
+ * or inside a Lambda with a Function URL in RESPONSE_STREAM mode (run_mode=lambda_streaming).
+ *
+ * Every request gets translated into the API Gateway shape, so the handlers don't know the difference.
+ */
+
+const (
+	RUN_MODE_LAMBDA           = "lambda"
+	RUN_MODE_LAMBDA_STREAMING = "lambda_streaming"
+	RUN_MODE_SERVER           = "server"
+	default_server_address    = ":8080"
+)
+
+type observaquizHttpHandler struct{}
+
+func (observaquizHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request, err := apiGatewayRequestFromHttp(r)
+	if err != nil {
+		oteltrace.SpanFromContext(r.Context()).RecordError(err)
+		writeHttpResponse(w, instrumentation.ErrorResponse("Could not read request body", 400))
+		return
+	}
+
+	if endpoint, found := api.findStreamingEndpoint(request.RequestContext.HTTP.Method, request.RequestContext.HTTP.Path); found {
+		streamWithSpan(r.Context(), endpoint, request, w)
+		return
+	}
+
+	response, _ := RouterWithSpan(r.Context(), request)
+	writeHttpResponse(w, response)
+}
+
+func apiGatewayRequestFromHttp(r *http.Request) (events.APIGatewayV2HTTPRequest, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return events.APIGatewayV2HTTPRequest{}, err
+	}
+
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",") // API Gateway lowercases them too
+	}
+	queryParameters := map[string]string{}
+	for k, v := range r.URL.Query() {
+		queryParameters[k] = strings.Join(v, ",")
+	}
+
+	sourceIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if functionUrlRequest, ok := lambdaurl.RequestFromContext(r.Context()); ok {
+		sourceIP = functionUrlRequest.RequestContext.HTTP.SourceIP
+	}
+
+	return events.APIGatewayV2HTTPRequest{
+		RawPath:               r.URL.Path,
+		RawQueryString:        r.URL.RawQuery,
+		Headers:               headers,
+		QueryStringParameters: queryParameters,
+		Body:                  string(body),
+		RequestContext: events.APIGatewayV2HTTPRequestContext{
+			DomainName: r.Host,
+			HTTP: events.APIGatewayV2HTTPRequestContextHTTPDescription{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Protocol:  r.Proto,
+				SourceIP:  sourceIP,
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}, nil
+}
+
+func writeHttpResponse(w http.ResponseWriter, response events.APIGatewayV2HTTPResponse) {
+	for k, v := range response.Headers {
+		w.Header().Set(k, v)
+	}
+	statusCode := response.StatusCode
+	if statusCode == 0 {
+		statusCode = 200
+	}
+	w.WriteHeader(statusCode)
+	io.WriteString(w, response.Body)
+}
+
+// the streaming equivalent of RouterWithSpan
+func streamWithSpan(currentContext context.Context, endpoint streamingEndpoint, request events.APIGatewayV2HTTPRequest, w http.ResponseWriter) {
+	currentContext, cleanup := context.WithTimeout(currentContext, 30*time.Second)
+	defer cleanup()
+	span := oteltrace.SpanFromContext(currentContext)
+	span.SetName(fmt.Sprintf("%s %s", endpoint.method, endpoint.pathTemplate))
+
+	currentContext, _ = setAttributesOnSpanAndBaggageFromHeaders(currentContext, request)
+	instrumentation.AddHttpRequestAttributesToSpan(span, request)
+	housekeeping(currentContext)
+
+	if endpoint.requiresEvent {
+		eventName := getEventName(request)
+		if _, eventFound := eventQuestions[eventName]; !eventFound {
+			writeHttpResponse(w, instrumentation.ErrorResponse(fmt.Sprintf("Couldn't find event name %s", eventName), 404))
+			return
+		}
+	}
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(currentContext, carrier)
+	w.Header().Set("x-tracechild", carrier["traceparent"])
+	for k, v := range sseHeaders() {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(200)
+
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+	stream := newSseWriter(w, flush)
+	defer func() {
+		if r := recover(); r != nil {
+			instrumentation.RespondToPanic(span, r)
+			stream.sendError(fmt.Sprintf("Panic caught: %v", r), 500)
+		}
+	}()
+
+	endpoint.handler(currentContext, request, stream)
+}
+
+func runStandaloneServer(address string) {
+	if address == "" {
+		address = default_server_address
+	}
+	fmt.Printf("Observaquiz API listening on %s\n", address)
+	err := http.ListenAndServe(address, otelhttp.NewHandler(observaquizHttpHandler{}, "observaquiz"))
+	if err != nil {
+		fmt.Printf("Server stopped: %v\n", err)
+	}
+}
+
+// Lambda Function URLs with InvokeMode RESPONSE_STREAM. API Gateway can't do this.
+func runLambdaResponseStreaming(tracerProvider *sdktrace.TracerProvider) {
+	handler := otelhttp.NewHandler(observaquizHttpHandler{}, "observaquiz")
+	lambdaurl.Start(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// there's no otellambda flusher here, and the Lambda freezes as soon as the stream ends
+		defer tracerProvider.ForceFlush(context.Background())
+		handler.ServeHTTP(w, r)
+	}))
+}
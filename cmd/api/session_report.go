@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"observaquiz_lambda/cmd/api/costs"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * At the end, a report to take home: where their observability is, what they're doing well, and what to try next.
+ *
+ *    GET /api/sessions/{id}/report                   JSON, with the Markdown in it
+ *    GET /api/sessions/{id}/report?format=markdown   just the Markdown (so does Accept: text/markdown)
+ *
+ * One LLM call reads all their latest answers, with the category and response each one got, and writes it.
+ * The prompt is the event's, in event.json: { "report": { "prompt": "..." } }, or defaultReportPrompt. It can use
+ * ANSWERS (every question, their answer, its category and our response), STAGES (the categories the event's questions use),
+ * and SCORE.
+ *
+ * The report is kept (per Lambda instance) until they answer again; then the next request writes a new one.
+ * Asking again while it's being written waits for that one, rather than asking the LLM twice. Writing it gets reportTimeout.
+ * Anything moderation caught, theirs or ours, stays out of the prompt.
+ * Same access rules as the summary: an issued session needs the API key it was issued to.
+ */
+
+const (
+	REPORT_FORMAT_JSON     = "json"
+	REPORT_FORMAT_MARKDOWN = "markdown"
+
+	maximumCachedReports = 1000
+	reportTimeout        = 20 * time.Second // of the request's 30
+)
+
+const defaultReportPrompt = `You are Jessitron, an evangelist for great observability. You speak in a casual, informal tone.
+Someone at a conference booth just answered all our questions about how they observe their software. Write them a short report to take home.
+
+The stages of observability maturity are: STAGES
+
+Here is what they said, what category each answer got, and what we told them:
+
+ANSWERS
+
+Their score was SCORE.
+
+Decide which stage fits them best, looking at all their answers together. Then write:
+- summary: two or three sentences about where they are, in their terms, mentioning what they told us
+- strengths: up to three things they're already doing well
+- next_steps: two to four specific things to try next, to move toward Observability 2.0. Make each one something they could start next week.
+
+Format your answer in JSON:
+{ "maturity": "one of the stages", "summary": "string", "strengths": ["string"], "next_steps": ["string"] }
+`
+
+var getSessionReportEndpoint = apiEndpoint{
+	"GET",
+	"/api/sessions/{id}/report",
+	regexp.MustCompile("^/api/sessions/[^/]+/report$"),
+	getSessionReport,
+	true,
+}
+
+type ReportConfig struct {
+	Prompt string `json:"prompt"` // empty for defaultReportPrompt
+}
+
+func (config ReportConfig) prompt() string {
+	if config.Prompt == "" {
+		return defaultReportPrompt
+	}
+	return config.Prompt
+}
+
+type SessionReport struct {
+	ExecutionId   string           `json:"execution_id"`
+	EventName     string           `json:"event_name"`
+	Nickname      string           `json:"nickname,omitempty"`
+	Maturity      string           `json:"maturity"`
+	Summary       string           `json:"summary"`
+	Strengths     []string         `json:"strengths"`
+	NextSteps     []string         `json:"next_steps"`
+	Score         int              `json:"score"`
+	PossibleScore int              `json:"possible_score"`
+	Questions     []ReportQuestion `json:"questions"` // the ones they answered
+	Complete      bool             `json:"complete"`  // they answered every question in the event
+	AnsweredUntil time.Time        `json:"answered_until"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+	EvaluationId  string           `json:"evaluation_id,omitempty"`
+	Markdown      string           `json:"markdown"`
+}
+
+type ReportQuestion struct {
+	QuestionId    string `json:"question_id"`
+	Question      string `json:"question"`
+	Category      string `json:"category,omitempty"`
+	Score         int    `json:"score"`
+	PossibleScore int    `json:"possible_score"`
+}
+
+type reportLlmResult struct {
+	Maturity  string   `json:"maturity"`
+	Summary   string   `json:"summary"`
+	Strengths []string `json:"strengths"`
+	NextSteps []string `json:"next_steps"`
+}
+
+func getSessionReport(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	session, errorResponse := findSession(currentContext, request)
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+	latest := session.LatestAnswers()
+	if len(latest) == 0 {
+		return instrumentation.ErrorResponse("Answer a question first; then there's something to report on", 409), nil
+	}
+
+	report, errorResponse := sessionReports.report(currentContext, session.EventName, session.ExecutionId, reportFingerprint(latest), func() (*SessionReport, *errorResponseType) {
+		writeContext, cancel := context.WithTimeout(currentContext, reportTimeout)
+		defer cancel()
+		writeContext = costs.WithScope(writeContext, costs.Scope{EventName: session.EventName, ExecutionId: session.ExecutionId, AttendeeKeyHash: session.AttendeeKeyHash})
+		return generateSessionReport(writeContext, session, latest)
+	})
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+
+	if reportFormat(request) == REPORT_FORMAT_MARKDOWN {
+		return events.APIGatewayV2HTTPResponse{
+			Body:       report.Markdown,
+			Headers:    map[string]string{"Content-Type": "text/markdown; charset=utf-8"},
+			StatusCode: 200}, nil
+	}
+	return sessionJsonResponse(currentContext, report)
+}
+
+func reportFormat(request events.APIGatewayV2HTTPRequest) string {
+	if format := request.QueryStringParameters["format"]; format != "" {
+		return format
+	}
+	if strings.Contains(getHeader(request, "accept"), "text/markdown") {
+		return REPORT_FORMAT_MARKDOWN
+	}
+	return REPORT_FORMAT_JSON
+}
+
+// changes whenever their latest answers do
+func reportFingerprint(latest []store.Answer) string {
+	hasher := sha256.New()
+	for _, answer := range latest {
+		fmt.Fprintf(hasher, "%s\x00%s\x00%s\x00%d\x00", answer.QuestionId, answer.EvaluationId, answer.AnsweredAt.Format(time.RFC3339Nano), answer.Score)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+func generateSessionReport(currentContext context.Context, session store.Session, latest []store.Answer) (*SessionReport, *errorResponseType) {
+	currentContext, span := tracer.Start(currentContext, "write session report")
+	defer span.End()
+	report := &SessionReport{
+		ExecutionId: session.ExecutionId,
+		EventName:   session.EventName,
+		Nickname:    session.Nickname,
+		Strengths:   []string{},
+		NextSteps:   []string{},
+		Questions:   []ReportQuestion{},
+		GeneratedAt: time.Now().UTC(),
+	}
+	report.Score, report.PossibleScore = session.TotalScore()
+	questions := map[string]Question{}
+	for _, question := range eventQuestions[session.EventName] {
+		questions[question.Id.String()] = question
+	}
+	described := strings.Builder{}
+	for _, answer := range latest {
+		question := questions[answer.QuestionId]
+		report.Questions = append(report.Questions, ReportQuestion{
+			QuestionId:    answer.QuestionId,
+			Question:      question.Question,
+			Category:      answer.Category,
+			Score:         answer.Score,
+			PossibleScore: answer.PossibleScore,
+		})
+		if answer.AnsweredAt.After(report.AnsweredUntil) {
+			report.AnsweredUntil = answer.AnsweredAt
+		}
+		theirAnswer := answer.Answer
+		if answerFlagged(answer, ANSWER_FLAG_MODERATION) {
+			theirAnswer = "(removed by moderation)"
+		}
+		fmt.Fprintf(&described, "Question: %s\nTheir answer: ```\n%s\n```\n", question.Question, theirAnswer)
+		if answer.Category != "" {
+			fmt.Fprintf(&described, "Category: %s\n", answer.Category)
+		}
+		if ourResponse, ok := reportableResponse(answer); ok {
+			fmt.Fprintf(&described, "We said: %s\n", ourResponse)
+		}
+		described.WriteString("\n")
+	}
+	report.Complete = len(latest) >= len(eventQuestions[session.EventName])
+	stages := maturityStages(session.EventName)
+	span.SetAttributes(attribute.Int("app.report.answers_qty", len(latest)),
+		attribute.Bool("app.report.complete", report.Complete),
+		attribute.StringSlice("app.report.stages", stages))
+
+	llmApi := newOpenaiApi("GPT3Dot5Turbo1106", settings.OpenAIKey)
+	output := chatResult{}
+	result := reportLlmResult{}
+	err := chatForValidJson(currentContext, llmApi, chatRequest{
+		theirAnswer:    described.String(),
+		promptTemplate: eventConfigs[session.EventName].Report.prompt(),
+		replacements: map[string]string{
+			"ANSWERS": described.String(),
+			"STAGES":  strings.Join(stages, ", "),
+			"SCORE":   fmt.Sprintf("%d out of %d", report.Score, report.PossibleScore),
+		},
+	}, jsonSchema{
+		{name: "maturity", fieldType: jsonString, required: true, allowed: stages},
+		{name: "summary", fieldType: jsonString, required: true},
+		{name: "strengths", fieldType: jsonStrings},
+		{name: "next_steps", fieldType: jsonStrings, required: true},
+	}, &output, &result)
+	if errors.Is(err, errOverBudget) {
+		return nil, &errorResponseType{message: "No more reports today, sorry. Your summary is still at /summary", statusCode: 503}
+	}
+	if errors.Is(err, errLlmUnreachable) {
+		return nil, &errorResponseType{message: "Could not reach LLM. Try again in a bit", statusCode: 503}
+	}
+	if errors.Is(currentContext.Err(), context.DeadlineExceeded) {
+		span.RecordError(currentContext.Err())
+		return nil, &errorResponseType{message: "Writing the report took too long. Try again in a bit", statusCode: 504}
+	}
+	if err != nil {
+		span.RecordError(err)
+		return nil, &errorResponseType{message: "Could not write the report", statusCode: 500}
+	}
+
+	written := strings.Join(append(append([]string{result.Summary}, result.Strengths...), result.NextSteps...), "\n")
+	if moderation := moderateText(currentContext, "response", written); moderation.flagged {
+		return nil, &errorResponseType{message: "Could not write the report this time. Try again", statusCode: 500}
+	}
+	report.Maturity, report.Summary, report.EvaluationId = result.Maturity, result.Summary, output.evaluationId
+	if result.Strengths != nil {
+		report.Strengths = result.Strengths
+	}
+	if result.NextSteps != nil {
+		report.NextSteps = result.NextSteps
+	}
+	report.Markdown = renderReportMarkdown(*report)
+	span.SetAttributes(attribute.String("app.report.maturity", report.Maturity),
+		attribute.Int("app.report.next_steps_qty", len(report.NextSteps)))
+	return report, nil
+}
+
+// every category the event's questions can give, in the order they list them. Empty means the LLM can say anything
+func maturityStages(eventName string) []string {
+	stages := []string{}
+	seen := map[string]bool{}
+	for _, question := range eventQuestions[eventName] {
+		for _, category := range question.PromptsV2.Categories {
+			if !seen[category] {
+				seen[category] = true
+				stages = append(stages, category)
+			}
+		}
+	}
+	return stages
+}
+
+func answerFlagged(answer store.Answer, flag string) bool {
+	for _, f := range answer.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// what we told them, unless moderation caught their answer (then it's a stand-in about that) or our response (then it's the stand-in)
+func reportableResponse(answer store.Answer) (string, bool) {
+	if answerFlagged(answer, ANSWER_FLAG_MODERATION) || answer.Response == safeLlmResponse {
+		return "", false
+	}
+	return answer.Response, true
+}
+
+func renderReportMarkdown(report SessionReport) string {
+	markdown := strings.Builder{}
+	title := "Your observability report"
+	if report.Nickname != "" {
+		title = fmt.Sprintf("%s's observability report", report.Nickname)
+	}
+	fmt.Fprintf(&markdown, "# %s\n\n", title)
+	fmt.Fprintf(&markdown, "**Where you are:** %s\n\n%s\n\n", report.Maturity, report.Summary)
+	if len(report.Strengths) > 0 {
+		markdown.WriteString("## What you're doing well\n\n")
+		for _, strength := range report.Strengths {
+			fmt.Fprintf(&markdown, "- %s\n", strength)
+		}
+		markdown.WriteString("\n")
+	}
+	markdown.WriteString("## Next steps\n\n")
+	for i, step := range report.NextSteps {
+		fmt.Fprintf(&markdown, "%d. %s\n", i+1, step)
+	}
+	markdown.WriteString("\n## Your answers\n\n| Question | Category | Score |\n| --- | --- | --- |\n")
+	for _, question := range report.Questions {
+		fmt.Fprintf(&markdown, "| %s | %s | %d / %d |\n", markdownCell(question.Question), markdownCell(question.Category), question.Score, question.PossibleScore)
+	}
+	fmt.Fprintf(&markdown, "\n**Score:** %d out of %d\n", report.Score, report.PossibleScore)
+	return markdown.String()
+}
+
+func markdownCell(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "|", "\\|"), "\n", " ")
+}
+
+/* the cache */
+
+// all of it belongs to the cache's lock
+type cachedReport struct {
+	fingerprint string
+	report      *SessionReport
+	writing     *reportWriting // nil unless a request is writing one
+	lastUsed    time.Time
+}
+
+type reportWriting struct {
+	fingerprint   string
+	done          chan struct{} // closed when it's written, or failed
+	report        *SessionReport
+	errorResponse *errorResponseType
+}
+
+type reportCache struct {
+	lock    sync.Mutex
+	reports map[string]*cachedReport
+}
+
+var sessionReports = &reportCache{reports: map[string]*cachedReport{}}
+
+// report is the one kept for these answers, or the one another request is writing for them, or else what write writes.
+// Nothing is locked while the LLM writes it.
+func (cache *reportCache) report(currentContext context.Context, eventName string, executionId string, fingerprint string, write func() (*SessionReport, *errorResponseType)) (*SessionReport, *errorResponseType) {
+	span := trace.SpanFromContext(currentContext)
+	key := eventName + "\x00" + executionId
+	cache.lock.Lock()
+	entry := cache.entry(key)
+	if entry.report != nil && entry.fingerprint == fingerprint {
+		report := entry.report
+		cache.lock.Unlock()
+		span.SetAttributes(attribute.Bool("app.report.cached", true))
+		return report, nil
+	}
+	span.SetAttributes(attribute.Bool("app.report.cached", false))
+	if writing := entry.writing; writing != nil && writing.fingerprint == fingerprint {
+		cache.lock.Unlock()
+		span.SetAttributes(attribute.Bool("app.report.waited", true))
+		select {
+		case <-writing.done:
+			return writing.report, writing.errorResponse
+		case <-currentContext.Done():
+			return nil, &errorResponseType{message: "Gave up waiting for your report. Try again in a bit", statusCode: 504}
+		}
+	}
+	writing := &reportWriting{fingerprint: fingerprint, done: make(chan struct{})}
+	entry.writing = writing
+	cache.lock.Unlock()
+
+	// if write panics, anyone waiting still needs to hear about it
+	writing.errorResponse = &errorResponseType{message: "Could not write the report", statusCode: 500}
+	defer func() {
+		cache.lock.Lock()
+		// unless they answered again, or their data was deleted, while it was written
+		if entry.writing == writing && cache.reports[key] == entry {
+			entry.writing = nil
+			if writing.errorResponse == nil {
+				entry.report, entry.fingerprint = writing.report, fingerprint
+			}
+		}
+		cache.lock.Unlock()
+		close(writing.done)
+	}()
+	writing.report, writing.errorResponse = write()
+	return writing.report, writing.errorResponse
+}
+
+// expects the lock to be held
+func (cache *reportCache) entry(key string) *cachedReport {
+	entry, ok := cache.reports[key]
+	if !ok {
+		if len(cache.reports) >= maximumCachedReports {
+			cache.evictOldest()
+		}
+		entry = &cachedReport{}
+		cache.reports[key] = entry
+	}
+	entry.lastUsed = time.Now()
+	return entry
+}
+
+// expects the lock to be held
+func (cache *reportCache) evictOldest() {
+	oldestKey, oldest := "", time.Time{}
+	for key, entry := range cache.reports {
+		if oldestKey == "" || entry.lastUsed.Before(oldest) {
+			oldestKey, oldest = key, entry.lastUsed
+		}
+	}
+	delete(cache.reports, oldestKey)
+}
+
+// when an attendee's data is deleted
+func (cache *reportCache) forget(eventName string, executionId string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	delete(cache.reports, eventName+"\x00"+executionId)
+}
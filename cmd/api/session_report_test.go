@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"observaquiz_lambda/cmd/api/store"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReportRequestsShareOneWrite(t *testing.T) {
+	cache := &reportCache{reports: map[string]*cachedReport{}}
+	var writes atomic.Int32
+	release := make(chan struct{})
+	write := func() (*SessionReport, *errorResponseType) {
+		writes.Add(1)
+		<-release
+		return &SessionReport{Summary: "written once"}, nil
+	}
+
+	results := make(chan *SessionReport, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report, _ := cache.report(context.Background(), testEventName, "reported", "fingerprint", write)
+			results <- report
+		}()
+	}
+
+	// while it's being written, someone who can't wait doesn't have to
+	time.Sleep(50 * time.Millisecond)
+	impatient, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, errorResponse := cache.report(impatient, testEventName, "reported", "fingerprint", write); errorResponse == nil || errorResponse.statusCode != 504 {
+		t.Errorf("expected a cancelled request to stop waiting, got %+v", errorResponse)
+	}
+
+	close(release)
+	wg.Wait()
+	close(results)
+	for report := range results {
+		if report == nil || report.Summary != "written once" {
+			t.Errorf("expected everyone to get the one report, got %+v", report)
+		}
+	}
+	if writes.Load() != 1 {
+		t.Errorf("expected one write, got %d", writes.Load())
+	}
+	if report, _ := cache.report(context.Background(), testEventName, "reported", "fingerprint", write); report.Summary != "written once" || writes.Load() != 1 {
+		t.Errorf("expected it kept")
+	}
+}
+
+func TestReportWrittenForADeletedAttendeeIsNotKept(t *testing.T) {
+	cache := &reportCache{reports: map[string]*cachedReport{}}
+	cache.report(context.Background(), testEventName, "deleted-mid-report", "fingerprint", func() (*SessionReport, *errorResponseType) {
+		cache.forget(testEventName, "deleted-mid-report")
+		return &SessionReport{}, nil
+	})
+	if _, kept := cache.reports[testEventName+"\x00deleted-mid-report"]; kept {
+		t.Errorf("the report came back after they were forgotten")
+	}
+}
+
+func TestReportLeavesOutModeratedResponses(t *testing.T) {
+	if _, ok := reportableResponse(store.Answer{Response: safeAnswerResponse, Flags: []string{ANSWER_FLAG_MODERATION}}); ok {
+		t.Errorf("their answer was moderated; what we said about that isn't about their observability")
+	}
+	if _, ok := reportableResponse(store.Answer{Response: safeLlmResponse}); ok {
+		t.Errorf("our response was moderated; the stand-in shouldn't go to the LLM")
+	}
+	if response, ok := reportableResponse(store.Answer{Response: "Logs are a fine place to start.", Flags: []string{ANSWER_FLAG_GUARD}}); !ok || response != "Logs are a fine place to start." {
+		t.Errorf("expected the response, got %q", response)
+	}
+}
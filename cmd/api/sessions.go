@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"observaquiz_lambda/cmd/api/queryData"
+	"observaquiz_lambda/cmd/api/store"
+	"observaquiz_lambda/pkg/instrumentation"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * The execution id used to be whatever the client made up. Now the client can ask for one:
+ *
+ *    POST /api/sessions                  with x-honeycomb-api-key; gives back an execution id for this event
+ *    GET  /api/sessions/{id}             what they've answered so far, and what's left, to pick up where they left off
+ *    GET  /api/sessions/{id}/summary     the final score, the category for each question, and when they finished
+ *    GET  /api/sessions/{id}/report      what it all says about their observability, and what to try next (session_report.go)
+ *
+ * An issued id belongs to the event and to a hash of the attendee's API key. Looking at it, answering with it,
+ * or giving it a nickname takes the same API key. Send it as x-observaquiz-execution-id, the same as before.
+ * Ids the client made up still work for answering. They belong to the first API key they're answered with,
+ * or to nobody if there wasn't one; nobody can look those up here.
+ */
+
+var postSessionEndpoint = apiEndpoint{
+	"POST",
+	"/api/sessions",
+	regexp.MustCompile("^/api/sessions$"),
+	postSession,
+	true,
+}
+
+var getSessionEndpoint = apiEndpoint{
+	"GET",
+	"/api/sessions/{id}",
+	regexp.MustCompile("^/api/sessions/[^/]+$"),
+	getSession,
+	true,
+}
+
+var getSessionSummaryEndpoint = apiEndpoint{
+	"GET",
+	"/api/sessions/{id}/summary",
+	regexp.MustCompile("^/api/sessions/[^/]+/summary$"),
+	getSessionSummary,
+	true,
+}
+
+type PostSessionResponse struct {
+	ExecutionId string    `json:"execution_id"`
+	EventName   string    `json:"event_name"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+type SessionProgress struct {
+	ExecutionId          string             `json:"execution_id"`
+	EventName            string             `json:"event_name"`
+	Nickname             string             `json:"nickname,omitempty"`
+	StartedAt            time.Time          `json:"started_at"`
+	UpdatedAt            time.Time          `json:"updated_at"`
+	Score                int                `json:"score"`
+	PossibleScore        int                `json:"possible_score"`
+	Answered             []AnsweredQuestion `json:"answered"` // their latest answer to each question
+	RemainingQuestionIds []string           `json:"remaining_question_ids"`
+}
+
+type AnsweredQuestion struct {
+	QuestionId    string    `json:"question_id"`
+	Answer        string    `json:"answer"`
+	Response      string    `json:"response"`
+	Score         int       `json:"score"`
+	PossibleScore int       `json:"possible_score"`
+	Category      string    `json:"category,omitempty"`
+	EvaluationId  string    `json:"evaluation_id"`
+	AnsweredAt    time.Time `json:"answered_at"`
+}
+
+type SessionSummary struct {
+	ExecutionId   string            `json:"execution_id"`
+	EventName     string            `json:"event_name"`
+	Nickname      string            `json:"nickname,omitempty"`
+	Score         int               `json:"score"`
+	PossibleScore int               `json:"possible_score"`
+	Questions     []QuestionSummary `json:"questions"` // every question in the event, in order
+	Complete      bool              `json:"complete"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty"` // when they answered the last one
+}
+
+type QuestionSummary struct {
+	QuestionId    string `json:"question_id"`
+	Question      string `json:"question"`
+	Answered      bool   `json:"answered"`
+	Score         int    `json:"score"`
+	PossibleScore int    `json:"possible_score"`
+	Category      string `json:"category,omitempty"` // v2 only
+}
+
+func postSession(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	span := trace.SpanFromContext(currentContext)
+
+	attendeeApiKey := getHeader(request, ATTENDEE_API_KEY_HEADER)
+	if attendeeApiKey == "" {
+		return instrumentation.ErrorResponse(fmt.Sprintf("Send the %s header, so the session is yours", ATTENDEE_API_KEY_HEADER), 400), nil
+	}
+	start := store.SessionStart{
+		EventName:       getEventName(request),
+		ExecutionId:     uuid.NewString(),
+		AttendeeKeyHash: queryData.HashAttendeeApiKey(attendeeApiKey),
+		StartedAt:       time.Now().UTC(),
+	}
+	span.SetAttributes(attribute.String("app.session.event_name", start.EventName),
+		attribute.String("app.session.execution_id", start.ExecutionId))
+
+	err := attendeeStore.StartSession(currentContext, start)
+	if err != nil {
+		span.RecordError(err)
+		return instrumentation.ErrorResponse("Couldn't start a session", 500), nil
+	}
+
+	sessionJson, _ := json.Marshal(PostSessionResponse{ExecutionId: start.ExecutionId, EventName: start.EventName, StartedAt: start.StartedAt})
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(sessionJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 201}, nil
+}
+
+func getSession(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	session, errorResponse := findSession(currentContext, request)
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+
+	progress := SessionProgress{
+		ExecutionId:          session.ExecutionId,
+		EventName:            session.EventName,
+		Nickname:             session.Nickname,
+		StartedAt:            session.StartedAt,
+		UpdatedAt:            session.UpdatedAt,
+		Answered:             []AnsweredQuestion{},
+		RemainingQuestionIds: []string{},
+	}
+	progress.Score, progress.PossibleScore = session.TotalScore()
+	answered := map[string]bool{}
+	for _, answer := range session.LatestAnswers() {
+		answered[answer.QuestionId] = true
+		progress.Answered = append(progress.Answered, AnsweredQuestion{
+			QuestionId:    answer.QuestionId,
+			Answer:        answer.Answer,
+			Response:      answer.Response,
+			Score:         answer.Score,
+			PossibleScore: answer.PossibleScore,
+			Category:      answer.Category,
+			EvaluationId:  answer.EvaluationId,
+			AnsweredAt:    answer.AnsweredAt,
+		})
+	}
+	for _, question := range eventQuestions[session.EventName] {
+		if !answered[question.Id.String()] {
+			progress.RemainingQuestionIds = append(progress.RemainingQuestionIds, question.Id.String())
+		}
+	}
+	return sessionJsonResponse(currentContext, progress)
+}
+
+func getSessionSummary(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+	session, errorResponse := findSession(currentContext, request)
+	if errorResponse != nil {
+		return instrumentation.ErrorResponse(errorResponse.message, errorResponse.statusCode), nil
+	}
+
+	summary := SessionSummary{
+		ExecutionId: session.ExecutionId,
+		EventName:   session.EventName,
+		Nickname:    session.Nickname,
+		Questions:   []QuestionSummary{},
+		Complete:    true,
+	}
+	latest := map[string]store.Answer{}
+	for _, answer := range session.LatestAnswers() {
+		latest[answer.QuestionId] = answer
+	}
+	var lastAnsweredAt time.Time
+	for _, question := range eventQuestions[session.EventName] {
+		questionSummary := QuestionSummary{QuestionId: question.Id.String(), Question: question.Question, PossibleScore: possibleScoreOf(question)}
+		if pointsPerQuestion := scoringPolicyFor(session.EventName).PointsPerQuestion; pointsPerQuestion > 0 {
+			questionSummary.PossibleScore = pointsPerQuestion
+		}
+		answer, ok := latest[questionSummary.QuestionId]
+		if !ok {
+			summary.Complete = false
+			summary.PossibleScore += questionSummary.PossibleScore
+			summary.Questions = append(summary.Questions, questionSummary)
+			continue
+		}
+		questionSummary.Answered = true
+		questionSummary.Score = answer.Score
+		questionSummary.PossibleScore = answer.PossibleScore // after the event's scoring policy
+		questionSummary.Category = answer.Category
+		summary.Score += answer.Score
+		summary.PossibleScore += answer.PossibleScore
+		if answer.AnsweredAt.After(lastAnsweredAt) {
+			lastAnsweredAt = answer.AnsweredAt
+		}
+		summary.Questions = append(summary.Questions, questionSummary)
+	}
+	if summary.Complete && !lastAnsweredAt.IsZero() {
+		summary.CompletedAt = &lastAnsweredAt
+	}
+	trace.SpanFromContext(currentContext).SetAttributes(attribute.Bool("app.session.complete", summary.Complete),
+		attribute.Int("app.session.score", summary.Score))
+	return sessionJsonResponse(currentContext, summary)
+}
+
+// findSession gets the session in the path, if the caller is allowed to see it.
+func findSession(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (store.Session, *errorResponseType) {
+	span := trace.SpanFromContext(currentContext)
+	eventName := getEventName(request)
+	executionId := strings.Split(request.RequestContext.HTTP.Path, "/")[3]
+	span.SetAttributes(attribute.String("app.session.event_name", eventName),
+		attribute.String("app.session.execution_id", executionId))
+
+	session, found, err := attendeeStore.Session(currentContext, eventName, executionId)
+	if err != nil {
+		span.RecordError(err)
+		return session, &errorResponseType{message: "Couldn't read the attendee store", statusCode: 500}
+	}
+	if !found || executionId == "unset" {
+		return session, &errorResponseType{message: "Couldn't find a session with that ID in this event", statusCode: 404}
+	}
+	if session.AttendeeKeyHash == "" {
+		// nobody owns it, so we can't tell whether it's theirs
+		span.SetAttributes(attribute.Bool("app.session.unowned", true))
+		return session, &errorResponseType{message: "That session isn't tied to an API key. Start one with POST /api/sessions", statusCode: 403}
+	}
+	if session.AttendeeKeyHash != attendeeKeyHashOf(request) {
+		span.SetAttributes(attribute.Bool("app.session.key_mismatch", true))
+		return session, &errorResponseType{message: "That session belongs to a different API key", statusCode: 403}
+	}
+	return session, nil
+}
+
+// checkSessionOwner stops anyone but its owner from adding to an issued session. Made-up ids, and ones we haven't seen, are anyone's.
+func checkSessionOwner(currentContext context.Context, request events.APIGatewayV2HTTPRequest, eventName string, executionId string) *errorResponseType {
+	span := trace.SpanFromContext(currentContext)
+	session, found, err := attendeeStore.Session(currentContext, eventName, executionId)
+	if err != nil {
+		// the booth keeps going without the store; see recordAnswer
+		span.RecordError(err)
+		return nil
+	}
+	if !found || session.AttendeeKeyHash == "" {
+		return nil
+	}
+	if session.AttendeeKeyHash != attendeeKeyHashOf(request) {
+		span.SetAttributes(attribute.Bool("app.session.key_mismatch", true))
+		return &errorResponseType{message: "That session belongs to a different API key", statusCode: 403}
+	}
+	return nil
+}
+
+// attendeeKeyHashOf is the hash of the attendee's API key, or empty if they didn't send one
+func attendeeKeyHashOf(request events.APIGatewayV2HTTPRequest) string {
+	attendeeApiKey := getHeader(request, ATTENDEE_API_KEY_HEADER)
+	if strings.TrimSpace(attendeeApiKey) == "" {
+		return ""
+	}
+	return queryData.HashAttendeeApiKey(attendeeApiKey)
+}
+
+func sessionJsonResponse(currentContext context.Context, body interface{}) (events.APIGatewayV2HTTPResponse, error) {
+	sessionJson, err := json.Marshal(body)
+	if err != nil {
+		trace.SpanFromContext(currentContext).RecordError(err)
+		return instrumentation.ErrorResponse("Failure marshalling JSON", 500), nil
+	}
+	return events.APIGatewayV2HTTPResponse{
+		Body:       string(sessionJson),
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		StatusCode: 200}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"observaquiz_lambda/cmd/api/queryData"
+	"observaquiz_lambda/cmd/api/store"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func sessionRequest(executionId string, attendeeApiKey string) events.APIGatewayV2HTTPRequest {
+	request := events.APIGatewayV2HTTPRequest{Headers: map[string]string{"event-name": testEventName, ATTENDEE_API_KEY_HEADER: attendeeApiKey}}
+	request.RequestContext.HTTP.Method = "GET"
+	request.RequestContext.HTTP.Path = "/api/sessions/" + executionId
+	return request
+}
+
+func startTestSession(t *testing.T, executionId string, attendeeApiKey string) {
+	t.Helper()
+	err := attendeeStore.StartSession(context.Background(), store.SessionStart{EventName: testEventName, ExecutionId: executionId, AttendeeKeyHash: queryData.HashAttendeeApiKey(attendeeApiKey), StartedAt: time.Now().UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindSessionNeedsTheOwnersKey(t *testing.T) {
+	startTestSession(t, "owned-session", "owner-key")
+	recordAnswer(context.Background(), testEventName, v1QuestionId, "made-up-session", "", AnswerBody{Answer: "anything"}, &responseToAnswer{score: 10, possibleScore: 100})
+
+	cases := []struct {
+		name           string
+		executionId    string
+		attendeeApiKey string
+		statusCode     int
+	}{
+		{"the owner", "owned-session", "owner-key", http.StatusOK},
+		{"someone else", "owned-session", "other-key", http.StatusForbidden},
+		{"no key", "owned-session", "", http.StatusForbidden},
+		{"nobody owns it", "made-up-session", "", http.StatusForbidden},
+		{"unset", "unset", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		_, errorResponse := findSession(context.Background(), sessionRequest(c.executionId, c.attendeeApiKey))
+		statusCode := http.StatusOK
+		if errorResponse != nil {
+			statusCode = errorResponse.statusCode
+		}
+		if statusCode != c.statusCode {
+			t.Errorf("%s: expected %d, got %d", c.name, c.statusCode, statusCode)
+		}
+	}
+}
+
+func TestAnsweringWithSomeoneElsesSession(t *testing.T) {
+	startTestSession(t, "answer-owned-session", "owner-key")
+	request := answerRequest(v1QuestionId, "answer-owned-session", replayedV1Answer) // with a different key
+
+	response, _ := postAnswer(context.Background(), request)
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for someone else's session, got %d: %s", response.StatusCode, response.Body)
+	}
+	session, _, _ := attendeeStore.Session(context.Background(), testEventName, "answer-owned-session")
+	if len(session.Answers) != 0 {
+		t.Errorf("the answer shouldn't have been recorded: %+v", session.Answers)
+	}
+}
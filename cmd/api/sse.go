@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+/**
+ * Server-Sent Events, for endpoints that have something to say before they're done.
+ *
+ * A streamingEndpoint writes events as it goes. The standalone server and the Lambda
+ * response-streaming mode send each one to the client as soon as it is written.
+ * Behind API Gateway (which can't stream), the same handler runs into a buffer and
+ * the client gets all the events at once at the end.
+ */
+
+type streamingEndpoint struct {
+	method        string
+	pathTemplate  string
+	pathRegex     *regexp.Regexp
+	handler       func(context.Context, events.APIGatewayV2HTTPRequest, *sseWriter)
+	requiresEvent bool
+}
+
+type sseWriter struct {
+	lock   sync.Mutex // the response and the scoring write from different goroutines
+	writer io.Writer
+	flush  func()
+}
+
+func newSseWriter(writer io.Writer, flush func()) *sseWriter {
+	if flush == nil {
+		flush = func() {}
+	}
+	return &sseWriter{writer: writer, flush: flush}
+}
+
+func (s *sseWriter) send(event string, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = fmt.Fprintf(s.writer, "event: %s\ndata: %s\n\n", event, jsonData)
+	if err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+type sseError struct {
+	Error      string `json:"error"`
+	StatusCode int    `json:"status_code"`
+}
+
+func (s *sseWriter) sendError(message string, statusCode int) {
+	s.send("error", sseError{Error: message, StatusCode: statusCode})
+}
+
+// for API Gateway, which wants the whole body at once
+func (endpoint streamingEndpoint) buffered() apiEndpoint {
+	return apiEndpoint{
+		endpoint.method,
+		endpoint.pathTemplate,
+		endpoint.pathRegex,
+		func(currentContext context.Context, request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+			body := bytes.Buffer{}
+			endpoint.handler(currentContext, request, newSseWriter(&body, nil))
+			return events.APIGatewayV2HTTPResponse{
+				Body:       body.String(),
+				Headers:    sseHeaders(),
+				StatusCode: 200}, nil
+		},
+		endpoint.requiresEvent,
+	}
+}
+
+func sseHeaders() map[string]string {
+	return map[string]string{"Content-Type": "text/event-stream", "Cache-Control": "no-cache"}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+/**
+ * Deleting attendee data: everything one attendee did (DeleteAttendee), or everything nobody has touched since a cutoff (DeleteBefore).
+ * A deleted session is gone, with its answers, opinions, nickname and lead. FileStore rewrites its file without them,
+ * so they don't come back when it's read again.
+ *
+ * Its lead is withdrawn first, and that much is kept: a CRM that had them still needs to hear they're gone.
+ * Leads returns it with the rest, and delivery saves how it went with SaveLead, as it would for any other.
+ *
+ * Drawings are kept, because they say who won, but someone deleted is taken out of them:
+ * their execution id becomes DELETED_EXECUTION_ID, and their nickname goes.
+ */
+
+const DELETED_EXECUTION_ID = "deleted"
+
+// What was deleted, so whoever asked for it can record it.
+type Deletion struct {
+	Sessions           []DeletedSession `json:"sessions"`
+	DrawingQty         int              `json:"drawing_qty"`          // drawings removed entirely
+	ScrubbedDrawingQty int              `json:"scrubbed_drawing_qty"` // drawings kept, with deleted attendees taken out
+	WithdrawnLeadQty   int              `json:"withdrawn_lead_qty"`   // leads of deleted sessions, kept withdrawn
+}
+
+type DeletedSession struct {
+	EventName   string    `json:"event_name"`
+	ExecutionId string    `json:"execution_id"`
+	AnswerQty   int       `json:"answer_qty"`
+	OpinionQty  int       `json:"opinion_qty"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (deletion Deletion) AnswerQty() int {
+	total := 0
+	for _, session := range deletion.Sessions {
+		total += session.AnswerQty
+	}
+	return total
+}
+
+// worked out with the lock held, then applied; FileStore rewrites its file in between
+type deletionPlan struct {
+	deletion          Deletion
+	sessions          map[sessionKey]bool
+	drawings          map[string][]Drawing // by event: what's left of them
+	deletedDrawingIds map[string]bool
+	withdrawnLeads    []Lead
+}
+
+/* these expect the lock to be held */
+
+func (s *MemoryStore) planDeletion(deleteSession func(*Session) bool, deleteDrawing func(Drawing) bool) deletionPlan {
+	plan := deletionPlan{deletion: Deletion{Sessions: []DeletedSession{}}, sessions: map[sessionKey]bool{}, drawings: map[string][]Drawing{}, deletedDrawingIds: map[string]bool{}}
+	withdrawnAt := time.Now().UTC()
+	for key, session := range s.sessions {
+		if deleteSession(session) {
+			plan.sessions[key] = true
+			if session.Lead != nil {
+				plan.withdrawnLeads = append(plan.withdrawnLeads, session.Lead.Withdraw(withdrawnAt))
+			}
+			plan.deletion.Sessions = append(plan.deletion.Sessions, DeletedSession{
+				EventName:   session.EventName,
+				ExecutionId: session.ExecutionId,
+				AnswerQty:   len(session.Answers),
+				OpinionQty:  len(session.Opinions),
+				StartedAt:   session.StartedAt,
+				UpdatedAt:   session.UpdatedAt,
+			})
+		}
+	}
+	plan.deletion.WithdrawnLeadQty = len(plan.withdrawnLeads)
+	sort.Slice(plan.deletion.Sessions, func(i, j int) bool {
+		return plan.deletion.Sessions[i].StartedAt.Before(plan.deletion.Sessions[j].StartedAt)
+	})
+
+	for eventName, drawings := range s.drawings {
+		kept := []Drawing{}
+		for _, drawing := range drawings {
+			if deleteDrawing(drawing) {
+				plan.deletion.DrawingQty++
+				plan.deletedDrawingIds[drawing.Id] = true
+				continue
+			}
+			scrubbed, changed := plan.scrub(drawing)
+			if changed {
+				plan.deletion.ScrubbedDrawingQty++
+			}
+			kept = append(kept, scrubbed)
+		}
+		plan.drawings[eventName] = kept
+	}
+	return plan
+}
+
+func (s *MemoryStore) applyDeletion(plan deletionPlan) {
+	for key := range plan.sessions {
+		delete(s.sessions, key)
+	}
+	for eventName, drawings := range plan.drawings {
+		s.drawings[eventName] = drawings
+	}
+	for _, lead := range plan.withdrawnLeads {
+		s.withdrawnLeads[lead.Id] = lead
+	}
+}
+
+// scrub takes deleted attendees out of a drawing. The slices are copied, because the store's drawing shares them.
+func (plan deletionPlan) scrub(drawing Drawing) (Drawing, bool) {
+	deleted := func(executionId string) bool {
+		return plan.sessions[sessionKey{drawing.EventName, executionId}]
+	}
+	changed := false
+	candidateIds := make([]string, len(drawing.CandidateIds))
+	for i, executionId := range drawing.CandidateIds {
+		candidateIds[i] = executionId
+		if deleted(executionId) {
+			candidateIds[i] = DELETED_EXECUTION_ID
+			changed = true
+		}
+	}
+	winners := make([]PrizeWinner, len(drawing.Winners))
+	for i, winner := range drawing.Winners {
+		winners[i] = winner
+		if deleted(winner.ExecutionId) {
+			winners[i] = PrizeWinner{ExecutionId: DELETED_EXECUTION_ID, Score: winner.Score}
+			changed = true
+		}
+	}
+	drawing.CandidateIds, drawing.Winners = candidateIds, winners
+	return drawing, changed
+}
+
+// rewritten says what to write back instead of r; keep is false if it goes
+func (plan deletionPlan) rewritten(r record) (rewritten record, keep bool) {
+	if r.Drawing != nil {
+		if plan.deletedDrawingIds[r.Drawing.Id] {
+			return r, false
+		}
+		scrubbed, _ := plan.scrub(*r.Drawing)
+		r.Drawing = &scrubbed
+		return r, true
+	}
+	return r, !plan.deletesRecord(r)
+}
+
+// what FileStore writes after the rest: the withdrawn leads
+func (plan deletionPlan) appended() []record {
+	records := []record{}
+	for i := range plan.withdrawnLeads {
+		records = append(records, record{Kind: recordKindWithdrawnLead, Lead: &plan.withdrawnLeads[i]})
+	}
+	return records
+}
+
+func (plan deletionPlan) deletesRecord(r record) bool {
+	switch {
+	case r.Kind == recordKindWithdrawnLead:
+		return false // its session was deleted already; a new one with the same execution id doesn't take it along
+	case r.Answer != nil:
+		return plan.sessions[sessionKey{r.Answer.EventName, r.Answer.ExecutionId}]
+	case r.Opinion != nil:
+		return plan.sessions[sessionKey{r.Opinion.EventName, r.Opinion.ExecutionId}]
+	case r.Nickname != nil:
+		return plan.sessions[sessionKey{r.Nickname.EventName, r.Nickname.ExecutionId}]
+	case r.Start != nil:
+		return plan.sessions[sessionKey{r.Start.EventName, r.Start.ExecutionId}]
+	case r.Lead != nil:
+		return plan.sessions[sessionKey{r.Lead.EventName, r.Lead.ExecutionId}]
+	}
+	return false
+}
+
+func attendeeSessions(attendeeKeyHash string) func(*Session) bool {
+	return func(session *Session) bool {
+		return attendeeKeyHash != "" && session.AttendeeKeyHash == attendeeKeyHash
+	}
+}
+
+func noDrawings(Drawing) bool { return false }
+
+func sessionsBefore(cutoff time.Time) func(*Session) bool {
+	return func(session *Session) bool { return session.UpdatedAt.Before(cutoff) }
+}
+
+func drawingsBefore(cutoff time.Time) func(Drawing) bool {
+	return func(drawing Drawing) bool { return drawing.DrawnAt.Before(cutoff) }
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssuedSessionKeepsItsOwner(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.StartSession(ctx, SessionStart{EventName: "ev", ExecutionId: "issued", AttendeeKeyHash: "owner", StartedAt: testTime})
+	answer := testAnswer("issued", "q1", 10, 1)
+	answer.AttendeeKeyHash = "someone else"
+	s.SaveAnswer(ctx, answer)
+
+	made := testAnswer("made-up", "q1", 10, 1)
+	made.AttendeeKeyHash = "first"
+	s.SaveAnswer(ctx, made)
+	made.AttendeeKeyHash = "second"
+	s.SaveAnswer(ctx, made)
+
+	if session, _, _ := s.Session(ctx, "ev", "issued"); session.AttendeeKeyHash != "owner" {
+		t.Errorf("an issued session belongs to who it was issued to, not %q", session.AttendeeKeyHash)
+	}
+	if session, _, _ := s.Session(ctx, "ev", "made-up"); session.AttendeeKeyHash != "first" {
+		t.Errorf("a made-up id belongs to the first key used with it, not %q", session.AttendeeKeyHash)
+	}
+}
+
+func TestDeletingAnAttendeeTakesThemOutOfDrawings(t *testing.T) {
+	ctx := context.Background()
+	s, path := openTestFileStore(t)
+	s.StartSession(ctx, SessionStart{EventName: "ev", ExecutionId: "forgotten", AttendeeKeyHash: "hash", StartedAt: testTime})
+	s.SaveAnswer(ctx, testAnswer("forgotten", "q1", 90, 1))
+	s.SetNickname(ctx, Nickname{EventName: "ev", ExecutionId: "forgotten", Nickname: "Secret Squirrel", SetAt: testTime})
+	s.SaveAnswer(ctx, testAnswer("kept", "q1", 80, 1))
+	s.SaveDrawing(ctx, Drawing{Id: "drawing-1", EventName: "ev", DrawnAt: testTime, CandidateIds: []string{"forgotten", "kept"},
+		Winners: []PrizeWinner{{ExecutionId: "forgotten", Nickname: "Secret Squirrel", Score: 90}}})
+
+	deletion, err := s.DeleteAttendee(ctx, "hash")
+	if err != nil || len(deletion.Sessions) != 1 || deletion.AnswerQty() != 1 || deletion.ScrubbedDrawingQty != 1 || deletion.DrawingQty != 0 {
+		t.Fatalf("expected one session deleted and one drawing scrubbed: %+v %v", deletion, err)
+	}
+	contents, _ := os.ReadFile(path)
+	if strings.Contains(string(contents), "Secret Squirrel") || strings.Contains(string(contents), `"execution_id":"forgotten"`) {
+		t.Errorf("they're still in the file:\n%s", contents)
+	}
+
+	s = reopen(t, path)
+	if _, found, _ := s.Session(ctx, "ev", "forgotten"); found {
+		t.Errorf("the deleted session came back")
+	}
+	if _, found, _ := s.Session(ctx, "ev", "kept"); !found {
+		t.Errorf("someone else's session went too")
+	}
+	drawings, _ := s.Drawings(ctx, "ev")
+	if len(drawings) != 1 || drawings[0].CandidateIds[0] != DELETED_EXECUTION_ID || drawings[0].Winners[0] != (PrizeWinner{ExecutionId: DELETED_EXECUTION_ID, Score: 90}) {
+		t.Errorf("expected the drawing kept, without them: %+v", drawings)
+	}
+}
+
+func TestDeleteBeforeGoesByLastActivity(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.SaveAnswer(ctx, testAnswer("old", "q1", 10, 0))
+	s.SaveAnswer(ctx, testAnswer("came-back", "q1", 10, 0))
+	s.SaveAnswer(ctx, testAnswer("came-back", "q2", 10, 60*24*10))
+	s.SaveDrawing(ctx, Drawing{Id: "old-drawing", EventName: "ev", DrawnAt: testTime})
+
+	deletion, _ := s.DeleteBefore(ctx, testTime.Add(24*time.Hour))
+	if len(deletion.Sessions) != 1 || deletion.Sessions[0].ExecutionId != "old" || deletion.DrawingQty != 1 {
+		t.Errorf("expected only the old session and the old drawing: %+v", deletion)
+	}
+	if _, found, _ := s.Session(ctx, "ev", "came-back"); !found {
+		t.Errorf("a session with a recent answer went")
+	}
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Export writes one row per answer, for whoever wants the spreadsheet after the event.
+ * The API's GET /api/admin/export and cmd/export both use it.
+ *
+ * Columns picks which columns, in that order (default: all of them). Redact keeps a column but hides what's in it:
+ * execution_id becomes a stable pseudonym (the same attendee gets the same one), everything else becomes empty (null in JSONL).
+ * So "drop raw answers, keep only categories" is Columns without answer and response, or Redact: answer, response.
+ *
+ * In CSV, text that a spreadsheet would take for a formula (=, +, -, @, tab, or carriage return first) gets a ' in front.
+ */
+
+const (
+	EXPORT_FORMAT_CSV   = "csv"
+	EXPORT_FORMAT_JSONL = "jsonl"
+)
+
+var ExportColumns = []string{
+	"event_name",
+	"execution_id",
+	"nickname",
+	"session_started_at",
+	"question_id",
+	"answered_at",
+	"answer",
+	"response",
+	"category",
+	"score",
+	"possible_score",
+	"partial_score",
+	"flags",
+	"evaluation_id",
+	"opinion",
+	"opinion_given_at",
+}
+
+type ExportOptions struct {
+	Format      string   // csv (default) or jsonl
+	Columns     []string // default: ExportColumns
+	Redact      []string
+	AllAttempts bool // every answer, not just the latest to each question
+}
+
+func (options ExportOptions) columns() []string {
+	if len(options.Columns) == 0 {
+		return ExportColumns
+	}
+	return options.Columns
+}
+
+// Check says what's wrong with the options, before anything is written
+func (options ExportOptions) Check() error {
+	if options.Format != "" && options.Format != EXPORT_FORMAT_CSV && options.Format != EXPORT_FORMAT_JSONL {
+		return fmt.Errorf("format is %s or %s, not '%s'", EXPORT_FORMAT_CSV, EXPORT_FORMAT_JSONL, options.Format)
+	}
+	known := map[string]bool{}
+	for _, column := range ExportColumns {
+		known[column] = true
+	}
+	for _, column := range append(append([]string{}, options.Columns...), options.Redact...) {
+		if !known[column] {
+			return fmt.Errorf("there is no column '%s'. The columns are: %s", column, strings.Join(ExportColumns, ", "))
+		}
+	}
+	return nil
+}
+
+// ParseColumnList reads "answer, category" the way a query string or command line says it
+func ParseColumnList(list string) []string {
+	columns := []string{}
+	for _, column := range strings.Split(list, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// Export writes the rows and says how many there were.
+func Export(writer io.Writer, sessions []Session, options ExportOptions) (int, error) {
+	if err := options.Check(); err != nil {
+		return 0, err
+	}
+	columns := options.columns()
+	redacted := map[string]bool{}
+	for _, column := range options.Redact {
+		redacted[column] = true
+	}
+
+	var csvWriter *csv.Writer
+	if options.Format != EXPORT_FORMAT_JSONL {
+		csvWriter = csv.NewWriter(writer)
+		if err := csvWriter.Write(columns); err != nil {
+			return 0, err
+		}
+	}
+
+	rowQty := 0
+	for _, session := range sessions {
+		answers := session.LatestAnswers()
+		if options.AllAttempts {
+			answers = session.Answers
+		}
+		for _, answer := range answers {
+			values := exportRow(session, answer)
+			for column := range redacted {
+				values[column] = redact(column, values[column])
+			}
+			var err error
+			if csvWriter != nil {
+				row := make([]string, len(columns))
+				for i, column := range columns {
+					row[i] = csvValue(values[column])
+				}
+				err = csvWriter.Write(row)
+			} else {
+				err = writeJsonLine(writer, columns, values)
+			}
+			if err != nil {
+				return rowQty, err
+			}
+			rowQty++
+		}
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return rowQty, csvWriter.Error()
+	}
+	return rowQty, nil
+}
+
+func exportRow(session Session, answer Answer) map[string]interface{} {
+	opinions, opinionTimes := []string{}, []string{}
+	for _, opinion := range session.Opinions {
+		if opinion.EvaluationId != "" && opinion.EvaluationId == answer.EvaluationId {
+			opinions = append(opinions, opinion.Opinion)
+			opinionTimes = append(opinionTimes, exportTime(opinion.GivenAt))
+		}
+	}
+	flags := answer.Flags
+	if flags == nil {
+		flags = []string{}
+	}
+	return map[string]interface{}{
+		"event_name":         session.EventName,
+		"execution_id":       session.ExecutionId,
+		"nickname":           session.Nickname,
+		"session_started_at": exportTime(session.StartedAt),
+		"question_id":        answer.QuestionId,
+		"answered_at":        exportTime(answer.AnsweredAt),
+		"answer":             answer.Answer,
+		"response":           answer.Response,
+		"category":           answer.Category,
+		"score":              answer.Score,
+		"possible_score":     answer.PossibleScore,
+		"partial_score":      answer.PartialScore,
+		"flags":              flags,
+		"evaluation_id":      answer.EvaluationId,
+		"opinion":            opinions,
+		"opinion_given_at":   opinionTimes,
+	}
+}
+
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func redact(column string, value interface{}) interface{} {
+	if executionId, ok := value.(string); ok && column == "execution_id" && executionId != "" {
+		return fmt.Sprintf("attendee-%x", sha256.Sum256([]byte(executionId)))[:len("attendee-")+12]
+	}
+	return nil
+}
+
+// lists (flags, opinions) are separated by semicolons
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return SpreadsheetSafe(v)
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case []string:
+		return SpreadsheetSafe(strings.Join(v, ";"))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Attendees typed the answers. A spreadsheet runs a cell that starts with one of these as a formula,
+// so start it with a ' instead, which spreadsheets take to mean "this is text". The lead export uses this too.
+func SpreadsheetSafe(text string) string {
+	if text != "" && strings.ContainsAny(text[:1], "=+-@\t\r") {
+		return "'" + text
+	}
+	return text
+}
+
+// in column order, so the lines read the same as the CSV
+func writeJsonLine(writer io.Writer, columns []string, values map[string]interface{}) error {
+	line := strings.Builder{}
+	line.WriteString("{")
+	for i, column := range columns {
+		if i > 0 {
+			line.WriteString(",")
+		}
+		key, _ := json.Marshal(column)
+		value, _ := json.Marshal(values[column])
+		line.Write(key)
+		line.WriteString(":")
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+	_, err := io.WriteString(writer, line.String())
+	return err
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportTestSession() Session {
+	answer := testAnswer("one", "q1", 10, 1)
+	answer.Category = "logs"
+	answer.Flags = []string{"guard", "moderation"}
+	return Session{EventName: "ev", ExecutionId: "one", Nickname: "Ada", StartedAt: testTime,
+		Answers:  []Answer{testAnswer("one", "q1", 5, 0), answer},
+		Opinions: []Opinion{{EventName: "ev", ExecutionId: "one", EvaluationId: "one-q1", Opinion: "nice", GivenAt: testTime.Add(2 * time.Minute)}},
+	}
+}
+
+func exportCsv(t *testing.T, sessions []Session, options ExportOptions) [][]string {
+	t.Helper()
+	written := bytes.Buffer{}
+	if _, err := Export(&written, sessions, options); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&written).ReadAll()
+	if err != nil {
+		t.Fatalf("that's not CSV: %v\n%s", err, written.String())
+	}
+	return rows
+}
+
+func TestExportColumnsInTheirOrder(t *testing.T) {
+	rows := exportCsv(t, []Session{exportTestSession()}, ExportOptions{Columns: []string{"score", "question_id", "flags", "opinion"}})
+	if len(rows) != 2 {
+		t.Fatalf("expected a header and the latest answer, got %q", rows)
+	}
+	if strings.Join(rows[0], ",") != "score,question_id,flags,opinion" {
+		t.Errorf("expected the columns asked for, in that order: %q", rows[0])
+	}
+	if strings.Join(rows[1], ",") != "10,q1,guard;moderation,nice" {
+		t.Errorf("unexpected row: %q", rows[1])
+	}
+
+	all := exportCsv(t, []Session{exportTestSession()}, ExportOptions{AllAttempts: true})
+	if len(all) != 3 || len(all[0]) != len(ExportColumns) {
+		t.Errorf("expected every column, and both attempts: %q", all)
+	}
+}
+
+func TestExportRedacts(t *testing.T) {
+	rows := exportCsv(t, []Session{exportTestSession()}, ExportOptions{Columns: []string{"execution_id", "answer", "category"}, Redact: []string{"execution_id", "answer"}})
+	pseudonym := rows[1][0]
+	if !strings.HasPrefix(pseudonym, "attendee-") || strings.Contains(pseudonym, "one") || rows[1][1] != "" || rows[1][2] != "logs" {
+		t.Errorf("expected a pseudonym, no answer, and the category: %q", rows[1])
+	}
+	again := exportCsv(t, []Session{exportTestSession()}, ExportOptions{Columns: []string{"execution_id"}, Redact: []string{"execution_id"}})
+	if again[1][0] != pseudonym {
+		t.Errorf("expected the same attendee to get the same pseudonym: %s then %s", pseudonym, again[1][0])
+	}
+
+	written := bytes.Buffer{}
+	Export(&written, []Session{exportTestSession()}, ExportOptions{Format: EXPORT_FORMAT_JSONL, Columns: []string{"answer", "score"}, Redact: []string{"answer"}})
+	if strings.TrimSpace(written.String()) != `{"answer":null,"score":10}` {
+		t.Errorf("expected a null answer in JSONL, got %s", written.String())
+	}
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	for _, answer := range []string{"=HYPERLINK(\"http://example.com\")", "+1", "-1", "@SUM(A1)", "\tindented", "\rreturned"} {
+		session := exportTestSession()
+		session.Answers = []Answer{testAnswer("one", "q1", -5, 0)}
+		session.Answers[0].Answer = answer
+		rows := exportCsv(t, []Session{session}, ExportOptions{Columns: []string{"answer", "score"}})
+		if rows[1][0] != "'"+answer {
+			t.Errorf("expected %q escaped, got %q", answer, rows[1][0])
+		}
+		if rows[1][1] != "-5" {
+			t.Errorf("a negative score is a number, not a formula: %q", rows[1][1])
+		}
+	}
+
+	rows := exportCsv(t, []Session{exportTestSession()}, ExportOptions{Columns: []string{"answer"}})
+	if rows[1][0] != "we read the logs" {
+		t.Errorf("expected an ordinary answer left alone, got %q", rows[1][0])
+	}
+
+	written := bytes.Buffer{}
+	session := exportTestSession()
+	session.Answers[1].Answer = "=1+1"
+	Export(&written, []Session{session}, ExportOptions{Format: EXPORT_FORMAT_JSONL, Columns: []string{"answer"}})
+	line := map[string]string{}
+	json.Unmarshal(written.Bytes(), &line)
+	if line["answer"] != "=1+1" {
+		t.Errorf("JSONL isn't a spreadsheet; expected the answer as it was, got %q", line["answer"])
+	}
+}
+
+func TestExportOptionsAreChecked(t *testing.T) {
+	if err := (ExportOptions{Format: "xlsx"}).Check(); err == nil {
+		t.Errorf("expected xlsx turned down")
+	}
+	if err := (ExportOptions{Redact: []string{"email"}}).Check(); err == nil || !strings.Contains(err.Error(), "execution_id") {
+		t.Errorf("expected an error listing the columns, got %v", err)
+	}
+	if columns := ParseColumnList(" answer, ,category "); strings.Join(columns, ",") != "answer,category" {
+		t.Errorf("unexpected columns: %q", columns)
+	}
+}
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/**
+ * FileStore keeps a MemoryStore, and writes every change to a file as one line of JSON before making it.
+ * When it opens, it reads the file back to get where it was. Nothing to run next to it, and a run of the
+ * local server keeps its attendees across restarts.
+ *
+ * Deleting rewrites the whole file (deletion.go), which is fine at booth scale.
+ *
+ * In Lambda, only /tmp is writable, and each instance has its own /tmp: point store_path there,
+ * and know that it lasts as long as the instance does.
+ */
+
+const (
+	recordKindAnswer   = "answer"
+	recordKindOpinion  = "opinion"
+	recordKindNickname = "nickname"
+	recordKindStart    = "session_start"
+	recordKindTiers    = "prize_tiers"
+	recordKindDrawing  = "drawing"
+	recordKindLead     = "lead"
+
+	recordKindWithdrawnLead = "withdrawn_lead" // of a deleted session
+)
+
+type record struct {
+	Kind     string        `json:"kind"`
+	Answer   *Answer       `json:"answer,omitempty"`
+	Opinion  *Opinion      `json:"opinion,omitempty"`
+	Nickname *Nickname     `json:"nickname,omitempty"`
+	Start    *SessionStart `json:"start,omitempty"`
+	Tiers    *PrizeTiers   `json:"tiers,omitempty"`
+	Drawing  *Drawing      `json:"drawing,omitempty"`
+	Lead     *Lead         `json:"lead,omitempty"`
+}
+
+type FileStore struct {
+	lock   sync.Mutex // held while writing, so lines go into the file in the same order as into memory
+	path   string
+	memory *MemoryStore
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, memory: NewMemoryStore()}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil // it'll be created by the first write
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // an answer with all its reasoning can be long
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r := record{}
+		err = json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s line %d: %w", path, lineNumber, err)
+		}
+		s.apply(r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) SaveAnswer(currentContext context.Context, answer Answer) error {
+	return s.write(record{Kind: recordKindAnswer, Answer: &answer})
+}
+
+func (s *FileStore) SaveOpinion(currentContext context.Context, opinion Opinion) error {
+	s.memory.lock.Lock()
+	opinion = s.memory.withQuestionId(opinion)
+	s.memory.lock.Unlock()
+	return s.write(record{Kind: recordKindOpinion, Opinion: &opinion})
+}
+
+func (s *FileStore) SetNickname(currentContext context.Context, nickname Nickname) error {
+	return s.write(record{Kind: recordKindNickname, Nickname: &nickname})
+}
+
+func (s *FileStore) StartSession(currentContext context.Context, start SessionStart) error {
+	return s.write(record{Kind: recordKindStart, Start: &start})
+}
+
+func (s *FileStore) Session(currentContext context.Context, eventName string, executionId string) (Session, bool, error) {
+	return s.memory.Session(currentContext, eventName, executionId)
+}
+
+func (s *FileStore) Sessions(currentContext context.Context, eventName string) ([]Session, error) {
+	return s.memory.Sessions(currentContext, eventName)
+}
+
+func (s *FileStore) SavePrizeTiers(currentContext context.Context, tiers PrizeTiers) error {
+	return s.write(record{Kind: recordKindTiers, Tiers: &tiers})
+}
+
+func (s *FileStore) PrizeTiers(currentContext context.Context, eventName string) (PrizeTiers, bool, error) {
+	return s.memory.PrizeTiers(currentContext, eventName)
+}
+
+func (s *FileStore) SaveDrawing(currentContext context.Context, drawing Drawing) error {
+	return s.write(record{Kind: recordKindDrawing, Drawing: &drawing})
+}
+
+func (s *FileStore) Drawings(currentContext context.Context, eventName string) ([]Drawing, error) {
+	return s.memory.Drawings(currentContext, eventName)
+}
+
+// A withdrawn lead has no name or email, but the lines before it do; those go.
+func (s *FileStore) SaveLead(currentContext context.Context, lead Lead) error {
+	saved := record{Kind: recordKindLead, Lead: &lead}
+	if s.memory.hasWithdrawnLead(lead.Id) {
+		saved.Kind = recordKindWithdrawnLead // there's no session to read it back into
+	}
+	if !lead.Withdrawn() {
+		return s.write(saved)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.rewrite(func(r record) (record, bool) {
+		earlier := r.Lead != nil && r.Lead.Id == lead.Id
+		return r, !earlier
+	})
+	if err != nil {
+		return err
+	}
+	return s.writeLocked(saved)
+}
+
+func (s *FileStore) Leads(currentContext context.Context, eventName string) ([]Lead, error) {
+	return s.memory.Leads(currentContext, eventName)
+}
+
+func (s *FileStore) DeleteAttendee(currentContext context.Context, attendeeKeyHash string) (Deletion, error) {
+	return s.delete(attendeeSessions(attendeeKeyHash), noDrawings)
+}
+
+func (s *FileStore) DeleteBefore(currentContext context.Context, cutoff time.Time) (Deletion, error) {
+	return s.delete(sessionsBefore(cutoff), drawingsBefore(cutoff))
+}
+
+// Appending "it's deleted" would leave what they wrote in the file, so the file is written again without it.
+// Like write, the file changes first; memory only forgets once the file has.
+func (s *FileStore) delete(deleteSession func(*Session) bool, deleteDrawing func(Drawing) bool) (Deletion, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.memory.lock.Lock()
+	plan := s.memory.planDeletion(deleteSession, deleteDrawing)
+	s.memory.lock.Unlock()
+	if len(plan.deletion.Sessions) == 0 && plan.deletion.DrawingQty == 0 && plan.deletion.ScrubbedDrawingQty == 0 {
+		return plan.deletion, nil
+	}
+
+	err := s.rewrite(plan.rewritten, plan.appended()...)
+	if err != nil {
+		return Deletion{Sessions: []DeletedSession{}}, err
+	}
+	s.memory.lock.Lock()
+	s.memory.applyDeletion(plan)
+	s.memory.lock.Unlock()
+	return plan.deletion, nil
+}
+
+// rewrite writes the file again, each line as rewritten says (or not, if keep is false), then the appended ones.
+// Expects s.lock to be held. The new file replaces the old one in one rename, so a failure halfway leaves the old one
+func (s *FileStore) rewrite(rewritten func(r record) (record, bool), appended ...record) error {
+	old, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	replacement, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(replacement.Name()) // does nothing once it's been renamed
+
+	writer := bufio.NewWriter(replacement)
+	scanner := bufio.NewScanner(old)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r := record{}
+		err = json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			replacement.Close()
+			return fmt.Errorf("rereading %s: %w", s.path, err)
+		}
+		r, keep := rewritten(r)
+		if !keep {
+			continue
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			replacement.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := scanner.Err(); err != nil {
+		replacement.Close()
+		return fmt.Errorf("rereading %s: %w", s.path, err)
+	}
+	for _, r := range appended {
+		line, err := json.Marshal(r)
+		if err != nil {
+			replacement.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = replacement.Sync()
+	}
+	closeErr := replacement.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return os.Rename(replacement.Name(), s.path)
+}
+
+// to the file first: if that fails, memory doesn't get ahead of what we'd read back next time
+func (s *FileStore) write(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.appendLocked(line, r)
+}
+
+// expects s.lock to be held
+func (s *FileStore) writeLocked(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.appendLocked(line, r)
+}
+
+func (s *FileStore) appendLocked(line []byte, r record) error {
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	s.apply(r)
+	return nil
+}
+
+func (s *FileStore) apply(r record) {
+	s.memory.lock.Lock()
+	defer s.memory.lock.Unlock()
+	switch {
+	case r.Kind == recordKindAnswer && r.Answer != nil:
+		s.memory.applyAnswer(*r.Answer)
+	case r.Kind == recordKindOpinion && r.Opinion != nil:
+		s.memory.applyOpinion(*r.Opinion)
+	case r.Kind == recordKindNickname && r.Nickname != nil:
+		s.memory.applyNickname(*r.Nickname)
+	case r.Kind == recordKindStart && r.Start != nil:
+		s.memory.applySessionStart(*r.Start)
+	case r.Kind == recordKindTiers && r.Tiers != nil:
+		s.memory.applyPrizeTiers(*r.Tiers)
+	case r.Kind == recordKindDrawing && r.Drawing != nil:
+		s.memory.applyDrawing(*r.Drawing)
+	case r.Kind == recordKindLead && r.Lead != nil:
+		s.memory.applyLead(*r.Lead)
+	case r.Kind == recordKindWithdrawnLead && r.Lead != nil:
+		s.memory.withdrawnLeads[r.Lead.Id] = *r.Lead
+	}
+}
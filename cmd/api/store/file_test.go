@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestFileStore(t *testing.T) (*FileStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "attendees.jsonl")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func reopen(t *testing.T, path string) *FileStore {
+	t.Helper()
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("couldn't read it back: %v", err)
+	}
+	return s
+}
+
+func TestDeletedSessionLeavesItsLeadWithdrawn(t *testing.T) {
+	ctx := context.Background()
+	s, path := openTestFileStore(t)
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.StartSession(ctx, SessionStart{EventName: "ev", ExecutionId: "gone", AttendeeKeyHash: "hash", StartedAt: at})
+	lead := Lead{Id: "lead-1", EventName: "ev", ExecutionId: "gone", Name: "Ada Lovelace", Email: "ada@example.com",
+		Consent: LeadConsent{Contact: true}, SubmittedAt: at, Version: 1, Delivery: LeadDelivery{DeliveredVersion: 1}}
+	if err := s.SaveLead(ctx, lead); err != nil {
+		t.Fatal(err)
+	}
+
+	deletion, err := s.DeleteAttendee(ctx, "hash")
+	if err != nil || deletion.WithdrawnLeadQty != 1 {
+		t.Fatalf("expected one lead withdrawn: %+v %v", deletion, err)
+	}
+	contents, _ := os.ReadFile(path)
+	if strings.Contains(string(contents), "ada@example.com") {
+		t.Errorf("their email is still in the file")
+	}
+
+	s = reopen(t, path)
+	if _, found, _ := s.Session(ctx, "ev", "gone"); found {
+		t.Errorf("the session came back")
+	}
+	leads, _ := s.Leads(ctx, "ev")
+	if len(leads) != 1 || !leads[0].Withdrawn() || leads[0].Version != 2 || !leads[0].DeliveryPending() {
+		t.Fatalf("expected version 2, withdrawn, for the CRM to hear about: %+v", leads)
+	}
+
+	// delivering it updates the withdrawn lead; it doesn't start a session again
+	delivered := leads[0]
+	delivered.Delivery.DeliveredVersion = delivered.Version
+	if err := s.SaveLead(ctx, delivered); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(t, path)
+	if _, found, _ := s.Session(ctx, "ev", "gone"); found {
+		t.Errorf("saving the delivery brought the session back")
+	}
+	leads, _ = s.Leads(ctx, "ev")
+	if len(leads) != 1 || leads[0].DeliveryPending() {
+		t.Errorf("expected the withdrawal delivered: %+v", leads)
+	}
+}
+
+func TestFileStoreReadsBackWhereItWas(t *testing.T) {
+	ctx := context.Background()
+	s, path := openTestFileStore(t)
+	s.StartSession(ctx, SessionStart{EventName: "ev", ExecutionId: "one", AttendeeKeyHash: "hash", StartedAt: testTime})
+	s.SaveAnswer(ctx, testAnswer("one", "q1", 10, 1))
+	s.SaveAnswer(ctx, testAnswer("one", "q1", 30, 2))
+	s.SaveOpinion(ctx, Opinion{EventName: "ev", ExecutionId: "one", EvaluationId: "one-q1", Opinion: "nice", GivenAt: testTime.Add(3 * time.Minute)})
+	s.SetNickname(ctx, Nickname{EventName: "ev", ExecutionId: "one", Nickname: "Ada", SetAt: testTime.Add(4 * time.Minute)})
+	s.SavePrizeTiers(ctx, PrizeTiers{EventName: "ev"})
+	s.SaveDrawing(ctx, Drawing{Id: "drawing-1", EventName: "ev", DrawnAt: testTime, Winners: []PrizeWinner{{ExecutionId: "one", Score: 30}}})
+
+	s = reopen(t, path)
+	session, found, _ := s.Session(ctx, "ev", "one")
+	if !found || len(session.Answers) != 2 || len(session.Opinions) != 1 || session.Nickname != "Ada" || session.AttendeeKeyHash != "hash" {
+		t.Fatalf("expected it all back: %+v", session)
+	}
+	if session.Opinions[0].QuestionId != "q1" || session.LatestAnswers()[0].Score != 30 {
+		t.Errorf("expected the opinion about q1, and the later score: %+v", session)
+	}
+	if _, found, _ := s.PrizeTiers(ctx, "ev"); !found {
+		t.Errorf("the prize tiers didn't come back")
+	}
+	if drawings, _ := s.Drawings(ctx, "ev"); len(drawings) != 1 || drawings[0].Winners[0].ExecutionId != "one" {
+		t.Errorf("the drawing didn't come back: %+v", drawings)
+	}
+}
+
+func TestFileStoreSaysWhichLineItCouldNotRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "attendees.jsonl")
+	os.WriteFile(path, []byte("{\"kind\": \"answer\"}\nnot json\n"), 0644)
+	_, err := OpenFileStore(path)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error about line 2, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import "time"
+
+/**
+ * A lead is an attendee who asked us to get in touch: name, email, company, and what they agreed to.
+ * It belongs to their session, so deleting the session deletes it.
+ *
+ * Each change (submitted again, withdrawn) bumps Version. Delivery to the CRM is done when DeliveredVersion catches up.
+ * Withdrawing consent erases the name, email and company; FileStore rewrites its file so the old ones don't stay there.
+ *
+ * Deleting the session withdraws its lead first, and keeps that withdrawn lead (id, event, when), so the CRM can still be told.
+ */
+
+type Lead struct {
+	Id          string       `json:"id"` // the same for every version, so the CRM can update it
+	EventName   string       `json:"event_name"`
+	ExecutionId string       `json:"execution_id"`
+	Name        string       `json:"name,omitempty"`
+	Email       string       `json:"email,omitempty"`
+	Company     string       `json:"company,omitempty"`
+	Consent     LeadConsent  `json:"consent"`
+	SubmittedAt time.Time    `json:"submitted_at"`
+	WithdrawnAt *time.Time   `json:"withdrawn_at,omitempty"`
+	Version     int          `json:"version"`
+	Delivery    LeadDelivery `json:"delivery"`
+}
+
+type LeadConsent struct {
+	Contact   bool `json:"contact"`   // someone from sales may get in touch. We don't keep a lead without it
+	Marketing bool `json:"marketing"` // they'd like the newsletter and such
+}
+
+type LeadDelivery struct {
+	DeliveredVersion int        `json:"delivered_version"`
+	DeliveredAt      *time.Time `json:"delivered_at,omitempty"`
+	Attempts         int        `json:"attempts"` // since the last delivery
+	LastAttemptAt    *time.Time `json:"last_attempt_at,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+	GaveUp           bool       `json:"gave_up,omitempty"`
+}
+
+func (lead Lead) Withdrawn() bool {
+	return lead.WithdrawnAt != nil
+}
+
+// Consented is what may go to the CRM
+func (lead Lead) Consented() bool {
+	return lead.Consent.Contact && !lead.Withdrawn()
+}
+
+// Withdraw erases who they were, and makes a new version for the CRM to hear about. If it never had them, there's nothing to tell it
+func (lead Lead) Withdraw(at time.Time) Lead {
+	if lead.Withdrawn() {
+		return lead
+	}
+	lead.WithdrawnAt = &at
+	lead.Name, lead.Email, lead.Company = "", "", ""
+	lead.Consent = LeadConsent{}
+	lead.Version++
+	if lead.Delivery.DeliveredVersion == 0 {
+		lead.Delivery.DeliveredVersion = lead.Version
+	}
+	lead.Delivery.Attempts, lead.Delivery.LastError, lead.Delivery.GaveUp = 0, "", false
+	return lead
+}
+
+// DeliveryPending is true when the CRM hasn't heard about the latest version, and we haven't given up telling it
+func (lead Lead) DeliveryPending() bool {
+	return lead.Version > lead.Delivery.DeliveredVersion && !lead.Delivery.GaveUp
+}
@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore lives in this instance only. Each Lambda instance has its own, like the cost ledger.
+type MemoryStore struct {
+	lock       sync.Mutex
+	sessions   map[sessionKey]*Session
+	prizeTiers map[string]PrizeTiers // by event
+	drawings   map[string][]Drawing  // by event
+	// by lead id: the leads of deleted sessions, withdrawn (deletion.go)
+	withdrawnLeads map[string]Lead
+}
+
+type sessionKey struct {
+	eventName   string
+	executionId string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[sessionKey]*Session{}, prizeTiers: map[string]PrizeTiers{}, drawings: map[string][]Drawing{}, withdrawnLeads: map[string]Lead{}}
+}
+
+func (s *MemoryStore) SaveAnswer(currentContext context.Context, answer Answer) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyAnswer(answer)
+	return nil
+}
+
+func (s *MemoryStore) SaveOpinion(currentContext context.Context, opinion Opinion) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyOpinion(s.withQuestionId(opinion))
+	return nil
+}
+
+func (s *MemoryStore) SetNickname(currentContext context.Context, nickname Nickname) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyNickname(nickname)
+	return nil
+}
+
+func (s *MemoryStore) StartSession(currentContext context.Context, start SessionStart) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applySessionStart(start)
+	return nil
+}
+
+func (s *MemoryStore) Session(currentContext context.Context, eventName string, executionId string) (Session, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[sessionKey{eventName, executionId}]
+	if !ok {
+		return Session{}, false, nil
+	}
+	return session.copy(), true, nil
+}
+
+// oldest first
+func (s *MemoryStore) Sessions(currentContext context.Context, eventName string) ([]Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sessions := []Session{}
+	for key, session := range s.sessions {
+		if key.eventName == eventName {
+			sessions = append(sessions, session.copy())
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.Before(sessions[j].StartedAt) })
+	return sessions, nil
+}
+
+func (s *MemoryStore) SavePrizeTiers(currentContext context.Context, tiers PrizeTiers) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyPrizeTiers(tiers)
+	return nil
+}
+
+func (s *MemoryStore) PrizeTiers(currentContext context.Context, eventName string) (PrizeTiers, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tiers, ok := s.prizeTiers[eventName]
+	return tiers, ok, nil
+}
+
+func (s *MemoryStore) SaveDrawing(currentContext context.Context, drawing Drawing) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyDrawing(drawing)
+	return nil
+}
+
+func (s *MemoryStore) Drawings(currentContext context.Context, eventName string) ([]Drawing, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Drawing{}, s.drawings[eventName]...), nil
+}
+
+func (s *MemoryStore) SaveLead(currentContext context.Context, lead Lead) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applyLead(lead)
+	return nil
+}
+
+func (s *MemoryStore) Leads(currentContext context.Context, eventName string) ([]Lead, error) {
+	sessions, err := s.Sessions(currentContext, eventName)
+	if err != nil {
+		return nil, err
+	}
+	leads := []Lead{}
+	for _, session := range sessions {
+		if session.Lead != nil {
+			leads = append(leads, *session.Lead)
+		}
+	}
+	s.lock.Lock()
+	for _, lead := range s.withdrawnLeads {
+		if lead.EventName == eventName {
+			leads = append(leads, lead)
+		}
+	}
+	s.lock.Unlock()
+	sort.SliceStable(leads, func(i, j int) bool { return leads[i].SubmittedAt.Before(leads[j].SubmittedAt) })
+	return leads, nil
+}
+
+func (s *MemoryStore) hasWithdrawnLead(leadId string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.withdrawnLeads[leadId]
+	return ok
+}
+
+func (s *MemoryStore) DeleteAttendee(currentContext context.Context, attendeeKeyHash string) (Deletion, error) {
+	return s.delete(attendeeSessions(attendeeKeyHash), noDrawings)
+}
+
+func (s *MemoryStore) DeleteBefore(currentContext context.Context, cutoff time.Time) (Deletion, error) {
+	return s.delete(sessionsBefore(cutoff), drawingsBefore(cutoff))
+}
+
+func (s *MemoryStore) delete(deleteSession func(*Session) bool, deleteDrawing func(Drawing) bool) (Deletion, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	plan := s.planDeletion(deleteSession, deleteDrawing)
+	s.applyDeletion(plan)
+	return plan.deletion, nil
+}
+
+/* these expect the lock to be held */
+
+func (s *MemoryStore) sessionFor(eventName string, executionId string) *Session {
+	key := sessionKey{eventName, executionId}
+	session, ok := s.sessions[key]
+	if !ok {
+		session = &Session{EventName: eventName, ExecutionId: executionId, Answers: []Answer{}, Opinions: []Opinion{}}
+		s.sessions[key] = session
+	}
+	return session
+}
+
+func (s *MemoryStore) applyAnswer(answer Answer) {
+	session := s.sessionFor(answer.EventName, answer.ExecutionId)
+	session.Answers = append(session.Answers, answer)
+	session.claim(answer.AttendeeKeyHash)
+	session.touch(answer.AnsweredAt)
+}
+
+func (s *MemoryStore) applyOpinion(opinion Opinion) {
+	session := s.sessionFor(opinion.EventName, opinion.ExecutionId)
+	session.Opinions = append(session.Opinions, opinion)
+	session.claim(opinion.AttendeeKeyHash)
+	session.touch(opinion.GivenAt)
+}
+
+func (s *MemoryStore) applyNickname(nickname Nickname) {
+	session := s.sessionFor(nickname.EventName, nickname.ExecutionId)
+	session.Nickname = nickname.Nickname
+	session.touch(nickname.SetAt)
+}
+
+func (s *MemoryStore) applySessionStart(start SessionStart) {
+	session := s.sessionFor(start.EventName, start.ExecutionId)
+	session.AttendeeKeyHash = start.AttendeeKeyHash
+	session.touch(start.StartedAt)
+}
+
+func (s *MemoryStore) applyLead(lead Lead) {
+	if withdrawn, ok := s.withdrawnLeads[lead.Id]; ok {
+		if lead.Withdrawn() && lead.Version >= withdrawn.Version {
+			s.withdrawnLeads[lead.Id] = lead // its session is gone; this is how delivery went
+		}
+		return
+	}
+	session := s.sessionFor(lead.EventName, lead.ExecutionId)
+	session.Lead = &lead
+}
+
+func (s *MemoryStore) applyPrizeTiers(tiers PrizeTiers) {
+	s.prizeTiers[tiers.EventName] = tiers
+}
+
+func (s *MemoryStore) applyDrawing(drawing Drawing) {
+	s.drawings[drawing.EventName] = append(s.drawings[drawing.EventName], drawing)
+}
+
+func (s *MemoryStore) withQuestionId(opinion Opinion) Opinion {
+	if session, ok := s.sessions[sessionKey{opinion.EventName, opinion.ExecutionId}]; ok && opinion.QuestionId == "" {
+		if answer, ok := session.answerWithEvaluationId(opinion.EvaluationId); ok {
+			opinion.QuestionId = answer.QuestionId
+		}
+	}
+	return opinion
+}
+
+// the first API key a made-up execution id is used with is whose it is, so deleting that key's data finds it.
+// An issued id already belongs to someone.
+func (session *Session) claim(attendeeKeyHash string) {
+	if session.AttendeeKeyHash == "" {
+		session.AttendeeKeyHash = attendeeKeyHash
+	}
+}
+
+func (session *Session) touch(at time.Time) {
+	if session.StartedAt.IsZero() || at.Before(session.StartedAt) {
+		session.StartedAt = at
+	}
+	if at.After(session.UpdatedAt) {
+		session.UpdatedAt = at
+	}
+}
+
+// so nobody outside the lock is looking at the same slices we append to
+func (session *Session) copy() Session {
+	copied := *session
+	copied.Answers = append([]Answer{}, session.Answers...)
+	copied.Opinions = append([]Opinion{}, session.Opinions...)
+	if session.Lead != nil {
+		lead := *session.Lead
+		copied.Lead = &lead
+	}
+	return copied
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testAnswer(executionId string, questionId string, score int, minutes int) Answer {
+	return Answer{EventName: "ev", ExecutionId: executionId, QuestionId: questionId, Answer: "we read the logs", Response: "Logs are a start.",
+		Score: score, PossibleScore: 100, EvaluationId: executionId + "-" + questionId, AnsweredAt: testTime.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func TestAnsweringAgainReplacesTheScore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.SaveAnswer(ctx, testAnswer("one", "q1", 10, 0))
+	s.SaveAnswer(ctx, testAnswer("one", "q2", 20, 1))
+	s.SaveAnswer(ctx, testAnswer("one", "q1", 30, 2))
+
+	session, found, _ := s.Session(ctx, "ev", "one")
+	if !found || len(session.Answers) != 3 {
+		t.Fatalf("expected every attempt kept: %+v", session)
+	}
+	latest := session.LatestAnswers()
+	if len(latest) != 2 || latest[0].QuestionId != "q1" || latest[0].Score != 30 || latest[1].QuestionId != "q2" {
+		t.Errorf("expected q1 at 30 then q2, got %+v", latest)
+	}
+	if score, possible := session.TotalScore(); score != 50 || possible != 200 {
+		t.Errorf("expected 50 of 200, got %d of %d", score, possible)
+	}
+	if !session.StartedAt.Equal(testTime) || !session.UpdatedAt.Equal(testTime.Add(2*time.Minute)) {
+		t.Errorf("expected it to run from the first answer to the last: %v to %v", session.StartedAt, session.UpdatedAt)
+	}
+}
+
+func TestOpinionGetsItsAnswersQuestion(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.SaveAnswer(ctx, testAnswer("one", "q2", 20, 0))
+	s.SaveOpinion(ctx, Opinion{EventName: "ev", ExecutionId: "one", EvaluationId: "one-q2", Opinion: "meh", GivenAt: testTime})
+
+	session, _, _ := s.Session(ctx, "ev", "one")
+	if len(session.Opinions) != 1 || session.Opinions[0].QuestionId != "q2" {
+		t.Errorf("expected the opinion to be about q2: %+v", session.Opinions)
+	}
+}
+
+func TestSessionsAreCopies(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.SaveAnswer(ctx, testAnswer("one", "q1", 10, 0))
+	s.SaveLead(ctx, Lead{Id: "lead-1", EventName: "ev", ExecutionId: "one", Name: "Ada", Version: 1})
+
+	sessions, _ := s.Sessions(ctx, "ev")
+	sessions[0].Answers[0].Score = 99
+	sessions[0].Lead.Name = "someone else"
+
+	session, _, _ := s.Session(ctx, "ev", "one")
+	if session.Answers[0].Score != 10 || session.Lead.Name != "Ada" {
+		t.Errorf("changing what Sessions returned changed the store")
+	}
+	if _, found, _ := s.Session(ctx, "other event", "one"); found {
+		t.Errorf("sessions are per event")
+	}
+}
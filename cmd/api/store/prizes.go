@@ -0,0 +1,36 @@
+package store
+
+import "time"
+
+// What an event gives away. Admins set these; they're kept so a drawing can say what it was drawn for.
+type PrizeTiers struct {
+	EventName string      `json:"event_name"`
+	Tiers     []PrizeTier `json:"tiers"`
+	SetAt     time.Time   `json:"set_at"`
+}
+
+type PrizeTier struct {
+	Name         string `json:"name"`
+	Prize        string `json:"prize"`
+	MinimumScore int    `json:"minimum_score"` // their total, as on the leaderboard
+	Winners      int    `json:"winners"`       // how many to draw. 0 means everyone who qualifies wins
+}
+
+// A drawing has everything it needs to be done again: the same candidates, in the same order, with the same seed, pick the same winners.
+type Drawing struct {
+	Id                 string        `json:"id"`
+	EventName          string        `json:"event_name"`
+	Tier               PrizeTier     `json:"tier"`
+	Seed               int64         `json:"seed"`
+	DrawnAt            time.Time     `json:"drawn_at"`
+	CandidateIds       []string      `json:"candidate_ids"`        // qualifying execution ids, sorted, before the draw
+	ExcludedFlaggedQty int           `json:"excluded_flagged_qty"` // qualified on score, but something flagged one of their answers
+	ExcludedWinnerQty  int           `json:"excluded_winner_qty"`  // qualified, but already won an earlier drawing
+	Winners            []PrizeWinner `json:"winners"`
+}
+
+type PrizeWinner struct {
+	ExecutionId string `json:"execution_id"`
+	Nickname    string `json:"nickname,omitempty"`
+	Score       int    `json:"score"`
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+/**
+ * What each attendee did at the booth: what they answered, what we said back, how it scored, and what they thought of it.
+ * Also what each event gives away, and who won it (prizes.go); who wants to hear from us (leads.go).
+ * Attendees can have theirs deleted (deletion.go).
+ *
+ * Everything is keyed by event and execution id (the x-observaquiz-execution-id header), which the UI makes up
+ * once per attendee. There are two of these:
+ *
+ *    MemoryStore   forgets everything when the instance goes away
+ *    FileStore     appends every change to one file, and reads it back when it opens
+ */
+
+type Store interface {
+	SaveAnswer(currentContext context.Context, answer Answer) error
+	SaveOpinion(currentContext context.Context, opinion Opinion) error
+	SetNickname(currentContext context.Context, nickname Nickname) error
+	StartSession(currentContext context.Context, start SessionStart) error
+	// found is false when that execution id has done nothing in that event
+	Session(currentContext context.Context, eventName string, executionId string) (session Session, found bool, err error)
+	Sessions(currentContext context.Context, eventName string) ([]Session, error)
+
+	SavePrizeTiers(currentContext context.Context, tiers PrizeTiers) error
+	// found is false when nobody has set them for that event
+	PrizeTiers(currentContext context.Context, eventName string) (tiers PrizeTiers, found bool, err error)
+	SaveDrawing(currentContext context.Context, drawing Drawing) error
+	Drawings(currentContext context.Context, eventName string) ([]Drawing, error) // oldest first
+
+	// SaveLead replaces the session's lead, if it had one
+	SaveLead(currentContext context.Context, lead Lead) error
+	Leads(currentContext context.Context, eventName string) ([]Lead, error) // oldest first, withdrawn ones too, and those of deleted sessions
+
+	// DeleteAttendee deletes every session started with that API key hash, in any event (deletion.go)
+	DeleteAttendee(currentContext context.Context, attendeeKeyHash string) (Deletion, error)
+	// DeleteBefore deletes sessions with nothing newer than cutoff, and drawings from before it
+	DeleteBefore(currentContext context.Context, cutoff time.Time) (Deletion, error)
+}
+
+type Session struct {
+	EventName       string    `json:"event_name"`
+	ExecutionId     string    `json:"execution_id"`
+	Nickname        string    `json:"nickname,omitempty"`          // for the leaderboard
+	AttendeeKeyHash string    `json:"attendee_key_hash,omitempty"` // who POST /api/sessions issued the id to, or else the first key to answer with it. Only they can look at it
+	StartedAt       time.Time `json:"started_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	Answers         []Answer  `json:"answers"` // every attempt, oldest first
+	Opinions        []Opinion `json:"opinions"`
+	Lead            *Lead     `json:"lead,omitempty"` // if they asked us to get in touch (leads.go)
+}
+
+type Answer struct {
+	EventName        string           `json:"event_name"`
+	ExecutionId      string           `json:"execution_id"`
+	QuestionId       string           `json:"question_id"`
+	Answer           string           `json:"answer"`
+	Response         string           `json:"response"`
+	Score            int              `json:"score"`
+	PossibleScore    int              `json:"possible_score"`
+	PartialScore     bool             `json:"partial_score,omitempty"`
+	RawScore         *int             `json:"raw_score,omitempty"`
+	RawPossibleScore *int             `json:"raw_possible_score,omitempty"`
+	EvaluationId     string           `json:"evaluation_id"`
+	Category         string           `json:"category,omitempty"`   // v2 only
+	Components       []ScoreComponent `json:"components,omitempty"` // v2 only
+	Flags            []string         `json:"flags,omitempty"`      // moderation, guard, or copy detection caught it
+	AttendeeKeyHash  string           `json:"attendee_key_hash,omitempty"`
+	AnsweredAt       time.Time        `json:"answered_at"`
+}
+
+// One piece of the score. Unlike the one in the answer response, this always has the reasoning.
+type ScoreComponent struct {
+	Description   string   `json:"description"`
+	Status        string   `json:"status"`
+	Score         int      `json:"score"`
+	MaximumScore  int      `json:"maximum_score"`
+	Confidence    string   `json:"confidence,omitempty"`
+	Reasoning     string   `json:"reasoning,omitempty"`
+	MatchedWords  []string `json:"matched_words,omitempty"`
+	PenaltyWords  []string `json:"penalty_words,omitempty"`
+	Samples       []int    `json:"samples,omitempty"`
+	LowConfidence bool     `json:"low_confidence,omitempty"`
+}
+
+type Opinion struct {
+	EventName       string    `json:"event_name"`
+	ExecutionId     string    `json:"execution_id"`
+	EvaluationId    string    `json:"evaluation_id"`
+	QuestionId      string    `json:"question_id,omitempty"` // of the answer with that evaluation id, if we have it
+	Opinion         string    `json:"opinion"`
+	AttendeeKeyHash string    `json:"attendee_key_hash,omitempty"`
+	GivenAt         time.Time `json:"given_at"`
+}
+
+type Nickname struct {
+	EventName   string    `json:"event_name"`
+	ExecutionId string    `json:"execution_id"`
+	Nickname    string    `json:"nickname"`
+	SetAt       time.Time `json:"set_at"`
+}
+
+type SessionStart struct {
+	EventName       string    `json:"event_name"`
+	ExecutionId     string    `json:"execution_id"`
+	AttendeeKeyHash string    `json:"attendee_key_hash"`
+	StartedAt       time.Time `json:"started_at"`
+}
+
+// LatestAnswers is their most recent answer to each question, in the order they first answered them.
+// Answering again replaces the earlier score.
+func (session Session) LatestAnswers() []Answer {
+	latest := []Answer{}
+	positions := map[string]int{}
+	for _, answer := range session.Answers {
+		if i, ok := positions[answer.QuestionId]; ok {
+			latest[i] = answer
+			continue
+		}
+		positions[answer.QuestionId] = len(latest)
+		latest = append(latest, answer)
+	}
+	return latest
+}
+
+func (session Session) TotalScore() (score int, possibleScore int) {
+	for _, answer := range session.LatestAnswers() {
+		score += answer.Score
+		possibleScore += answer.PossibleScore
+	}
+	return score, possibleScore
+}
+
+// Flagged is true when anything caught any of their answers
+func (session Session) Flagged() bool {
+	for _, answer := range session.Answers {
+		if len(answer.Flags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// the answer that got this evaluation id, so an opinion can say which question it was about
+func (session Session) answerWithEvaluationId(evaluationId string) (Answer, bool) {
+	for _, answer := range session.Answers {
+		if answer.EvaluationId == evaluationId {
+			return answer, true
+		}
+	}
+	return Answer{}, false
+}
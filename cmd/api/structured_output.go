@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/**
+ * The LLM says it will give us JSON in the format we asked for. It mostly does.
+ * Sometimes the score is "15" instead of 15, or 25 out of 20, or the category is one we made up on the spot.
+ *
+ * Check what comes back against a little schema. Fix what we can fix ourselves (numbers as strings,
+ * category capitalization); for anything else, tell the LLM what was wrong and let it try again, a few times.
+ */
+
+const maximumRepairAttempts = 2
+
+type jsonFieldType string
+
+const (
+	jsonString  jsonFieldType = "string"
+	jsonInteger jsonFieldType = "integer"
+	jsonBoolean jsonFieldType = "boolean"
+	jsonStrings jsonFieldType = "list of strings"
+)
+
+type jsonField struct {
+	name      string
+	fieldType jsonFieldType
+	required  bool
+	allowed   []string // for strings and lists of strings; empty means anything goes
+	minimum   *int     // for integers
+	maximum   *int
+}
+
+type jsonSchema []jsonField
+
+func intPointer(i int) *int {
+	return &i
+}
+
+func categorySchema(allowedCategories []string) jsonSchema {
+	return jsonSchema{
+		{name: "category", fieldType: jsonString, required: true, allowed: allowedCategories},
+		{name: "confidence", fieldType: jsonString},
+		{name: "reasoning", fieldType: jsonString},
+	}
+}
+
+func scoreSchema(maximumScore int) jsonSchema {
+	return jsonSchema{
+		{name: "score", fieldType: jsonInteger, required: true, minimum: intPointer(0), maximum: intPointer(maximumScore)},
+		{name: "confidence", fieldType: jsonString},
+		{name: "reasoning", fieldType: jsonString},
+	}
+}
+
+// validate checks the LLM's JSON against the schema, and returns it with the fixable bits fixed.
+func (schema jsonSchema) validate(llmOutput string) (normalized map[string]interface{}, err error) {
+	parsed := map[string]interface{}{}
+	err = json.Unmarshal([]byte(llmOutput), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("that is not a JSON object: %w", err)
+	}
+
+	problems := []string{}
+	for _, field := range schema {
+		value, present := parsed[field.name]
+		if !present || value == nil {
+			if field.required {
+				problems = append(problems, fmt.Sprintf("\"%s\" is missing", field.name))
+			}
+			continue
+		}
+		fixedValue, problem := field.check(value)
+		if problem != "" {
+			problems = append(problems, problem)
+			continue
+		}
+		parsed[field.name] = fixedValue
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return parsed, nil
+}
+
+func (field jsonField) check(value interface{}) (fixedValue interface{}, problem string) {
+	switch field.fieldType {
+	case jsonInteger:
+		var number float64
+		switch v := value.(type) {
+		case float64:
+			number = v
+		case string: // "15" is close enough to 15
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Sprintf("\"%s\" must be an integer, not %q", field.name, v)
+			}
+			number = parsed
+		default:
+			return nil, fmt.Sprintf("\"%s\" must be an integer, not %v", field.name, value)
+		}
+		integer := int(math.Round(number))
+		if field.minimum != nil && integer < *field.minimum {
+			return nil, fmt.Sprintf("\"%s\" is %d, but the minimum is %d", field.name, integer, *field.minimum)
+		}
+		if field.maximum != nil && integer > *field.maximum {
+			return nil, fmt.Sprintf("\"%s\" is %d, but the maximum is %d", field.name, integer, *field.maximum)
+		}
+		return integer, ""
+	case jsonBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, ""
+		case string: // "true" is close enough to true
+			parsed, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err == nil {
+				return parsed, ""
+			}
+		}
+		return nil, fmt.Sprintf("\"%s\" must be true or false, not %v", field.name, value)
+	case jsonStrings:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Sprintf("\"%s\" must be a list of strings", field.name)
+		}
+		fixedList := []interface{}{}
+		for _, item := range list {
+			fixedItem, problem := jsonField{name: field.name, fieldType: jsonString, allowed: field.allowed}.check(item)
+			if problem != "" {
+				return nil, problem
+			}
+			fixedList = append(fixedList, fixedItem)
+		}
+		return fixedList, ""
+	case jsonString:
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case float64, bool: // a confidence of 0.9 is fine as "0.9"
+			text = fmt.Sprintf("%v", v)
+		default:
+			return nil, fmt.Sprintf("\"%s\" must be a string", field.name)
+		}
+		if len(field.allowed) == 0 {
+			return text, ""
+		}
+		for _, allowedValue := range field.allowed {
+			if strings.EqualFold(strings.TrimSpace(text), allowedValue) {
+				return allowedValue, ""
+			}
+		}
+		return nil, fmt.Sprintf("\"%s\" is %q, but it must be one of: %s", field.name, text, strings.Join(field.allowed, ", "))
+	}
+	return value, ""
+}
+
+var errLlmUnreachable = errors.New("could not reach LLM")
+var errLlmOutputInvalid = errors.New("LLM output did not match the schema")
+
+// over budget stays over budget, because callers have a fallback for that; anything else is unreachable
+func llmSendFailure(err error) error {
+	if errors.Is(err, errOverBudget) {
+		return err
+	}
+	return errLlmUnreachable
+}
+
+// chatForValidJson asks for JSON, checks it against the schema, and asks again (with the complaint) if it's wrong.
+// On success, the normalized JSON is unmarshalled into result.
+func chatForValidJson(currentContext context.Context, llmApi *openaiApi, request chatRequest, schema jsonSchema, output *chatResult, result interface{}) error {
+	span := trace.SpanFromContext(currentContext)
+	request.wantsJson = true
+
+	err := llmApi.send(currentContext, request, output)
+	if err != nil {
+		return llmSendFailure(err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		normalized, validationErr := schema.validate(output.responseContent)
+		if validationErr == nil {
+			span.SetAttributes(attribute.Int("app.llm.repair_attempts", attempt))
+			normalizedJson, _ := json.Marshal(normalized)
+			return json.Unmarshal(normalizedJson, result)
+		}
+
+		if attempt >= maximumRepairAttempts {
+			span.SetAttributes(attribute.Int("app.llm.repair_attempts", attempt),
+				attribute.String("app.llm.validation_error", validationErr.Error()))
+			return fmt.Errorf("%w: %v", errLlmOutputInvalid, validationErr)
+		}
+
+		span.AddEvent("repair LLM output", trace.WithAttributes(
+			attribute.Int("app.llm.repair.attempt", attempt+1),
+			attribute.String("app.llm.repair.validation_error", validationErr.Error()),
+			attribute.String("app.llm.repair.invalid_output", output.responseContent)))
+
+		repairRequest := request
+		repairRequest.followUp = append(append([]openai.ChatCompletionMessage{}, request.followUp...),
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: output.responseContent},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "That response was not valid: " + validationErr.Error() + ". Respond again with only the corrected JSON."})
+		err = llmApi.send(currentContext, repairRequest, output)
+		if err != nil {
+			return llmSendFailure(err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestJsonFieldCheck(t *testing.T) {
+	score := jsonField{name: "score", fieldType: jsonInteger, minimum: intPointer(0), maximum: intPointer(20)}
+	category := jsonField{name: "category", fieldType: jsonString, allowed: []string{"Observability 1.0", "Other"}}
+	flagged := jsonField{name: "flagged", fieldType: jsonBoolean}
+	covered := jsonField{name: "covered", fieldType: jsonStrings, allowed: []string{"detect", "act"}}
+
+	tests := []struct {
+		description string
+		field       jsonField
+		value       interface{}
+		expected    interface{}
+		problem     string // part of it
+	}{
+		{"an integer", score, 15.0, 15, ""},
+		{"a number as a string", score, " 15 ", 15, ""},
+		{"rounded", score, 14.6, 15, ""},
+		{"not a number", score, "fifteen", nil, "must be an integer"},
+		{"a list for a number", score, []interface{}{15.0}, nil, "must be an integer"},
+		{"over the maximum", score, 25.0, nil, "the maximum is 20"},
+		{"under the minimum", score, -1.0, nil, "the minimum is 0"},
+		{"an allowed category", category, "other", "Other", ""},
+		{"a made-up category", category, "Observability 3.0", nil, "must be one of"},
+		{"a number as a string field", jsonField{name: "confidence", fieldType: jsonString}, 0.9, "0.9", ""},
+		{"an object for a string", category, map[string]interface{}{}, nil, "must be a string"},
+		{"a boolean", flagged, true, true, ""},
+		{"a boolean as a string", flagged, "false", false, ""},
+		{"not a boolean", flagged, "maybe", nil, "must be true or false"},
+		{"a list", covered, []interface{}{"Detect"}, []interface{}{"detect"}, ""},
+		{"not a list", covered, "detect", nil, "must be a list of strings"},
+		{"something not allowed in the list", covered, []interface{}{"detect", "nap"}, nil, "must be one of"},
+	}
+	for _, test := range tests {
+		fixed, problem := test.field.check(test.value)
+		if test.problem == "" && (problem != "" || !jsonEqual(fixed, test.expected)) {
+			t.Errorf("%s: expected %v, got %v (%s)", test.description, test.expected, fixed, problem)
+		}
+		if test.problem != "" && !strings.Contains(problem, test.problem) {
+			t.Errorf("%s: expected a problem about %q, got %q", test.description, test.problem, problem)
+		}
+	}
+}
+
+func jsonEqual(a interface{}, b interface{}) bool {
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if aIsList || bIsList {
+		if len(aList) != len(bList) {
+			return false
+		}
+		for i := range aList {
+			if aList[i] != bList[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func TestSchemaValidate(t *testing.T) {
+	schema := scoreSchema(20)
+	if _, err := schema.validate(`{"confidence": "high"}`); err == nil || !strings.Contains(err.Error(), `"score" is missing`) {
+		t.Errorf("expected the missing score, got %v", err)
+	}
+	if _, err := schema.validate(`{"score": null}`); err == nil {
+		t.Errorf("a null score is a missing score")
+	}
+	if _, err := schema.validate(`the score is 15`); err == nil || !strings.Contains(err.Error(), "not a JSON object") {
+		t.Errorf("expected it to say that's not JSON, got %v", err)
+	}
+	if _, err := schema.validate(`{"score": 25, "reasoning": {}}`); err == nil || !strings.Contains(err.Error(), "maximum") || !strings.Contains(err.Error(), "reasoning") {
+		t.Errorf("expected both problems, got %v", err)
+	}
+	normalized, err := schema.validate(`{"score": "15", "confidence": "high"}`)
+	if err != nil || normalized["score"] != 15 {
+		t.Errorf("expected the score fixed to 15, got %v %v", normalized, err)
+	}
+}
+
+// answers each request with the next of its outputs, and keeps the requests
+type scriptedLlmClient struct {
+	outputs  []string
+	requests *[]openai.ChatCompletionRequest
+}
+
+func (c scriptedLlmClient) complete(currentContext context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	*c.requests = append(*c.requests, request)
+	if len(*c.requests) > len(c.outputs) {
+		return openai.ChatCompletionResponse{}, errors.New("asked more times than the script goes")
+	}
+	output := c.outputs[len(*c.requests)-1]
+	return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: output}}}}, nil
+}
+
+func (c scriptedLlmClient) completeStreaming(currentContext context.Context, request openai.ChatCompletionRequest, onToken func(string)) (string, error) {
+	response, err := c.complete(currentContext, request)
+	if err != nil {
+		return "", err
+	}
+	return response.Choices[0].Message.Content, nil
+}
+
+func scriptedLlmApi(outputs ...string) (*openaiApi, *[]openai.ChatCompletionRequest) {
+	requests := &[]openai.ChatCompletionRequest{}
+	return &openaiApi{model: "fake", client: scriptedLlmClient{outputs: outputs, requests: requests}}, requests
+}
+
+type repairedScore struct {
+	Score     int    `json:"score"`
+	Reasoning string `json:"reasoning"`
+}
+
+func TestRepairedOnTheLastTry(t *testing.T) {
+	llmApi, requests := scriptedLlmApi(`{"score": 25}`, `I'm sorry, the score is 20`, `{"score": 20, "reasoning": "fixed"}`)
+	output, result := chatResult{}, repairedScore{}
+	err := chatForValidJson(context.Background(), llmApi, chatRequest{theirAnswer: "we read logs", promptTemplate: "score it"}, scoreSchema(20), &output, &result)
+
+	if err != nil || result.Score != 20 || result.Reasoning != "fixed" {
+		t.Fatalf("expected the third answer, got %+v %v", result, err)
+	}
+	if len(*requests) != 3 {
+		t.Fatalf("expected two repairs, got %d requests", len(*requests))
+	}
+	firstRepair := (*requests)[1].Messages
+	complaint := firstRepair[len(firstRepair)-1]
+	if firstRepair[len(firstRepair)-2].Content != `{"score": 25}` || !strings.Contains(complaint.Content, "the maximum is 20") {
+		t.Errorf("expected the repair to show the LLM its answer, and what was wrong with it: %+v", firstRepair)
+	}
+	secondRepair := (*requests)[2].Messages
+	if len(secondRepair) != len(firstRepair) || !strings.Contains(secondRepair[len(secondRepair)-1].Content, "not a JSON object") {
+		t.Errorf("expected each repair to be about the latest answer, not all of them: %+v", secondRepair)
+	}
+}
+
+func TestRepairsRunOut(t *testing.T) {
+	llmApi, requests := scriptedLlmApi(`{"score": 25}`, `{"score": 26}`, `{"score": 27}`, `{"score": 20}`)
+	output, result := chatResult{}, repairedScore{}
+	err := chatForValidJson(context.Background(), llmApi, chatRequest{theirAnswer: "we read logs", promptTemplate: "score it"}, scoreSchema(20), &output, &result)
+
+	if !errors.Is(err, errLlmOutputInvalid) || !strings.Contains(err.Error(), "27") {
+		t.Errorf("expected the last answer's problem, got %v", err)
+	}
+	if len(*requests) != 1+maximumRepairAttempts {
+		t.Errorf("expected %d repairs, then to give up; got %d requests", maximumRepairAttempts, len(*requests))
+	}
+}
+
+func TestScoringFallsBackWhenRepairsRunOut(t *testing.T) {
+	llmApi := fakeLlmApi(func(currentContext context.Context, prompt string) (string, error) {
+		if strings.HasPrefix(prompt, "score") {
+			return `{"score": 99}`, nil
+		}
+		return fakeAnswer(prompt)
+	})
+	question := fakeQuestion(1)
+	scored := scoreWithPrompt(context.Background(), llmApi, AnswerBody{Answer: "we read logs"}, map[string]string{}, question.Scoring.ScoringPrompts[0])
+	if scored.status != SCORE_STATUS_FAILED || scored.score != 0 {
+		t.Errorf("expected the scoring prompt to fail rather than give 99 of 20: %+v", scored)
+	}
+}
+
+func TestUnreachableLlmIsNotRepaired(t *testing.T) {
+	llmApi, requests := scriptedLlmApi()
+	output, result := chatResult{}, repairedScore{}
+	err := chatForValidJson(context.Background(), llmApi, chatRequest{theirAnswer: "we read logs", promptTemplate: "score it"}, scoreSchema(20), &output, &result)
+	if !errors.Is(err, errLlmUnreachable) || len(*requests) != 1 {
+		t.Errorf("expected unreachable after one try, got %v after %d", err, len(*requests))
+	}
+}
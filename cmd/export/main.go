@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"observaquiz_lambda/cmd/api/store"
+
+	"github.com/jessevdk/go-flags"
+)
+
+/**
+ * After an event, get the results out of the attendee store file (store=file) as CSV or JSONL, one row per answer.
+ * The same as GET /api/admin/export, for when the API isn't running.
+ *
+ *    go run ./cmd/export --event devopsdays_whenever --columns execution_id,question_id,category,score --redact execution_id
+ */
+
+type exportSettings struct {
+	StorePath   string `long:"store-path" default:"observaquiz_store.jsonl" env:"store_path" description:"the attendee store file the API wrote"`
+	EventName   string `long:"event" required:"true" description:"which event's results"`
+	Format      string `long:"format" default:"csv" description:"csv or jsonl"`
+	Columns     string `long:"columns" description:"which columns, in order, comma separated (default: all)"`
+	Redact      string `long:"redact" description:"columns to hide, comma separated. execution_id becomes a pseudonym"`
+	AllAttempts bool   `long:"all-attempts" description:"every answer, not just the latest to each question"`
+	Output      string `long:"output" description:"write here instead of stdout"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run is main, with what it reads and writes passed in; it returns the exit code
+func run(arguments []string, stdout io.Writer, stderr io.Writer) int {
+	settings := exportSettings{}
+	_, err := flags.ParseArgs(&settings, arguments)
+	if err != nil {
+		return 1
+	}
+
+	options := store.ExportOptions{
+		Format:      settings.Format,
+		Columns:     store.ParseColumnList(settings.Columns),
+		Redact:      store.ParseColumnList(settings.Redact),
+		AllAttempts: settings.AllAttempts,
+	}
+	if err := options.Check(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if _, err := os.Stat(settings.StorePath); err != nil {
+		fmt.Fprintf(stderr, "Could not find the attendee store at %s: %v\n", settings.StorePath, err)
+		return 1
+	}
+	attendeeStore, err := store.OpenFileStore(settings.StorePath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read the attendee store at %s: %v\n", settings.StorePath, err)
+		return 1
+	}
+	sessions, err := attendeeStore.Sessions(context.Background(), settings.EventName)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not read sessions for %s: %v\n", settings.EventName, err)
+		return 1
+	}
+
+	output := stdout
+	if settings.Output != "" {
+		file, err := os.Create(settings.Output)
+		if err != nil {
+			fmt.Fprintf(stderr, "Could not create %s: %v\n", settings.Output, err)
+			return 1
+		}
+		output = file
+	}
+	rowQty, err := store.Export(output, sessions, options)
+	if file, ok := output.(*os.File); ok && settings.Output != "" {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Export failed after %d rows: %v\n", rowQty, err)
+		return 1
+	}
+	fmt.Fprintf(stderr, "%d sessions, %d rows\n", len(sessions), rowQty)
+	return 0
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"observaquiz_lambda/cmd/api/store"
+)
+
+func writeTestStore(t *testing.T) string {
+	t.Helper()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "attendees.jsonl")
+	attendeeStore, err := store.OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	attendeeStore.SaveAnswer(ctx, store.Answer{EventName: "ev", ExecutionId: "one", QuestionId: "q1", Answer: "=cmd|' /C calc'!A0",
+		Category: "logs", Score: 10, PossibleScore: 100, EvaluationId: "one-q1", AnsweredAt: at})
+	attendeeStore.SaveAnswer(ctx, store.Answer{EventName: "another event", ExecutionId: "two", QuestionId: "q1", Answer: "not this one",
+		Score: 20, PossibleScore: 100, EvaluationId: "two-q1", AnsweredAt: at})
+	return path
+}
+
+func TestExportWritesTheEventsAnswers(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	code := run([]string{"--store-path", writeTestStore(t), "--event", "ev", "--columns", "execution_id,answer,score", "--redact", "execution_id"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("exited %d: %s", code, stderr.String())
+	}
+	rows, err := csv.NewReader(&stdout).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("expected a header and one row: %q %v", rows, err)
+	}
+	if !strings.HasPrefix(rows[1][0], "attendee-") || rows[1][1] != "'=cmd|' /C calc'!A0" || rows[1][2] != "10" {
+		t.Errorf("expected a pseudonym, the answer escaped, and the score: %q", rows[1])
+	}
+	if !strings.Contains(stderr.String(), "1 sessions, 1 rows") {
+		t.Errorf("expected a count, got %s", stderr.String())
+	}
+}
+
+func TestExportWritesToAFile(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "results.jsonl")
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"--store-path", writeTestStore(t), "--event", "ev", "--format", "jsonl", "--columns", "question_id", "--output", output}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exited %d: %s", code, stderr.String())
+	}
+	written, _ := os.ReadFile(output)
+	if strings.TrimSpace(string(written)) != `{"question_id":"q1"}` || stdout.Len() != 0 {
+		t.Errorf("expected the one line in the file, and nothing on stdout: %s / %s", written, stdout.String())
+	}
+}
+
+func TestExportSaysWhatsWrong(t *testing.T) {
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	if code := run([]string{"--store-path", writeTestStore(t), "--event", "ev", "--columns", "email"}, &stdout, &stderr); code == 0 || !strings.Contains(stderr.String(), "no column 'email'") {
+		t.Errorf("expected an unknown column turned down: %d %s", code, stderr.String())
+	}
+	stderr.Reset()
+	if code := run([]string{"--store-path", filepath.Join(t.TempDir(), "missing.jsonl"), "--event", "ev"}, &stdout, &stderr); code == 0 || !strings.Contains(stderr.String(), "Could not find") {
+		t.Errorf("expected a missing store turned down: %d %s", code, stderr.String())
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EVENT_NAME_HEADER    = "event-name"
+	ADMIN_API_KEY_HEADER = "x-observaquiz-admin-key"
+)
+
+// one of the API's score_components
+type partialScore struct {
+	Description  string `json:"description"`
+	Status       string `json:"status"`
+	Score        int    `json:"score"`
+	MaximumScore int    `json:"maximum_score"`
+}
+
+// what the API made of one sample answer, one time
+type evaluation struct {
+	category      string // empty for v1 questions, which don't categorize
+	partialScores []partialScore
+	score         int
+	possibleScore int
+	// before the event's scoring policy scaled it. The partial scores add up to this
+	rawScore         int
+	rawPossibleScore int
+	costUSD          float64
+	failure          string // empty if it worked
+	duration         time.Duration
+}
+
+type evaluator struct {
+	client    *http.Client
+	apiUrl    string
+	eventName string
+	adminKey  string
+}
+
+func newEvaluator(apiUrl string, eventName string, adminKey string) evaluator {
+	return evaluator{
+		client:    &http.Client{Timeout: 2 * time.Minute},
+		apiUrl:    strings.TrimSuffix(apiUrl, "/"),
+		eventName: eventName,
+		adminKey:  adminKey,
+	}
+}
+
+// evaluate asks the API to score the answer, without recording it anywhere.
+func (e evaluator) evaluate(s sample) evaluation {
+	start := time.Now()
+	result := e.post(s)
+	result.duration = time.Since(start)
+	return result
+}
+
+func (e evaluator) post(s sample) evaluation {
+	body, _ := json.Marshal(map[string]string{"answer": s.Answer})
+	request, err := http.NewRequest("POST", e.apiUrl+"/api/admin/questions/"+s.QuestionId+"/evaluate", bytes.NewReader(body))
+	if err != nil {
+		return evaluation{failure: err.Error()}
+	}
+	request.Header.Set("content-type", "application/json")
+	request.Header.Set(EVENT_NAME_HEADER, e.eventName)
+	request.Header.Set(ADMIN_API_KEY_HEADER, e.adminKey)
+
+	response, err := e.client.Do(request)
+	if err != nil {
+		return evaluation{failure: "could not reach the API"}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return evaluation{failure: fmt.Sprintf("status %d", response.StatusCode)}
+	}
+
+	scored := struct {
+		Score           int            `json:"score"`
+		PossibleScore   int            `json:"possible_score"`
+		RawScore        int            `json:"raw_score"`
+		RawPossible     int            `json:"raw_possible_score"`
+		Category        string         `json:"category"`
+		ScoreComponents []partialScore `json:"score_components"`
+		CostUSD         float64        `json:"cost_usd"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&scored)
+	if err != nil {
+		return evaluation{failure: "the API's response isn't JSON"}
+	}
+	return evaluation{
+		category:         scored.Category,
+		partialScores:    scored.ScoreComponents,
+		score:            scored.Score,
+		possibleScore:    scored.PossibleScore,
+		rawScore:         scored.RawScore,
+		rawPossibleScore: scored.RawPossible,
+		costUSD:          scored.CostUSD,
+	}
+}
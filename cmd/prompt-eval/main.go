@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/jessevdk/go-flags"
+)
+
+/**
+ * Before an event, find out how the prompts score a pile of sample answers. Better now than at the booth.
+ *
+ * This runs each sample answer through the real scoring, by posting it to the admin evaluate endpoint
+ * of a running API (run_mode=server). That scores it the way the booth would, but doesn't record it:
+ * nothing lands in the attendee store, on the leaderboard, or in the event's LLM budget.
+ * Run the API with llm_mode=record to keep what the LLM said, or replay to compare
+ * a scoring change against the same LLM output.
+ *
+ *    ADMIN_API_KEY=... go run ./cmd/prompt-eval --event devopsdays_whenever --answers cmd/prompt-eval/samples.example.jsonl --repeat 3
+ */
+
+var settings struct {
+	ApiUrl      string `long:"api" default:"http://localhost:8080" description:"a running API, with run_mode=server"`
+	EventName   string `long:"event" default:"devopsdays_whenever" description:"which question set"`
+	Answers     string `long:"answers" required:"true" description:"sample answers, .jsonl or .csv"`
+	Concurrency int    `long:"concurrency" default:"4" description:"how many answers to score at once"`
+	Repeat      int    `long:"repeat" default:"1" description:"score each answer this many times, to see how much scores vary"`
+	AdminKey    string `long:"admin-key" env:"ADMIN_API_KEY" required:"true" description:"the API's admin_api_key"`
+}
+
+func main() {
+	_, err := flags.Parse(&settings)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	samples, err := readSamples(settings.Answers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read sample answers from %s: %v\n", settings.Answers, err)
+		os.Exit(1)
+	}
+	if settings.Concurrency < 1 {
+		settings.Concurrency = 1
+	}
+	if settings.Repeat < 1 {
+		settings.Repeat = 1
+	}
+
+	evaluator := newEvaluator(settings.ApiUrl, settings.EventName, settings.AdminKey)
+	results := runAll(evaluator, samples, settings.Repeat, settings.Concurrency)
+
+	report := summarize(samples, results)
+	report.print(os.Stdout)
+	if report.failures > 0 {
+		os.Exit(2)
+	}
+}
+
+// runAll scores every sample settings.Repeat times, no more than `concurrency` at once.
+// The results come back in the same order as the samples, each with `repeat` runs.
+func runAll(evaluator evaluator, samples []sample, repeat int, concurrency int) [][]evaluation {
+	results := make([][]evaluation, len(samples))
+	for i := range results {
+		results[i] = make([]evaluation, repeat)
+	}
+
+	type job struct{ sampleIndex, run int }
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j.sampleIndex][j.run] = evaluator.evaluate(samples[j.sampleIndex])
+				fmt.Fprint(os.Stderr, ".")
+			}
+		}()
+	}
+	for i := range samples {
+		for run := 0; run < repeat; run++ {
+			jobs <- job{i, run}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	fmt.Fprintln(os.Stderr)
+	return results
+}
+
+func describe(s sample) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	answer := strings.ReplaceAll(s.Answer, "\n", " ")
+	if len(answer) > 50 {
+		answer = answer[:47] + "..."
+	}
+	return answer
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type report struct {
+	questions []*questionReport
+	failures  int
+	reasons   map[string]int // failure reason -> how many times
+	costUSD   float64        // what all the LLM calls cost
+}
+
+type questionReport struct {
+	questionId  string
+	runs        int
+	scores      []float64
+	possible    int
+	rawScores   []float64 // before the event's scoring policy scaled them
+	rawPossible int
+	inRange     int
+	checked     int                       // runs that had a score range to check
+	confusion   map[string]map[string]int // expected category -> what we got -> count
+	prompts     map[string]*promptReport
+	promptList  []string // in the order we saw them
+	mismatches  []string
+}
+
+// one scoring prompt (or pointy words), across all the runs
+type promptReport struct {
+	scores          []float64
+	possible        int
+	perSampleSpread []float64 // standard deviation across repeats of the same answer
+}
+
+func summarize(samples []sample, results [][]evaluation) report {
+	r := report{reasons: map[string]int{}}
+	byQuestion := map[string]*questionReport{}
+	for i, s := range samples {
+		q, ok := byQuestion[s.QuestionId]
+		if !ok {
+			q = &questionReport{questionId: s.QuestionId, confusion: map[string]map[string]int{}, prompts: map[string]*promptReport{}}
+			byQuestion[s.QuestionId] = q
+			r.questions = append(r.questions, q)
+		}
+
+		perPromptThisSample := map[string][]float64{}
+		for _, run := range results[i] {
+			q.runs++
+			if run.failure != "" {
+				r.failures++
+				r.reasons[run.failure]++
+				continue
+			}
+			q.scores = append(q.scores, float64(run.score))
+			q.possible = run.possibleScore
+			q.rawScores = append(q.rawScores, float64(run.rawScore))
+			q.rawPossible = run.rawPossibleScore
+			r.costUSD += run.costUSD
+
+			if s.MinimumScore != nil || s.MaximumScore != nil {
+				q.checked++
+				if (s.MinimumScore == nil || run.score >= *s.MinimumScore) && (s.MaximumScore == nil || run.score <= *s.MaximumScore) {
+					q.inRange++
+				} else {
+					r.failures++
+					r.reasons["score out of expected range"]++
+					q.mismatches = append(q.mismatches, fmt.Sprintf("%s: scored %d, expected %s", describe(s), run.score, describeRange(s)))
+				}
+			}
+
+			if s.ExpectedCategory != "" {
+				got := run.category
+				if got == "" {
+					got = "(none)"
+				}
+				if q.confusion[s.ExpectedCategory] == nil {
+					q.confusion[s.ExpectedCategory] = map[string]int{}
+				}
+				q.confusion[s.ExpectedCategory][got]++
+				if !strings.EqualFold(got, s.ExpectedCategory) {
+					r.failures++
+					r.reasons["unexpected category"]++
+					q.mismatches = append(q.mismatches, fmt.Sprintf("%s: categorized as %q, expected %q", describe(s), got, s.ExpectedCategory))
+				}
+			}
+
+			for _, partial := range run.partialScores {
+				if partial.Status != "scored" {
+					continue // a failed prompt's 0 isn't a score
+				}
+				prompt, ok := q.prompts[partial.Description]
+				if !ok {
+					prompt = &promptReport{}
+					q.prompts[partial.Description] = prompt
+					q.promptList = append(q.promptList, partial.Description)
+				}
+				prompt.scores = append(prompt.scores, float64(partial.Score))
+				prompt.possible = partial.MaximumScore
+				perPromptThisSample[partial.Description] = append(perPromptThisSample[partial.Description], float64(partial.Score))
+			}
+		}
+		for description, scores := range perPromptThisSample {
+			if len(scores) > 1 {
+				q.prompts[description].perSampleSpread = append(q.prompts[description].perSampleSpread, standardDeviation(scores))
+			}
+		}
+	}
+	return r
+}
+
+func describeRange(s sample) string {
+	switch {
+	case s.MinimumScore != nil && s.MaximumScore != nil:
+		return fmt.Sprintf("%d to %d", *s.MinimumScore, *s.MaximumScore)
+	case s.MinimumScore != nil:
+		return fmt.Sprintf("at least %d", *s.MinimumScore)
+	default:
+		return fmt.Sprintf("at most %d", *s.MaximumScore)
+	}
+}
+
+func (r report) print(out io.Writer) {
+	for _, q := range r.questions {
+		fmt.Fprintf(out, "\n== Question %s: %d runs\n", q.questionId, q.runs)
+
+		if len(q.scores) > 0 {
+			sorted := append([]float64{}, q.scores...)
+			sort.Float64s(sorted)
+			fmt.Fprintf(out, "Score (out of %d): min %.0f  median %.0f  mean %.1f  max %.0f  std dev %.1f\n",
+				q.possible, sorted[0], sorted[len(sorted)/2], mean(sorted), sorted[len(sorted)-1], standardDeviation(sorted))
+			printHistogram(out, sorted, q.possible)
+		}
+		if len(q.rawScores) > 0 && q.rawPossible != q.possible {
+			// the partial scores below add up to this, not to the scaled score
+			fmt.Fprintf(out, "Raw score (out of %d, before scaling): mean %.1f  std dev %.1f\n", q.rawPossible, mean(q.rawScores), standardDeviation(q.rawScores))
+		}
+		if q.checked > 0 {
+			fmt.Fprintf(out, "In the expected range: %d of %d\n", q.inRange, q.checked)
+		}
+
+		if len(q.promptList) > 0 {
+			fmt.Fprintln(out, "\nPer scoring prompt:")
+			table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "  prompt\tout of\tmean\tstd dev\tspread on the same answer")
+			for _, description := range q.promptList {
+				prompt := q.prompts[description]
+				spread := "-"
+				if len(prompt.perSampleSpread) > 0 {
+					spread = fmt.Sprintf("%.1f", mean(prompt.perSampleSpread))
+				}
+				fmt.Fprintf(table, "  %s\t%d\t%.1f\t%.1f\t%s\n", description, prompt.possible, mean(prompt.scores), standardDeviation(prompt.scores), spread)
+			}
+			table.Flush()
+		}
+
+		if len(q.confusion) > 0 {
+			printConfusion(out, q.confusion)
+		}
+
+		if len(q.mismatches) > 0 {
+			fmt.Fprintln(out, "\nNot what we expected:")
+			for _, mismatch := range q.mismatches {
+				fmt.Fprintln(out, "  "+mismatch)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "\n== LLM cost: $%.4f\n", r.costUSD)
+	fmt.Fprintf(out, "\n== %d failures\n", r.failures)
+	reasons := []string{}
+	for reason := range r.reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return r.reasons[reasons[i]] > r.reasons[reasons[j]] })
+	for _, reason := range reasons {
+		fmt.Fprintf(out, "  %4d  %s\n", r.reasons[reason], reason)
+	}
+}
+
+// five buckets from 0 to the possible score
+func printHistogram(out io.Writer, sorted []float64, possible int) {
+	if possible <= 0 {
+		return
+	}
+	const buckets = 5
+	counts := make([]int, buckets)
+	for _, score := range sorted {
+		bucket := int(score * buckets / float64(possible))
+		if bucket >= buckets {
+			bucket = buckets - 1
+		}
+		if bucket < 0 {
+			bucket = 0
+		}
+		counts[bucket]++
+	}
+	for i, count := range counts {
+		from := possible * i / buckets
+		to := possible * (i + 1) / buckets
+		fmt.Fprintf(out, "  %3d-%-3d %s %d\n", from, to, strings.Repeat("#", count), count)
+	}
+}
+
+// rows are what we expected, columns are what the LLM said
+func printConfusion(out io.Writer, confusion map[string]map[string]int) {
+	expected := []string{}
+	actualSet := map[string]bool{}
+	for category, got := range confusion {
+		expected = append(expected, category)
+		actualSet[category] = true
+		for actual := range got {
+			actualSet[actual] = true
+		}
+	}
+	sort.Strings(expected)
+	actual := []string{}
+	for category := range actualSet {
+		actual = append(actual, category)
+	}
+	sort.Strings(actual)
+
+	fmt.Fprintln(out, "\nCategories (rows: expected, columns: what the LLM said):")
+	table := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(table, "\t")
+	for _, category := range actual {
+		fmt.Fprintf(table, "%s\t", category)
+	}
+	fmt.Fprintln(table)
+	for _, row := range expected {
+		fmt.Fprintf(table, "%s\t", row)
+		for _, column := range actual {
+			fmt.Fprintf(table, "%d\t", confusion[row][column])
+		}
+		fmt.Fprintln(table)
+	}
+	table.Flush()
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+func standardDeviation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	average := mean(values)
+	total := 0.0
+	for _, v := range values {
+		total += (v - average) * (v - average)
+	}
+	return math.Sqrt(total / float64(len(values)))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// One sample answer, and what we expect the prompts to make of it.
+// Leave out the expectations you don't have an opinion about.
+type sample struct {
+	Name             string `json:"name"` // optional, for the report
+	QuestionId       string `json:"question_id"`
+	Answer           string `json:"answer"`
+	ExpectedCategory string `json:"expected_category"`
+	MinimumScore     *int   `json:"min_score"`
+	MaximumScore     *int   `json:"max_score"`
+}
+
+func readSamples(path string) ([]sample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []sample
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		samples, err = readCsvSamples(file)
+	case ".jsonl", ".ndjson":
+		samples, err = readJsonlSamples(file)
+	default:
+		return nil, fmt.Errorf("expected a .jsonl or .csv file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range samples {
+		if s.QuestionId == "" || strings.TrimSpace(s.Answer) == "" {
+			return nil, fmt.Errorf("sample %d needs a question_id and an answer", i+1)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples")
+	}
+	return samples, nil
+}
+
+func readJsonlSamples(file io.Reader) ([]sample, error) {
+	samples := []sample{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		s := sample{}
+		err := json.Unmarshal([]byte(line), &s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+// The CSV needs a header row, with the same column names as the JSON: question_id, answer, and optionally
+// name, expected_category, min_score, max_score.
+func readCsvSamples(file io.Reader) ([]sample, error) {
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	cell := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	score := func(row []string, name string, rowNumber int) (*int, error) {
+		text := cell(row, name)
+		if text == "" {
+			return nil, nil
+		}
+		value, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %s should be a number, not %q", rowNumber, name, text)
+		}
+		return &value, nil
+	}
+
+	samples := []sample{}
+	for i, row := range rows[1:] {
+		s := sample{
+			Name:             cell(row, "name"),
+			QuestionId:       cell(row, "question_id"),
+			Answer:           cell(row, "answer"),
+			ExpectedCategory: cell(row, "expected_category"),
+		}
+		s.MinimumScore, err = score(row, "min_score", i+2)
+		if err != nil {
+			return nil, err
+		}
+		s.MaximumScore, err = score(row, "max_score", i+2)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
@@ -1,6 +1,7 @@
 package instrumentation
 
 import (
+	"encoding/json"
 	"fmt"
 	"runtime/debug"
 
@@ -10,8 +11,14 @@ import (
 	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// ErrorResponse is { "error": message }, with whatever quotes or newlines are in the message escaped.
 func ErrorResponse(message string, statusCode int) events.APIGatewayV2HTTPResponse {
-	return events.APIGatewayV2HTTPResponse{Body: fmt.Sprintf("{ \"error\": \"%s\" }", message), StatusCode: statusCode}
+	body, _ := json.Marshal(errorBody{Error: message})
+	return events.APIGatewayV2HTTPResponse{Body: string(body), StatusCode: statusCode}
 }
 
 func RespondToPanic(span oteltrace.Span, r interface{}) events.APIGatewayV2HTTPResponse {
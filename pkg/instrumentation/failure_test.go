@@ -0,0 +1,26 @@
+package instrumentation
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestErrorResponseIsJsonWhateverTheMessage(t *testing.T) {
+	message := "No prize tier called \"grand \\ prize\"\nin devopsdays"
+	response := ErrorResponse(message, 404)
+
+	body := map[string]string{}
+	err := json.Unmarshal([]byte(response.Body), &body)
+	if err != nil {
+		t.Fatalf("not JSON: %v: %s", err, response.Body)
+	}
+	if body["error"] != message || response.StatusCode != 404 {
+		t.Errorf("expected the message back with 404, got %q with %d", body["error"], response.StatusCode)
+	}
+}
+
+func TestErrorResponseBody(t *testing.T) {
+	if response := ErrorResponse("Couldn't find event name nowhere", 404); response.Body != `{"error":"Couldn't find event name nowhere"}` {
+		t.Errorf("unexpected body: %s", response.Body)
+	}
+}